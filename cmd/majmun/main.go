@@ -36,6 +36,9 @@ func main() {
 	stop := make(chan os.Signal, 1)
 	signal.Notify(stop, os.Interrupt, syscall.SIGTERM)
 
+	reload := make(chan os.Signal, 1)
+	signal.Notify(reload, syscall.SIGHUP)
+
 	go func() {
 		if err := s.Start(); err != nil {
 			logging.Error(ctx, err, "server error")
@@ -43,9 +46,33 @@ func main() {
 		}
 	}()
 
-	<-stop
-	if err := s.Stop(); err != nil {
-		logging.Error(ctx, err, "error during shutdown")
-		os.Exit(1)
+	for {
+		select {
+		case <-reload:
+			reloadConfig(ctx, s, *configPath)
+		case <-stop:
+			if err := s.Stop(); err != nil {
+				logging.Error(ctx, err, "error during shutdown")
+				os.Exit(1)
+			}
+			return
+		}
+	}
+}
+
+func reloadConfig(ctx context.Context, s *server.Server, configPath string) {
+	logging.Info(ctx, "reloading config", "config_path", configPath)
+
+	c, err := config.Load(configPath)
+	if err != nil {
+		logging.Error(ctx, err, "failed to reload config, keeping current one")
+		return
+	}
+
+	if err := s.Reload(c); err != nil {
+		logging.Error(ctx, err, "failed to apply config, keeping current one")
+		return
 	}
+
+	logging.SetLevelAndFormat(c.Logs.Level, c.Logs.Format)
 }
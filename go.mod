@@ -6,7 +6,6 @@ require (
 	github.com/Masterminds/sprig/v3 v3.3.0
 	github.com/prometheus/client_golang v1.23.0
 	github.com/stretchr/testify v1.10.0
-	golang.org/x/sync v0.13.0
 	gopkg.in/yaml.v3 v3.0.1
 )
 
@@ -0,0 +1,162 @@
+package app
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"majmun/internal/config"
+	"majmun/internal/demux"
+	"majmun/internal/metrics"
+	"majmun/internal/shell"
+	"majmun/internal/utils"
+	"strings"
+	"sync"
+	"text/template"
+)
+
+// Account is a set of provider credentials shared by every client of a playlist.
+type Account struct {
+	name      string
+	url       *template.Template
+	vars      map[string]string
+	semaphore *utils.Semaphore
+}
+
+func (a *Account) Name() string {
+	return a.name
+}
+
+func (a *Account) Semaphore() *utils.Semaphore {
+	return a.semaphore
+}
+
+// AccountPool hands out the accounts of a playlist, in the configured order, to the streams that start.
+type AccountPool struct {
+	accounts []*Account
+}
+
+func NewAccountPool(playlistName string, accounts []config.Account) *AccountPool {
+	if len(accounts) == 0 {
+		return nil
+	}
+
+	pool := &AccountPool{accounts: make([]*Account, 0, len(accounts))}
+	for _, conf := range accounts {
+		account := &Account{
+			name: conf.Name,
+			vars: make(map[string]string, len(conf.TemplateVars)),
+			semaphore: utils.NewSemaphore(
+				metrics.SemaphoreLevelAccount, playlistName+"/"+conf.Name, conf.ConcurrentStreams),
+		}
+		if conf.URL != nil {
+			account.url = conf.URL.ToTemplate()
+		}
+		for _, v := range conf.TemplateVars {
+			account.vars[v.Name] = v.Value
+		}
+		pool.accounts = append(pool.accounts, account)
+	}
+
+	// Every account gets the variables of the others, so templates render them as empty when unset.
+	for _, account := range pool.accounts {
+		for _, other := range pool.accounts {
+			for name := range other.vars {
+				if _, ok := account.vars[name]; !ok {
+					account.vars[name] = ""
+				}
+			}
+		}
+	}
+
+	return pool
+}
+
+func (p *AccountPool) Accounts() []*Account {
+	return p.accounts
+}
+
+// acquire returns the first account with a free slot, or nil when all of them are busy.
+func (p *AccountPool) acquire() *Account {
+	for _, account := range p.accounts {
+		if account.semaphore.TryAcquire() {
+			return account
+		}
+	}
+	return nil
+}
+
+// accountStreamer runs the stream with the credentials of the account it holds,
+// rendering the upstream URL and the command for that account.
+type accountStreamer struct {
+	pool  *AccountPool
+	vars  StreamVars
+	build func(StreamVars) demux.Streamer
+
+	mu      sync.Mutex
+	account *Account
+}
+
+func (s *accountStreamer) AcquireAccount() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.account == nil {
+		s.account = s.pool.acquire()
+	}
+	return s.account != nil
+}
+
+func (s *accountStreamer) ReleaseAccount() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.account != nil {
+		s.account.semaphore.Release()
+		s.account = nil
+	}
+}
+
+func (s *accountStreamer) Stream(ctx context.Context, w io.Writer) (int64, error) {
+	s.mu.Lock()
+	account := s.account
+	s.mu.Unlock()
+
+	if account == nil {
+		return 0, demux.ErrNoFreeAccount
+	}
+
+	vars, err := s.vars.withAccount(account)
+	if err != nil {
+		return 0, err
+	}
+	return s.build(vars).Stream(ctx, w)
+}
+
+// Command renders the stream command without account details, so streams of the same channel
+// are shared no matter which account runs them.
+func (s *accountStreamer) Command() ([]string, error) {
+	cmd, ok := s.build(s.vars).(*shell.Streamer)
+	if !ok {
+		return nil, fmt.Errorf("stream is not run by a command")
+	}
+	return cmd.Command()
+}
+
+// withAccount returns the variables with the account details added and the upstream URL rendered for the account.
+func (v StreamVars) withAccount(account *Account) (StreamVars, error) {
+	v.AccountName = account.name
+	v.AccountVars = account.vars
+
+	if account.url == nil {
+		return v, nil
+	}
+
+	var buf bytes.Buffer
+	if err := account.url.Execute(&buf, v.templateVars()); err != nil {
+		return v, fmt.Errorf("render url of account %s: %w", account.name, err)
+	}
+	v.URL = strings.TrimSpace(buf.String())
+
+	return v, nil
+}
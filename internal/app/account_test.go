@@ -0,0 +1,70 @@
+package app
+
+import (
+	"majmun/internal/config"
+	"majmun/internal/config/common"
+	"testing"
+
+	"gopkg.in/yaml.v3"
+)
+
+func TestAccountPoolAcquire(t *testing.T) {
+	pool := NewAccountPool("provider", []config.Account{
+		{Name: "first", ConcurrentStreams: 1},
+		{Name: "second", ConcurrentStreams: 1},
+	})
+
+	if a := pool.acquire(); a == nil || a.Name() != "first" {
+		t.Fatalf("acquire() = %v, expected first", a)
+	}
+	if a := pool.acquire(); a == nil || a.Name() != "second" {
+		t.Fatalf("acquire() = %v, expected second", a)
+	}
+	if a := pool.acquire(); a != nil {
+		t.Fatalf("acquire() = %v, expected no free account", a.Name())
+	}
+
+	pool.Accounts()[1].Semaphore().Release()
+	if a := pool.acquire(); a == nil || a.Name() != "second" {
+		t.Fatalf("acquire() = %v, expected released second", a)
+	}
+}
+
+func TestStreamVarsWithAccount(t *testing.T) {
+	var tmpl common.Template
+	if err := yaml.Unmarshal([]byte(`'{{ .url | replace "main" .username }}'`), &tmpl); err != nil {
+		t.Fatalf("failed to parse template: %v", err)
+	}
+
+	pool := NewAccountPool("provider", []config.Account{
+		{Name: "main", ConcurrentStreams: 1},
+		{
+			Name:              "second",
+			URL:               &tmpl,
+			TemplateVars:      []common.NameValue{{Name: "username", Value: "backup"}},
+			ConcurrentStreams: 1,
+		},
+	})
+
+	vars, err := StreamVars{URL: "http://provider.com/live/main/1.ts"}.withAccount(pool.Accounts()[1])
+	if err != nil {
+		t.Fatalf("withAccount() error = %v", err)
+	}
+
+	if vars.URL != "http://provider.com/live/backup/1.ts" {
+		t.Errorf("URL = %q, expected the account URL", vars.URL)
+	}
+
+	tv := vars.templateVars()
+	if tv["account_name"] != "second" || tv["username"] != "backup" {
+		t.Errorf("templateVars() = %v, expected account variables", tv)
+	}
+
+	vars, err = StreamVars{URL: "http://provider.com/live/main/1.ts"}.withAccount(pool.Accounts()[0])
+	if err != nil {
+		t.Fatalf("withAccount() error = %v", err)
+	}
+	if tv := vars.templateVars(); tv["username"] != "" {
+		t.Errorf("username = %v, expected empty for an account without it", tv["username"])
+	}
+}
@@ -4,37 +4,88 @@ import (
 	"context"
 	"fmt"
 	"majmun/internal/config"
+	"majmun/internal/config/proxy"
+	"majmun/internal/httpstream"
 	"majmun/internal/logging"
 	"majmun/internal/metrics"
 	"majmun/internal/urlgen"
+	"majmun/internal/utils"
+	"net/http"
+	"strings"
 	"time"
-
-	"golang.org/x/sync/semaphore"
 )
 
 type Manager struct {
 	config         *config.Config
-	semaphore      *semaphore.Weighted
+	semaphore      *utils.Semaphore
+	accounts       map[string]*AccountPool
+	groups         map[string]*utils.Semaphore
 	clients        []*Client
 	secretToClient map[string]*Client
 	publicURLBase  string
+	httpClient     *http.Client
+
+	// semaphores holds every semaphore by key, so the manager built on reload can carry them over.
+	semaphores map[string]*utils.Semaphore
+	previous   map[string]*utils.Semaphore
+	carried    []carriedSemaphore
+}
+
+// carriedSemaphore is a semaphore of the previous manager that takes the settings of its replacement
+// once the new manager is built.
+type carriedSemaphore struct {
+	semaphore *utils.Semaphore
+	settings  *utils.Semaphore
 }
 
-func NewManager(cfg *config.Config) (*Manager, error) {
+// NewManager builds the clients and limits of the configuration. On reload, prev is the manager in use: its
+// semaphores are kept and resized, so the streams it runs keep counting against the limits of the new one.
+func NewManager(cfg *config.Config, prev *Manager) (*Manager, error) {
 	m := &Manager{
 		config:         cfg,
 		secretToClient: make(map[string]*Client),
+		accounts:       make(map[string]*AccountPool),
+		groups:         make(map[string]*utils.Semaphore),
 		publicURLBase:  cfg.Server.PublicURL.String(),
+		httpClient:     httpstream.NewHTTPClient(cfg.Cache.HttpHeaders),
+		semaphores:     make(map[string]*utils.Semaphore),
+	}
+	if prev != nil {
+		m.previous = prev.semaphores
 	}
 
 	if cfg.Proxy.Enabled != nil && *cfg.Proxy.Enabled && cfg.Proxy.ConcurrentStreams > 0 {
-		m.semaphore = semaphore.NewWeighted(cfg.Proxy.ConcurrentStreams)
+		m.semaphore = m.carryOver(metrics.SemaphoreLevelGlobal, withQueue(utils.NewSemaphore(
+			metrics.SemaphoreLevelGlobal, metrics.SemaphoreLevelGlobal, cfg.Proxy.ConcurrentStreams), cfg.Proxy.Queue))
+	}
+
+	// Accounts are shared by all clients, since the provider limits the credentials, not the client.
+	for _, playlist := range cfg.Playlists {
+		if pool := NewAccountPool(playlist.Name, playlist.Accounts); pool != nil {
+			for _, account := range pool.accounts {
+				account.semaphore = m.carryOver(
+					semaphoreKey(metrics.SemaphoreLevelAccount, playlist.Name, account.name), account.semaphore)
+			}
+			m.accounts[playlist.Name] = pool
+		}
+	}
+
+	// Concurrency groups limit upstream streams of the matched channels across all clients and playlists.
+	for _, group := range cfg.ConcurrencyGroups {
+		m.groups[group.Name] = m.carryOver(semaphoreKey(metrics.SemaphoreLevelChannel, group.Name), withQueue(
+			utils.NewSemaphore(metrics.SemaphoreLevelChannel, group.Name, group.ConcurrentStreams), cfg.Proxy.Queue))
 	}
 
 	if err := m.initClients(); err != nil {
 		return nil, err
 	}
 
+	// The semaphores in use change only once the new configuration is known to be valid.
+	for _, c := range m.carried {
+		c.semaphore.Reconfigure(c.settings)
+	}
+	m.previous, m.carried = nil, nil
+
 	return m, nil
 }
 
@@ -46,10 +97,25 @@ func (m *Manager) Clients() []*Client {
 	return m.clients
 }
 
-func (m *Manager) Semaphore() *semaphore.Weighted {
+func (m *Manager) Semaphore() *utils.Semaphore {
 	return m.semaphore
 }
 
+// ConcurrencyGroup returns the semaphore of the named concurrency group, or nil if there is none.
+func (m *Manager) ConcurrencyGroup(name string) *utils.Semaphore {
+	return m.groups[name]
+}
+
+// ConcurrencyGroups returns the semaphores of the concurrency groups by name.
+func (m *Manager) ConcurrencyGroups() map[string]*utils.Semaphore {
+	return m.groups
+}
+
+// AccountPools returns the provider accounts by playlist name.
+func (m *Manager) AccountPools() map[string]*AccountPool {
+	return m.accounts
+}
+
 func (m *Manager) initClients() error {
 	m.clients = make([]*Client, 0, len(m.config.Clients))
 
@@ -79,6 +145,10 @@ func (m *Manager) createClient(clientConf config.Client) (*Client, error) {
 		return nil, fmt.Errorf(
 			"failed to initialize client %s: %w", clientConf.Name, err)
 	}
+	cl.semaphore = m.carryOver(semaphoreKey(metrics.SemaphoreLevelClient, clientConf.Name),
+		withQueue(cl.Semaphore(), m.config.Proxy.Queue, clientConf.Proxy.Queue))
+	clientProxy := mergeProxies(m.config.Proxy, clientConf.Proxy)
+	cl.preempt = clientProxy.IsPreemptEnabled()
 
 	if err := m.initClientProviders(cl, clientConf.Playlists, clientConf.EPGs); err != nil {
 		return nil, fmt.Errorf(
@@ -129,15 +199,17 @@ func (m *Manager) initClientProviders(cl *Client, playlistNames, epgNames []stri
 }
 
 func (m *Manager) addPlaylistProvider(cl *Client, playlistConf config.Playlist) error {
-	var sem *semaphore.Weighted
+	var sem *utils.Semaphore
 	if playlistConf.Proxy.ConcurrentStreams > 0 {
-		sem = semaphore.NewWeighted(playlistConf.Proxy.ConcurrentStreams)
+		sem = m.carryOver(semaphoreKey(metrics.SemaphoreLevelPlaylist, cl.name, playlistConf.Name), withQueue(
+			utils.NewSemaphore(metrics.SemaphoreLevelPlaylist, playlistConf.Name, playlistConf.Proxy.ConcurrentStreams),
+			m.config.Proxy.Queue, playlistConf.Proxy.Queue))
 	}
 
-	metrics.SetPlaylistStreamsActive(playlistConf.Name, 0)
+	metrics.InitPlaylistStreamsActive(playlistConf.Name)
 
 	if err := cl.BuildPlaylistProvider(
-		playlistConf, m.config.Proxy, sem); err != nil {
+		playlistConf, m.config.Proxy, m.config.ProxyProfiles, sem, m.accounts[playlistConf.Name], m.httpClient); err != nil {
 		return fmt.Errorf(
 			"failed to build playlist subscription '%s' for client '%s': %w",
 			playlistConf.Name, cl.name, err)
@@ -173,6 +245,38 @@ func (m *Manager) findEPG(name string) (config.EPG, error) {
 	return config.EPG{}, fmt.Errorf("EPG not found: %s", name)
 }
 
+// carryOver returns the semaphore of the previous manager with the same key, which takes the size and queue
+// of sem once the manager is built, so the slots held by running streams stay counted. Without one, sem is used.
+func (m *Manager) carryOver(key string, sem *utils.Semaphore) *utils.Semaphore {
+	if sem == nil {
+		return nil
+	}
+
+	if prev, ok := m.previous[key]; ok {
+		m.carried = append(m.carried, carriedSemaphore{semaphore: prev, settings: sem})
+		sem = prev
+	}
+	m.semaphores[key] = sem
+	return sem
+}
+
+func semaphoreKey(level string, names ...string) string {
+	return strings.Join(append([]string{level}, names...), "/")
+}
+
+// withQueue sets up the queue of a semaphore with the server settings overridden by the ones of its level.
+func withQueue(sem *utils.Semaphore, queues ...proxy.Queue) *utils.Semaphore {
+	if sem == nil {
+		return nil
+	}
+
+	queue := proxy.Queue{}
+	for _, q := range queues {
+		queue = mergeQueue(queue, q)
+	}
+	return sem.WithQueue(time.Duration(queue.Timeout), queue.Order)
+}
+
 func (m *Manager) createURLGenerator(clientSecret string) (*urlgen.Generator, error) {
 	secretKey := m.config.URLGenerator.Secret + clientSecret
 
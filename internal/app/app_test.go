@@ -0,0 +1,101 @@
+package app
+
+import (
+	"fmt"
+	"majmun/internal/config"
+	"majmun/internal/utils"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func loadConfig(t *testing.T, content string) *config.Config {
+	t.Helper()
+
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatalf("failed to write config: %v", err)
+	}
+	cfg, err := config.Load(path)
+	if err != nil {
+		t.Fatalf("failed to load config: %v", err)
+	}
+	return cfg
+}
+
+func managerConfig(t *testing.T, concurrentStreams int) *config.Config {
+	t.Helper()
+
+	return loadConfig(t, fmt.Sprintf(`server:
+  listen_addr: ":8080"
+  public_url: "http://example.com"
+url_generator:
+  secret: "test-secret"
+proxy:
+  enabled: true
+  concurrency: %[1]d
+playlists:
+  - name: provider
+    sources: ["http://provider.com/playlist.m3u"]
+    proxy:
+      concurrency: %[1]d
+    accounts:
+      - name: main
+        concurrency: %[1]d
+concurrency_groups:
+  - name: sports
+    concurrency: %[1]d
+clients:
+  - name: living-room
+    secret: "client-secret"
+    proxy:
+      concurrency: %[1]d`, concurrentStreams))
+}
+
+func TestNewManagerKeepsSlotsOnReload(t *testing.T) {
+	for _, size := range []int{1, 2} {
+		prev, err := NewManager(managerConfig(t, 1), nil)
+		if err != nil {
+			t.Fatalf("NewManager() error = %v", err)
+		}
+		prevClient := prev.Client("client-secret")
+
+		held := map[string]*utils.Semaphore{
+			"global":   prev.Semaphore(),
+			"client":   prevClient.Semaphore(),
+			"playlist": prevClient.Playlists()[0].Semaphore(),
+			"account":  prev.AccountPools()["provider"].Accounts()[0].Semaphore(),
+			"group":    prev.ConcurrencyGroup("sports"),
+		}
+		for name, sem := range held {
+			if !sem.TryAcquire() {
+				t.Fatalf("%s: TryAcquire() = false on a free semaphore", name)
+			}
+		}
+
+		m, err := NewManager(managerConfig(t, size), prev)
+		if err != nil {
+			t.Fatalf("NewManager() error = %v", err)
+		}
+		client := m.Client("client-secret")
+
+		reloaded := map[string]*utils.Semaphore{
+			"global":   m.Semaphore(),
+			"client":   client.Semaphore(),
+			"playlist": client.Playlists()[0].Semaphore(),
+			"account":  m.AccountPools()["provider"].Accounts()[0].Semaphore(),
+			"group":    m.ConcurrencyGroup("sports"),
+		}
+		for name, sem := range reloaded {
+			if got := sem.Size(); got != int64(size) {
+				t.Errorf("size %d, %s: Size() = %d after reload", size, name, got)
+			}
+			if got := sem.InUse(); got != 1 {
+				t.Errorf("size %d, %s: InUse() = %d after reload, expected the held slot", size, name, got)
+			}
+			if got := sem.TryAcquire(); got != (size > 1) {
+				t.Errorf("size %d, %s: TryAcquire() = %v after reload", size, name, got)
+			}
+		}
+	}
+}
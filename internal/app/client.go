@@ -9,22 +9,27 @@ import (
 	"majmun/internal/listing"
 	"majmun/internal/listing/m3u8/rules/channel"
 	"majmun/internal/listing/m3u8/rules/playlist"
+	"majmun/internal/metrics"
 	"majmun/internal/shell"
 	"majmun/internal/urlgen"
-
-	"golang.org/x/sync/semaphore"
+	"majmun/internal/utils"
+	"net/http"
 )
 
 type Client struct {
 	name              string
 	secret            string
-	semaphore         *semaphore.Weighted
+	priority          int
+	preempt           bool
+	semaphore         *utils.Semaphore
 	playlistProviders []*Playlist
 	epgProviders      []*EPG
 	proxy             proxy.Proxy
+	hdhomerun         config.HDHomeRun
 	channelProcessor  *channel.Processor
 	playlistProcessor *playlist.Processor
 	epgLink           string
+	hdhomerunLink     string
 	urlGen            *urlgen.Generator
 }
 
@@ -32,7 +37,7 @@ type Provider interface {
 	Name() string
 	Type() string
 	URLGenerator() *urlgen.Generator
-	ExpiredLinkStreamer() *shell.Streamer
+	ExpiredLinkStreamer(vars StreamVars) *shell.Streamer
 }
 
 func NewClient(clientCfg config.Client, urlGen *urlgen.Generator, channelRules []*channelconf.Rule, playlistRules []*playlistconf.Rule, publicURL string) (*Client, error) {
@@ -40,25 +45,30 @@ func NewClient(clientCfg config.Client, urlGen *urlgen.Generator, channelRules [
 		return nil, fmt.Errorf("client secret cannot be empty")
 	}
 
-	var sem *semaphore.Weighted
+	var sem *utils.Semaphore
 	if clientCfg.Proxy.ConcurrentStreams > 0 {
-		sem = semaphore.NewWeighted(clientCfg.Proxy.ConcurrentStreams)
+		sem = utils.NewSemaphore(
+			metrics.SemaphoreLevelClient, clientCfg.Name, clientCfg.Proxy.ConcurrentStreams)
 	}
 
 	return &Client{
 		name:              clientCfg.Name,
 		secret:            clientCfg.Secret,
+		priority:          clientCfg.Priority,
 		semaphore:         sem,
 		proxy:             clientCfg.Proxy,
+		hdhomerun:         clientCfg.HDHomeRun,
 		channelProcessor:  channel.NewRulesProcessor(clientCfg.Name, channelRules),
 		playlistProcessor: playlist.NewRulesProcessor(clientCfg.Name, playlistRules),
 		epgLink:           fmt.Sprintf("%s/%s/epg.xml.gz", publicURL, clientCfg.Secret),
+		hdhomerunLink:     fmt.Sprintf("%s/%s/hdhr", publicURL, clientCfg.Secret),
 		urlGen:            urlGen,
 	}, nil
 }
 
 func (c *Client) BuildPlaylistProvider(
-	playlistConf config.Playlist, serverProxy proxy.Proxy, sem *semaphore.Weighted) error {
+	playlistConf config.Playlist, serverProxy proxy.Proxy, profiles []config.ProxyProfile,
+	sem *utils.Semaphore, accounts *AccountPool, httpClient *http.Client) error {
 
 	pr, err := NewPlaylistProvider(
 		playlistConf.Name,
@@ -67,10 +77,32 @@ func (c *Client) BuildPlaylistProvider(
 		mergeProxies(serverProxy, playlistConf.Proxy, c.proxy),
 		nil,
 		sem,
+		httpClient,
 	)
 	if err != nil {
 		return err
 	}
+	pr.accounts = accounts
+	pr.required = playlistConf.Required
+
+	// Profiles are applied on top of the client settings and share the playlist semaphore and accounts.
+	pr.profiles = make(map[string]*Playlist, len(profiles))
+	for _, profile := range profiles {
+		profilePr, err := NewPlaylistProvider(
+			playlistConf.Name,
+			c.urlGen,
+			playlistConf.Sources,
+			mergeProxies(serverProxy, playlistConf.Proxy, c.proxy, profile.Proxy),
+			nil,
+			sem,
+			httpClient,
+		)
+		if err != nil {
+			return fmt.Errorf("proxy profile %s: %w", profile.Name, err)
+		}
+		profilePr.accounts = accounts
+		pr.profiles[profile.Name] = profilePr
+	}
 
 	c.playlistProviders = append(c.playlistProviders, pr)
 	return nil
@@ -109,6 +141,14 @@ func (c *Client) EPGProviders() []listing.EPG {
 	return result
 }
 
+func (c *Client) Playlists() []*Playlist {
+	return c.playlistProviders
+}
+
+func (c *Client) EPGs() []*EPG {
+	return c.epgProviders
+}
+
 func (c *Client) GetProvider(prType urlgen.ProviderType, prName string) Provider {
 	switch prType {
 	case urlgen.ProviderTypePlaylist:
@@ -128,10 +168,28 @@ func (c *Client) GetProvider(prType urlgen.ProviderType, prName string) Provider
 	return nil
 }
 
-func (c *Client) Semaphore() *semaphore.Weighted {
+// Priority decides the place of the client in semaphore queues ordered by priority, higher first.
+func (c *Client) Priority() int {
+	return c.priority
+}
+
+// Preempt reports whether the client may take the global slot of a client with lower priority.
+func (c *Client) Preempt() bool {
+	return c.preempt
+}
+
+func (c *Client) Semaphore() *utils.Semaphore {
 	return c.semaphore
 }
 
+func (c *Client) HDHomeRun() config.HDHomeRun {
+	return c.hdhomerun
+}
+
+func (c *Client) HDHomeRunLink() string {
+	return c.hdhomerunLink
+}
+
 func (c *Client) EPGLink() string {
 	return c.epgLink
 }
@@ -47,6 +47,6 @@ func (es *EPG) ProxyConfig() proxy.Proxy {
 	return es.proxyConfig
 }
 
-func (es *EPG) ExpiredLinkStreamer() *shell.Streamer {
+func (es *EPG) ExpiredLinkStreamer(StreamVars) *shell.Streamer {
 	return nil
 }
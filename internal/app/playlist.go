@@ -4,39 +4,50 @@ import (
 	"fmt"
 	"majmun/internal/config/proxy"
 	"majmun/internal/config/rules/channel"
+	"majmun/internal/demux"
+	"majmun/internal/hls"
+	"majmun/internal/httpstream"
 	"majmun/internal/shell"
 	"majmun/internal/urlgen"
-
-	"golang.org/x/sync/semaphore"
+	"majmun/internal/utils"
+	"net/http"
+	"time"
 )
 
 type Playlist struct {
 	name string
 
-	sources []string
+	sources  []string
+	required bool
 
 	urlGenerator *urlgen.Generator
-	semaphore    *semaphore.Weighted
+	semaphore    *utils.Semaphore
 
 	rules []*channel.Rule
 
 	proxyConfig proxy.Proxy
+	httpClient  *http.Client
+	profiles    map[string]*Playlist
+	accounts    *AccountPool
 
 	linkStreamer          *shell.Streamer
 	rateLimitStreamer     *shell.Streamer
 	upstreamErrorStreamer *shell.Streamer
 	expiredLinkStreamer   *shell.Streamer
+	waitingStreamer       *shell.Streamer
+	preemptedStreamer     *shell.Streamer
 }
 
 func NewPlaylistProvider(
 	name string, urlGen *urlgen.Generator,
 	sources []string,
-	proxy proxy.Proxy, rules []*channel.Rule, sem *semaphore.Weighted) (*Playlist, error) {
+	proxy proxy.Proxy, rules []*channel.Rule, sem *utils.Semaphore, httpClient *http.Client) (*Playlist, error) {
 
 	streamStreamer, err := shell.NewShellStreamer(
 		proxy.Stream.Command,
 		proxy.Stream.EnvVars,
 		proxy.Stream.TemplateVars,
+		time.Duration(proxy.Stream.NoDataTimeout),
 	)
 	if err != nil {
 		return nil, fmt.Errorf("failed to create stream command: %w", err)
@@ -46,6 +57,7 @@ func NewPlaylistProvider(
 		proxy.Error.RateLimitExceeded.Command,
 		proxy.Error.RateLimitExceeded.EnvVars,
 		proxy.Error.RateLimitExceeded.TemplateVars,
+		time.Duration(proxy.Error.RateLimitExceeded.NoDataTimeout),
 	)
 	if err != nil {
 		return nil, fmt.Errorf("failed to create rate limit command: %w", err)
@@ -55,6 +67,7 @@ func NewPlaylistProvider(
 		proxy.Error.UpstreamError.Command,
 		proxy.Error.UpstreamError.EnvVars,
 		proxy.Error.UpstreamError.TemplateVars,
+		time.Duration(proxy.Error.UpstreamError.NoDataTimeout),
 	)
 	if err != nil {
 		return nil, fmt.Errorf("failed to create upstream error command: %w", err)
@@ -64,22 +77,46 @@ func NewPlaylistProvider(
 		proxy.Error.LinkExpired.Command,
 		proxy.Error.LinkExpired.EnvVars,
 		proxy.Error.LinkExpired.TemplateVars,
+		time.Duration(proxy.Error.LinkExpired.NoDataTimeout),
 	)
 	if err != nil {
 		return nil, fmt.Errorf("failed to create expired link command: %w", err)
 	}
 
+	waitingStreamer, err := shell.NewShellStreamer(
+		proxy.Error.Waiting.Command,
+		proxy.Error.Waiting.EnvVars,
+		proxy.Error.Waiting.TemplateVars,
+		time.Duration(proxy.Error.Waiting.NoDataTimeout),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create waiting command: %w", err)
+	}
+
+	preemptedStreamer, err := shell.NewShellStreamer(
+		proxy.Error.Preempted.Command,
+		proxy.Error.Preempted.EnvVars,
+		proxy.Error.Preempted.TemplateVars,
+		time.Duration(proxy.Error.Preempted.NoDataTimeout),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create preempted command: %w", err)
+	}
+
 	return &Playlist{
 		name:                  name,
 		urlGenerator:          urlGen,
 		sources:               sources,
 		semaphore:             sem,
 		proxyConfig:           proxy,
+		httpClient:            httpClient,
 		rules:                 rules,
 		linkStreamer:          streamStreamer,
 		rateLimitStreamer:     rateLimitStreamer,
 		upstreamErrorStreamer: upstreamErrorStreamer,
 		expiredLinkStreamer:   expiredLinkStreamer,
+		waitingStreamer:       waitingStreamer,
+		preemptedStreamer:     preemptedStreamer,
 	}, nil
 }
 
@@ -103,7 +140,7 @@ func (ps *Playlist) Rules() []*channel.Rule {
 	return ps.rules
 }
 
-func (ps *Playlist) Semaphore() *semaphore.Weighted {
+func (ps *Playlist) Semaphore() *utils.Semaphore {
 	return ps.semaphore
 }
 
@@ -111,22 +148,113 @@ func (ps *Playlist) IsProxied() bool {
 	return ps.proxyConfig.Enabled != nil && *ps.proxyConfig.Enabled
 }
 
+// IsHLS reports whether links of channels with the given proxy profile are served as HLS,
+// with the output of the profile overriding the one of the playlist.
+func (ps *Playlist) IsHLS(profile string) bool {
+	return ps.IsProxied() && ps.WithProxyProfile(profile).proxyConfig.Output == proxy.OutputHLS
+}
+
+func (ps *Playlist) IsRequired() bool {
+	return ps.required
+}
+
 func (ps *Playlist) ProxyConfig() proxy.Proxy {
 	return ps.proxyConfig
 }
 
-func (ps *Playlist) LinkStreamer(streamUrl string) *shell.Streamer {
-	return ps.linkStreamer.WithTemplateVars(map[string]any{"url": streamUrl})
+// WithProxyProfile returns the playlist with the settings of the named proxy profile,
+// or the playlist itself when the name is empty or the profile is no longer configured.
+func (ps *Playlist) WithProxyProfile(name string) *Playlist {
+	if profile, ok := ps.profiles[name]; ok {
+		return profile
+	}
+	return ps
+}
+
+// Accounts returns the provider accounts of the playlist, or nil when it has none.
+func (ps *Playlist) Accounts() *AccountPool {
+	return ps.accounts
+}
+
+// LinkStreamer returns the streamer of the upstream link. With provider accounts configured,
+// the stream runs with the credentials of the account picked when it starts.
+func (ps *Playlist) LinkStreamer(vars StreamVars) demux.Streamer {
+	if ps.accounts != nil {
+		return &accountStreamer{pool: ps.accounts, vars: vars, build: ps.sourceStreamer}
+	}
+	return ps.sourceStreamer(vars)
+}
+
+func (ps *Playlist) sourceStreamer(vars StreamVars) demux.Streamer {
+	stream := ps.proxyConfig.Stream
+	switch stream.Mode {
+	case proxy.StreamModeHTTP:
+		return httpstream.NewStreamer(ps.httpClient, vars.URL, time.Duration(stream.NoDataTimeout))
+	case proxy.StreamModeHLS:
+		variant := hls.Variant{Policy: hls.VariantPolicy(stream.Variant.Policy), MaxHeight: stream.Variant.MaxHeight}
+		return hls.NewUpstream(ps.httpClient, vars.URL, variant, time.Duration(stream.NoDataTimeout))
+	}
+	return ps.linkStreamer.WithTemplateVars(vars.templateVars())
+}
+
+func (ps *Playlist) LimitStreamer(vars StreamVars) *shell.Streamer {
+	return ps.rateLimitStreamer.WithTemplateVars(vars.templateVars())
+}
+
+func (ps *Playlist) UpstreamErrorStreamer(vars StreamVars) *shell.Streamer {
+	return ps.upstreamErrorStreamer.WithTemplateVars(vars.templateVars())
+}
+
+func (ps *Playlist) ExpiredLinkStreamer(vars StreamVars) *shell.Streamer {
+	return ps.expiredLinkStreamer.WithTemplateVars(vars.templateVars())
+}
+
+// WaitingSlate reports whether clients waiting in a semaphore queue get the waiting response.
+func (ps *Playlist) WaitingSlate() bool {
+	return ps.proxyConfig.Queue.IsSlateEnabled()
+}
+
+func (ps *Playlist) WaitingStreamer(vars StreamVars) *shell.Streamer {
+	return ps.waitingStreamer.WithTemplateVars(vars.templateVars())
 }
 
-func (ps *Playlist) LimitStreamer() *shell.Streamer {
-	return ps.rateLimitStreamer
+func (ps *Playlist) PreemptedStreamer(vars StreamVars) *shell.Streamer {
+	return ps.preemptedStreamer.WithTemplateVars(vars.templateVars())
 }
 
-func (ps *Playlist) UpstreamErrorStreamer() *shell.Streamer {
-	return ps.upstreamErrorStreamer
+// StreamVars describes the request a stream or error command runs for.
+type StreamVars struct {
+	URL         string
+	ClientName  string
+	ChannelName string
+	Attrs       map[string]string
+	Tags        map[string]string
+	UserAgent   string
+	Query       map[string]string
+	SourceIndex int
+	AccountName string
+	AccountVars map[string]string
 }
 
-func (ps *Playlist) ExpiredLinkStreamer() *shell.Streamer {
-	return ps.expiredLinkStreamer
+func (v StreamVars) templateVars() map[string]any {
+	vars := make(map[string]any, len(v.AccountVars)+9)
+	for k, val := range v.AccountVars {
+		vars[k] = val
+	}
+
+	for k, val := range map[string]any{
+		"url":           v.URL,
+		"client_name":   v.ClientName,
+		"channel_name":  v.ChannelName,
+		"channel_attrs": v.Attrs,
+		"channel_tags":  v.Tags,
+		"user_agent":    v.UserAgent,
+		"query":         v.Query,
+		"source_index":  v.SourceIndex,
+		"account_name":  v.AccountName,
+	} {
+		vars[k] = val
+	}
+
+	return vars
 }
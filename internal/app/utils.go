@@ -26,6 +26,25 @@ func mergeProxies(proxies ...proxy.Proxy) proxy.Proxy {
 		if p.ConcurrentStreams > 0 {
 			result.ConcurrentStreams = p.ConcurrentStreams
 		}
+		result.Queue = mergeQueue(result.Queue, p.Queue)
+		if p.Preempt != nil {
+			result.Preempt = p.Preempt
+		}
+		if p.Output != "" {
+			result.Output = p.Output
+		}
+		result.HLS = mergeHLS(result.HLS, p.HLS)
+		result.Failover = mergeFailover(result.Failover, p.Failover)
+		if p.Linger > 0 {
+			result.Linger = p.Linger
+		}
+		if p.ReplayBuffer > 0 {
+			result.ReplayBuffer = p.ReplayBuffer
+		}
+		result.SlowClient = mergeSlowClient(result.SlowClient, p.SlowClient)
+		if p.Probe != "" {
+			result.Probe = p.Probe
+		}
 		result.Stream = mergeHandlers(
 			result.Stream, p.Stream)
 
@@ -40,19 +59,86 @@ func mergeProxies(proxies ...proxy.Proxy) proxy.Proxy {
 
 		result.Error.UpstreamError = mergeHandlers(
 			result.Error.Handler, result.Error.UpstreamError, p.Error.UpstreamError)
+
+		result.Error.Waiting = mergeHandlers(
+			result.Error.Handler, result.Error.Waiting, p.Error.Waiting)
+
+		result.Error.Preempted = mergeHandlers(
+			result.Error.Handler, result.Error.Preempted, p.Error.Preempted)
 	}
 
 	return result
 }
 
+func mergeQueue(base, override proxy.Queue) proxy.Queue {
+	if override.Timeout > 0 {
+		base.Timeout = override.Timeout
+	}
+	if override.Order != "" {
+		base.Order = override.Order
+	}
+	if override.Slate != nil {
+		base.Slate = override.Slate
+	}
+	return base
+}
+
+func mergeHLS(base, override proxy.HLS) proxy.HLS {
+	if override.SegmentDuration > 0 {
+		base.SegmentDuration = override.SegmentDuration
+	}
+	if override.PlaylistSize > 0 {
+		base.PlaylistSize = override.PlaylistSize
+	}
+	if override.IdleTimeout > 0 {
+		base.IdleTimeout = override.IdleTimeout
+	}
+	return base
+}
+
+func mergeFailover(base, override proxy.Failover) proxy.Failover {
+	if override.Enabled != nil {
+		base.Enabled = override.Enabled
+	}
+	if override.Restarts > 0 {
+		base.Restarts = override.Restarts
+	}
+	if override.StallTimeout > 0 {
+		base.StallTimeout = override.StallTimeout
+	}
+	return base
+}
+
+func mergeSlowClient(base, override proxy.SlowClient) proxy.SlowClient {
+	if override.Policy != "" {
+		base.Policy = override.Policy
+	}
+	if override.Timeout > 0 {
+		base.Timeout = override.Timeout
+	}
+	return base
+}
+
 func mergeHandlers(handlers ...proxy.Handler) proxy.Handler {
 	result := proxy.Handler{}
 	for _, h := range handlers {
+		if h.Mode != "" {
+			result.Mode = h.Mode
+		}
 		if len(h.Command) > 0 {
 			result.Command = h.Command
 		}
 		mergePairs(&result.TemplateVars, h.TemplateVars)
 		mergePairs(&result.EnvVars, h.EnvVars)
+		if h.NoDataTimeout > 0 {
+			result.NoDataTimeout = h.NoDataTimeout
+		}
+		if h.Variant.Policy != "" {
+			result.Variant.Policy = h.Variant.Policy
+		}
+		if h.Variant.MaxHeight > 0 {
+			result.Variant.MaxHeight = h.Variant.MaxHeight
+		}
 	}
 
 	return result
@@ -5,6 +5,7 @@ import (
 	"majmun/internal/config/proxy"
 	"reflect"
 	"testing"
+	"time"
 )
 
 func TestMergeProxies(t *testing.T) {
@@ -76,6 +77,119 @@ func TestMergeProxies(t *testing.T) {
 				Enabled: boolPtr(true),
 			},
 		},
+		{
+			name: "output and hls settings override",
+			proxies: []proxy.Proxy{
+				{
+					Output: proxy.OutputMPEGTS,
+					HLS: proxy.HLS{
+						SegmentDuration: common.Duration(6 * time.Second),
+						PlaylistSize:    6,
+						IdleTimeout:     common.Duration(30 * time.Second),
+					},
+				},
+				{
+					Output: proxy.OutputHLS,
+					HLS: proxy.HLS{
+						SegmentDuration: common.Duration(2 * time.Second),
+					},
+				},
+				{
+					HLS: proxy.HLS{
+						PlaylistSize: 10,
+					},
+				},
+			},
+			expected: proxy.Proxy{
+				Output: proxy.OutputHLS,
+				HLS: proxy.HLS{
+					SegmentDuration: common.Duration(2 * time.Second),
+					PlaylistSize:    10,
+					IdleTimeout:     common.Duration(30 * time.Second),
+				},
+			},
+		},
+		{
+			name: "failover settings override",
+			proxies: []proxy.Proxy{
+				{
+					Failover: proxy.Failover{
+						Enabled:      boolPtr(true),
+						Restarts:     3,
+						StallTimeout: common.Duration(10 * time.Second),
+					},
+				},
+				{
+					Failover: proxy.Failover{
+						Enabled:  boolPtr(false),
+						Restarts: 5,
+					},
+				},
+			},
+			expected: proxy.Proxy{
+				Failover: proxy.Failover{
+					Enabled:      boolPtr(false),
+					Restarts:     5,
+					StallTimeout: common.Duration(10 * time.Second),
+				},
+			},
+		},
+		{
+			name: "linger and replay buffer override",
+			proxies: []proxy.Proxy{
+				{Linger: common.Duration(30 * time.Second), ReplayBuffer: common.ByteSize(32 << 20)},
+				{Linger: common.Duration(time.Minute)},
+				{ReplayBuffer: common.ByteSize(4 << 20)},
+			},
+			expected: proxy.Proxy{
+				Linger:       common.Duration(time.Minute),
+				ReplayBuffer: common.ByteSize(4 << 20),
+			},
+		},
+		{
+			name: "slow client override",
+			proxies: []proxy.Proxy{
+				{SlowClient: proxy.SlowClient{Policy: proxy.SlowClientDrop, Timeout: common.Duration(5 * time.Second)}},
+				{SlowClient: proxy.SlowClient{Policy: proxy.SlowClientBlock}},
+			},
+			expected: proxy.Proxy{
+				SlowClient: proxy.SlowClient{Policy: proxy.SlowClientBlock, Timeout: common.Duration(5 * time.Second)},
+			},
+		},
+		{
+			name: "probe override",
+			proxies: []proxy.Proxy{
+				{Probe: proxy.ProbeMPEGTS},
+				{Probe: proxy.ProbeADTS},
+				{},
+			},
+			expected: proxy.Proxy{
+				Probe: proxy.ProbeADTS,
+			},
+		},
+		{
+			name: "queue override",
+			proxies: []proxy.Proxy{
+				{Queue: proxy.Queue{Timeout: common.Duration(30 * time.Second), Slate: boolPtr(true)}},
+				{Queue: proxy.Queue{Order: proxy.QueueOrderPriority}},
+			},
+			expected: proxy.Proxy{
+				Queue: proxy.Queue{
+					Timeout: common.Duration(30 * time.Second),
+					Order:   proxy.QueueOrderPriority,
+					Slate:   boolPtr(true),
+				},
+			},
+		},
+		{
+			name: "preempt override",
+			proxies: []proxy.Proxy{
+				{Preempt: boolPtr(true)},
+				{ConcurrentStreams: 2},
+				{Preempt: boolPtr(false)},
+			},
+			expected: proxy.Proxy{ConcurrentStreams: 2, Preempt: boolPtr(false)},
+		},
 	}
 
 	for _, tt := range tests {
@@ -87,6 +201,33 @@ func TestMergeProxies(t *testing.T) {
 			if result.ConcurrentStreams != tt.expected.ConcurrentStreams {
 				t.Errorf("mergeProxies().ConcurrentStreams = %v, expected %v", result.ConcurrentStreams, tt.expected.ConcurrentStreams)
 			}
+			if result.Output != tt.expected.Output {
+				t.Errorf("mergeProxies().Output = %v, expected %v", result.Output, tt.expected.Output)
+			}
+			if result.HLS != tt.expected.HLS {
+				t.Errorf("mergeProxies().HLS = %v, expected %v", result.HLS, tt.expected.HLS)
+			}
+			if !reflect.DeepEqual(result.Failover, tt.expected.Failover) {
+				t.Errorf("mergeProxies().Failover = %v, expected %v", result.Failover, tt.expected.Failover)
+			}
+			if result.Linger != tt.expected.Linger {
+				t.Errorf("mergeProxies().Linger = %v, expected %v", result.Linger, tt.expected.Linger)
+			}
+			if result.ReplayBuffer != tt.expected.ReplayBuffer {
+				t.Errorf("mergeProxies().ReplayBuffer = %v, expected %v", result.ReplayBuffer, tt.expected.ReplayBuffer)
+			}
+			if result.SlowClient != tt.expected.SlowClient {
+				t.Errorf("mergeProxies().SlowClient = %v, expected %v", result.SlowClient, tt.expected.SlowClient)
+			}
+			if result.Probe != tt.expected.Probe {
+				t.Errorf("mergeProxies().Probe = %v, expected %v", result.Probe, tt.expected.Probe)
+			}
+			if !reflect.DeepEqual(result.Queue, tt.expected.Queue) {
+				t.Errorf("mergeProxies().Queue = %v, expected %v", result.Queue, tt.expected.Queue)
+			}
+			if !reflect.DeepEqual(result.Preempt, tt.expected.Preempt) {
+				t.Errorf("mergeProxies().Preempt = %v, expected %v", result.Preempt, tt.expected.Preempt)
+			}
 		})
 	}
 }
@@ -189,6 +330,45 @@ func TestMergeHandlers(t *testing.T) {
 				},
 			},
 		},
+		{
+			name: "no data timeout override",
+			handlers: []proxy.Handler{
+				{
+					NoDataTimeout: common.Duration(10 * time.Second),
+				},
+				{
+					NoDataTimeout: common.Duration(30 * time.Second),
+				},
+				{},
+			},
+			expected: proxy.Handler{
+				NoDataTimeout: common.Duration(30 * time.Second),
+			},
+		},
+		{
+			name: "mode override",
+			handlers: []proxy.Handler{
+				{Mode: proxy.StreamModeCommand, Command: []string{"ffmpeg"}},
+				{Mode: proxy.StreamModeHTTP},
+				{},
+			},
+			expected: proxy.Handler{
+				Mode:    proxy.StreamModeHTTP,
+				Command: []string{"ffmpeg"},
+			},
+		},
+		{
+			name: "variant override",
+			handlers: []proxy.Handler{
+				{Mode: proxy.StreamModeHLS, Variant: proxy.Variant{Policy: proxy.VariantLowestBandwidth, MaxHeight: 720}},
+				{Variant: proxy.Variant{Policy: proxy.VariantHighestResolution}},
+				{},
+			},
+			expected: proxy.Handler{
+				Mode:    proxy.StreamModeHLS,
+				Variant: proxy.Variant{Policy: proxy.VariantHighestResolution, MaxHeight: 720},
+			},
+		},
 	}
 
 	for _, tt := range tests {
@@ -203,6 +383,15 @@ func TestMergeHandlers(t *testing.T) {
 			if !nameValueSlicesEqual(result.EnvVars, tt.expected.EnvVars) {
 				t.Errorf("mergeHandlers().EnvVars = %v, expected %v", result.EnvVars, tt.expected.EnvVars)
 			}
+			if result.NoDataTimeout != tt.expected.NoDataTimeout {
+				t.Errorf("mergeHandlers().NoDataTimeout = %v, expected %v", result.NoDataTimeout, tt.expected.NoDataTimeout)
+			}
+			if result.Mode != tt.expected.Mode {
+				t.Errorf("mergeHandlers().Mode = %v, expected %v", result.Mode, tt.expected.Mode)
+			}
+			if result.Variant != tt.expected.Variant {
+				t.Errorf("mergeHandlers().Variant = %v, expected %v", result.Variant, tt.expected.Variant)
+			}
 		})
 	}
 }
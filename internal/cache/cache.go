@@ -13,6 +13,7 @@ import (
 	"os"
 	"path/filepath"
 	"strings"
+	"sync"
 	"time"
 )
 
@@ -20,6 +21,7 @@ const (
 	compressedExtension   = ".gz"
 	uncompressedExtension = ".cache"
 	metaExtension         = ".meta"
+	partExtension         = ".part"
 )
 
 type Cache struct {
@@ -30,6 +32,9 @@ type Cache struct {
 	ttl              time.Duration
 	retention        time.Duration
 	compression      bool
+
+	mu        sync.Mutex
+	downloads map[string]*download
 }
 
 func NewCache(cfg config.CacheConfig) (*Cache, error) {
@@ -45,6 +50,7 @@ func NewCache(cfg config.CacheConfig) (*Cache, error) {
 		ttl:              time.Duration(cfg.TTL),
 		retention:        time.Duration(cfg.Retention),
 		compression:      cfg.Compression,
+		downloads:        make(map[string]*download),
 	}
 
 	if cfg.Retention > 0 {
@@ -55,8 +61,18 @@ func NewCache(cfg config.CacheConfig) (*Cache, error) {
 }
 
 func (c *Cache) NewCachedHTTPClient() *http.Client {
+	return c.newHTTPClient(&cachingTransport{cache: c})
+}
+
+// NewRefreshingHTTPClient returns a client that revalidates every response with the origin and stores it
+// in the cache, however fresh the cached copy is. It does not fall back to stale copies.
+func (c *Cache) NewRefreshingHTTPClient() *http.Client {
+	return c.newHTTPClient(&cachingTransport{cache: c, refresh: true})
+}
+
+func (c *Cache) newHTTPClient(transport http.RoundTripper) *http.Client {
 	return &http.Client{
-		Transport: &cachingTransport{cache: c},
+		Transport: transport,
 		Timeout:   10 * time.Minute,
 		CheckRedirect: func(req *http.Request, via []*http.Request) error {
 			if len(via) >= 5 {
@@ -68,6 +84,10 @@ func (c *Cache) NewCachedHTTPClient() *http.Client {
 }
 
 func (c *Cache) NewReader(ctx context.Context, url string) (*Reader, error) {
+	return c.newReader(ctx, url, false)
+}
+
+func (c *Cache) newReader(ctx context.Context, url string, refresh bool) (*Reader, error) {
 	hash := sha256.Sum256([]byte(url))
 	name := hex.EncodeToString(hash[:16])
 	fileExt := c.fileExt()
@@ -86,7 +106,7 @@ func (c *Cache) NewReader(ctx context.Context, url string) (*Reader, error) {
 	var readCloser io.ReadCloser
 	var cacheStatus = metrics.CacheStatusMiss
 
-	s := reader.checkCacheStatus()
+	s := reader.checkCacheStatus(refresh)
 
 	switch s {
 	case statusValid, statusRenewed:
@@ -100,9 +120,17 @@ func (c *Cache) NewReader(ctx context.Context, url string) (*Reader, error) {
 			reader.ReadCloser = readCloser
 		}
 	case statusExpired, statusNotFound:
-		readCloser, err = reader.newCachingReader(ctx)
+		readCloser, err = c.newDownloadReader(ctx, reader)
 		if err == nil {
 			reader.ReadCloser = readCloser
+		} else if s == statusExpired && !refresh {
+			// The last complete copy is served when the origin fails, however old it is.
+			logging.Warn(ctx, err, "failed to refresh cache", "url", logging.SanitizeURL(url), "fallback", "stale cache")
+			readCloser, err = reader.newCachedReader()
+			if err == nil {
+				cacheStatus = metrics.CacheStatusStale
+				reader.ReadCloser = readCloser
+			}
 		}
 
 	default:
@@ -115,11 +143,30 @@ func (c *Cache) NewReader(ctx context.Context, url string) (*Reader, error) {
 	logging.Debug(
 		ctx, "file access", "cache", formatCacheStatus(s), "url", logging.SanitizeURL(url))
 
-	metrics.IncProxyRequests(ctx, cacheStatus)
+	if !refresh {
+		metrics.IncProxyRequests(ctx, cacheStatus)
+	}
 
 	return reader, err
 }
 
+// newDownloadReader reads the URL while it is downloaded into the cache. Concurrent requests for the same URL
+// share a single download.
+func (c *Cache) newDownloadReader(ctx context.Context, r *Reader) (io.ReadCloser, error) {
+	d, err := c.download(ctx, r)
+	if err != nil {
+		return nil, err
+	}
+
+	readCloser, err := d.newReader(ctx, c.compression)
+	if err != nil {
+		return nil, err
+	}
+
+	r.download = d
+	return readCloser, nil
+}
+
 func (c *Cache) Close() {
 	if c.cleanupTicker != nil {
 		c.cleanupTicker.Stop()
@@ -197,6 +244,16 @@ func (c *Cache) cleanExpired() error {
 				orphanedRemoved++
 			}
 
+		case strings.HasSuffix(fileName, partExtension):
+			// Failed downloads remove their part file, only leftovers of a crash are cleaned up here.
+			info, err := file.Info()
+			if err == nil && now.Sub(info.ModTime()) > c.retention {
+				if err := os.Remove(filePath); err != nil && !os.IsNotExist(err) {
+					return fmt.Errorf("failed to remove partial file: %w", err)
+				}
+				orphanedRemoved++
+			}
+
 		case strings.HasSuffix(fileName, ".meta"):
 			name := strings.TrimSuffix(fileName, ".meta")
 
@@ -3,6 +3,7 @@ package cache
 import (
 	"context"
 	"encoding/json"
+	"fmt"
 	"io"
 	"majmun/internal/config"
 	"majmun/internal/config/common"
@@ -10,6 +11,7 @@ import (
 	"net/http/httptest"
 	"os"
 	"path/filepath"
+	"sync/atomic"
 	"testing"
 	"time"
 )
@@ -192,6 +194,149 @@ func TestCache_NewReader(t *testing.T) {
 			t.Error("expected error for server error")
 		}
 	})
+
+	t.Run("serves stale copy when refresh fails", func(t *testing.T) {
+		var failing atomic.Bool
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if failing.Load() {
+				http.Error(w, "Bad Gateway", http.StatusBadGateway)
+				return
+			}
+			_, _ = w.Write([]byte("#EXTM3U\n"))
+		}))
+		defer server.Close()
+
+		reader, err := cache.NewReader(ctx, server.URL)
+		if err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+		if _, err := io.ReadAll(reader); err != nil {
+			t.Fatalf("failed to read content: %v", err)
+		}
+		if err := reader.Close(); err != nil {
+			t.Fatalf("failed to close reader: %v", err)
+		}
+
+		cachedAt := time.Now().Add(-2 * time.Hour)
+		if err := createTestMetadata(reader.MetaPath, cachedAt.Unix()); err != nil {
+			t.Fatalf("failed to expire cache entry: %v", err)
+		}
+		failing.Store(true)
+
+		stale, err := cache.NewReader(ctx, server.URL)
+		if err != nil {
+			t.Fatalf("expected stale copy, got %v", err)
+		}
+		defer func() { _ = stale.Close() }()
+
+		content, err := io.ReadAll(stale)
+		if err != nil {
+			t.Fatalf("failed to read content: %v", err)
+		}
+		if string(content) != "#EXTM3U\n" {
+			t.Errorf("expected stale content, got %q", string(content))
+		}
+		if age, ok := stale.Age(); !ok || age < time.Hour {
+			t.Errorf("expected age of the stale copy, got %v", age)
+		}
+	})
+
+	t.Run("keeps cached copy when download is incomplete", func(t *testing.T) {
+		var broken atomic.Bool
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if broken.Load() {
+				w.Header().Set("Content-Length", "100")
+				_, _ = w.Write([]byte("#EXTM3U broken\n"))
+				return
+			}
+			_, _ = w.Write([]byte("#EXTM3U\n"))
+		}))
+		defer server.Close()
+
+		reader, err := cache.NewReader(ctx, server.URL)
+		if err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+		if _, err := io.ReadAll(reader); err != nil {
+			t.Fatalf("failed to read content: %v", err)
+		}
+		_ = reader.Close()
+
+		if err := createTestMetadata(reader.MetaPath, time.Now().Add(-2*time.Hour).Unix()); err != nil {
+			t.Fatalf("failed to expire cache entry: %v", err)
+		}
+		broken.Store(true)
+
+		partial, err := cache.NewReader(ctx, server.URL)
+		if err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+		// The download may fail before or after the reader joins it, so either the error or the stale copy is seen.
+		if content, err := io.ReadAll(partial); err == nil && string(content) != "#EXTM3U\n" {
+			t.Errorf("expected error or stale content, got %q", string(content))
+		}
+		_ = partial.Close()
+
+		checkFileExists(t, reader.FilePath, true)
+		checkFileExists(t, reader.FilePath+partExtension, false)
+	})
+
+	t.Run("refresh downloads fresh copy again", func(t *testing.T) {
+		var version atomic.Int32
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			_, _ = fmt.Fprintf(w, "#EXTM3U version %d\n", version.Add(1))
+		}))
+		defer server.Close()
+
+		for _, refresh := range []bool{false, true} {
+			reader, err := cache.newReader(ctx, server.URL, refresh)
+			if err != nil {
+				t.Fatalf("expected no error, got %v", err)
+			}
+			if _, err := io.ReadAll(reader); err != nil {
+				t.Fatalf("failed to read content: %v", err)
+			}
+			_ = reader.Close()
+		}
+
+		reader, err := cache.NewReader(ctx, server.URL)
+		if err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+		defer func() { _ = reader.Close() }()
+
+		content, err := io.ReadAll(reader)
+		if err != nil {
+			t.Fatalf("failed to read content: %v", err)
+		}
+		if string(content) != "#EXTM3U version 2\n" {
+			t.Errorf("expected refreshed content, got %q", string(content))
+		}
+	})
+
+	t.Run("refresh does not serve stale copy", func(t *testing.T) {
+		var failing atomic.Bool
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if failing.Load() {
+				http.Error(w, "Bad Gateway", http.StatusBadGateway)
+				return
+			}
+			_, _ = w.Write([]byte("#EXTM3U\n"))
+		}))
+		defer server.Close()
+
+		reader, err := cache.NewReader(ctx, server.URL)
+		if err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+		_, _ = io.ReadAll(reader)
+		_ = reader.Close()
+
+		failing.Store(true)
+		if _, err := cache.newReader(ctx, server.URL, true); err == nil {
+			t.Error("expected error when the origin fails")
+		}
+	})
 }
 
 func TestCache_CleanExpired(t *testing.T) {
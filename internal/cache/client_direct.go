@@ -20,12 +20,17 @@ func (t *headerTransport) RoundTrip(req *http.Request) (*http.Response, error) {
 }
 
 func newDirectHTTPClient(extraHeaders []common.NameValue) *http.Client {
+	return NewHTTPClient(http.DefaultTransport, extraHeaders, 10*time.Minute)
+}
+
+// NewHTTPClient returns a client that sets the extra headers on every request, including redirects.
+func NewHTTPClient(base http.RoundTripper, extraHeaders []common.NameValue, timeout time.Duration) *http.Client {
 	return &http.Client{
 		Transport: &headerTransport{
-			base:    http.DefaultTransport,
+			base:    base,
 			headers: extraHeaders,
 		},
-		Timeout: 10 * time.Minute,
+		Timeout: timeout,
 		CheckRedirect: func(req *http.Request, via []*http.Request) error {
 			if len(via) >= 5 {
 				return fmt.Errorf("too many redirects")
@@ -0,0 +1,297 @@
+package cache
+
+import (
+	"compress/gzip"
+	"context"
+	"fmt"
+	"io"
+	"majmun/internal/ioutil"
+	"net/http"
+	"os"
+	"sync"
+)
+
+// download fetches a URL into the cache once, while any number of readers follow the file being written.
+// The download runs in the background, so a reader leaving early does not break it for the others.
+type download struct {
+	partPath string
+	filePath string
+
+	// started is closed once the origin responded, with header set, or failed with startErr.
+	started  chan struct{}
+	header   http.Header
+	startErr error
+
+	mu      sync.Mutex
+	cond    *sync.Cond
+	written int64
+	done    bool
+	err     error
+}
+
+func newDownload(filePath string) *download {
+	d := &download{
+		partPath: filePath + partExtension,
+		filePath: filePath,
+		started:  make(chan struct{}),
+	}
+	d.cond = sync.NewCond(&d.mu)
+	return d
+}
+
+// download joins the download of the reader URL in progress, or starts one, and waits for the origin to respond.
+func (c *Cache) download(ctx context.Context, r *Reader) (*download, error) {
+	c.mu.Lock()
+	d, ok := c.downloads[r.Name]
+	if !ok {
+		d = newDownload(r.FilePath)
+		c.downloads[r.Name] = d
+		go c.runDownload(context.WithoutCancel(ctx), d, r.Name, r.URL, r.MetaPath)
+	}
+	c.mu.Unlock()
+
+	select {
+	case <-d.started:
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+
+	if d.startErr != nil {
+		return nil, d.startErr
+	}
+	return d, nil
+}
+
+func (c *Cache) runDownload(ctx context.Context, d *download, name, url, metaPath string) {
+	resp, err := c.fetch(ctx, d, url)
+
+	d.mu.Lock()
+	if err == nil {
+		err = commitDownload(d, resp, metaPath)
+	}
+	if err != nil {
+		_ = os.Remove(d.partPath)
+	}
+	d.done = true
+	d.err = err
+	d.cond.Broadcast()
+	d.mu.Unlock()
+
+	c.mu.Lock()
+	delete(c.downloads, name)
+	c.mu.Unlock()
+}
+
+// fetch writes the response of the origin to the part file, compressed when the cache is.
+func (c *Cache) fetch(ctx context.Context, d *download, url string) (*http.Response, error) {
+	start := func(header http.Header, err error) {
+		d.header = header
+		d.startErr = err
+		close(d.started)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
+	if err != nil {
+		err = fmt.Errorf("failed to create request: %w", err)
+		start(nil, err)
+		return nil, err
+	}
+
+	resp, err := c.directHttpClient.Do(req)
+	if err != nil {
+		err = fmt.Errorf("failed to fetch URL: %w", err)
+		start(nil, err)
+		return nil, err
+	}
+	defer func() { _ = resp.Body.Close() }()
+
+	if resp.StatusCode != http.StatusOK {
+		err = fmt.Errorf("unexpected status code: %d", resp.StatusCode)
+		start(nil, err)
+		return nil, err
+	}
+
+	file, err := os.Create(d.partPath)
+	if err != nil {
+		err = fmt.Errorf("failed to create cache file: %w", err)
+		start(nil, err)
+		return nil, err
+	}
+	start(resp.Header, nil)
+
+	w := &progressWriter{file: file, download: d}
+	err = copyBody(w, resp, url, c.compression)
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to download %s: %w", url, err)
+	}
+
+	return resp, nil
+}
+
+func copyBody(w io.Writer, resp *http.Response, url string, compression bool) error {
+	gzipped := isGzippedContent(resp, url)
+
+	switch {
+	case compression && gzipped:
+		_, err := io.Copy(w, resp.Body)
+		return err
+	case compression:
+		gzipW, err := gzip.NewWriterLevel(w, gzip.BestSpeed)
+		if err != nil {
+			return fmt.Errorf("failed to create gzip writer: %w", err)
+		}
+		if _, err := io.Copy(gzipW, resp.Body); err != nil {
+			return err
+		}
+		return gzipW.Close()
+	case gzipped:
+		gzipR, err := gzip.NewReader(resp.Body)
+		if err != nil {
+			return fmt.Errorf("failed to create gzip reader: %w", err)
+		}
+		defer func() { _ = gzipR.Close() }()
+		_, err = io.Copy(w, gzipR)
+		return err
+	default:
+		_, err := io.Copy(w, resp.Body)
+		return err
+	}
+}
+
+// commitDownload replaces the cached copy with the complete download. It runs under the download lock,
+// so readers joining now open the cached copy instead of the part file.
+func commitDownload(d *download, resp *http.Response, metaPath string) error {
+	if err := os.Rename(d.partPath, d.filePath); err != nil {
+		return fmt.Errorf("failed to store cache file: %w", err)
+	}
+	meta := &Reader{MetaPath: metaPath, originResponse: resp}
+	return meta.SaveMetadata()
+}
+
+func (d *download) advance(n int) {
+	d.mu.Lock()
+	d.written += int64(n)
+	d.cond.Broadcast()
+	d.mu.Unlock()
+}
+
+// wait blocks until more than offset bytes are written, and returns io.EOF once the download completed
+// and there is nothing left to read.
+func (d *download) wait(ctx context.Context, offset int64) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	for d.written <= offset && !d.done && ctx.Err() == nil {
+		d.cond.Wait()
+	}
+
+	switch {
+	case d.done && d.err != nil:
+		return d.err
+	case d.written > offset:
+		return nil
+	case ctx.Err() != nil:
+		return ctx.Err()
+	default:
+		return io.EOF
+	}
+}
+
+// newReader returns a reader of the downloaded data, which follows the part file until the download ends.
+func (d *download) newReader(ctx context.Context, compression bool) (io.ReadCloser, error) {
+	file, err := d.open()
+	if err != nil {
+		return nil, err
+	}
+
+	tail := &tailReader{
+		ctx:      ctx,
+		file:     file,
+		download: d,
+	}
+	// A canceled request wakes up the reader waiting for data.
+	tail.stop = context.AfterFunc(ctx, func() {
+		d.mu.Lock()
+		d.cond.Broadcast()
+		d.mu.Unlock()
+	})
+
+	if !compression {
+		return tail, nil
+	}
+
+	gzipR, err := gzip.NewReader(tail)
+	if err != nil {
+		_ = tail.Close()
+		return nil, fmt.Errorf("failed to create gzip reader: %w", err)
+	}
+	return ioutil.NewReaderWithCloser(gzipR, func() error {
+		_ = gzipR.Close()
+		return tail.Close()
+	}), nil
+}
+
+// open opens the file the download is written to. The file is opened under the lock, so commitDownload
+// cannot rename it in between, and the open file stays readable after the rename.
+func (d *download) open() (*os.File, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if d.err != nil {
+		return nil, d.err
+	}
+
+	path := d.partPath
+	if d.done {
+		path = d.filePath
+	}
+
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open cache file: %w", err)
+	}
+	return file, nil
+}
+
+type progressWriter struct {
+	file     *os.File
+	download *download
+}
+
+func (w *progressWriter) Write(p []byte) (int, error) {
+	n, err := w.file.Write(p)
+	w.download.advance(n)
+	return n, err
+}
+
+type tailReader struct {
+	ctx      context.Context
+	file     *os.File
+	download *download
+	offset   int64
+	stop     func() bool
+}
+
+func (t *tailReader) Read(p []byte) (int, error) {
+	for {
+		n, err := t.file.Read(p)
+		if n > 0 {
+			t.offset += int64(n)
+			return n, nil
+		}
+		if err != nil && err != io.EOF {
+			return 0, err
+		}
+		if err := t.download.wait(t.ctx, t.offset); err != nil {
+			return 0, err
+		}
+	}
+}
+
+func (t *tailReader) Close() error {
+	t.stop()
+	return t.file.Close()
+}
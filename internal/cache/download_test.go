@@ -0,0 +1,253 @@
+package cache
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"majmun/internal/config"
+	"majmun/internal/config/common"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func newTestCache(t *testing.T, compression bool) *Cache {
+	t.Helper()
+	cache, err := NewCache(config.CacheConfig{
+		Path:        t.TempDir(),
+		TTL:         common.Duration(time.Hour),
+		Retention:   common.Duration(24 * time.Hour),
+		Compression: compression,
+	})
+	if err != nil {
+		t.Fatalf("failed to create cache: %v", err)
+	}
+	t.Cleanup(cache.Close)
+	return cache
+}
+
+// slowOrigin serves content in two halves, holding the second one back until release is closed.
+func slowOrigin(content string, hits *atomic.Int32, release <-chan struct{}) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hits.Add(1)
+		half := len(content) / 2
+		_, _ = w.Write([]byte(content[:half]))
+		w.(http.Flusher).Flush()
+		<-release
+		_, _ = w.Write([]byte(content[half:]))
+	}))
+}
+
+func TestCache_ConcurrentMisses(t *testing.T) {
+	content := strings.Repeat("#EXTINF:-1,Channel\nhttp://example.com/stream\n", 2000)
+
+	for _, compression := range []bool{false, true} {
+		cache := newTestCache(t, compression)
+
+		var hits atomic.Int32
+		release := make(chan struct{})
+		server := slowOrigin(content, &hits, release)
+
+		const readers = 8
+		results := make([]string, readers)
+		errs := make([]error, readers)
+		opened := sync.WaitGroup{}
+		done := sync.WaitGroup{}
+
+		for i := range readers {
+			opened.Add(1)
+			done.Add(1)
+			go func() {
+				defer done.Done()
+				reader, err := cache.NewReader(context.Background(), server.URL)
+				opened.Done()
+				if err != nil {
+					errs[i] = err
+					return
+				}
+				defer func() { _ = reader.Close() }()
+
+				data, err := io.ReadAll(reader)
+				results[i], errs[i] = string(data), err
+			}()
+		}
+
+		opened.Wait()
+		close(release)
+		done.Wait()
+		server.Close()
+
+		if n := hits.Load(); n != 1 {
+			t.Errorf("compression %v: origin requested %d times, expected 1", compression, n)
+		}
+		for i := range readers {
+			if errs[i] != nil {
+				t.Errorf("compression %v: reader %d error = %v", compression, i, errs[i])
+			} else if results[i] != content {
+				t.Errorf("compression %v: reader %d got %d bytes, expected %d", compression, i, len(results[i]), len(content))
+			}
+		}
+
+		reader, err := cache.NewReader(context.Background(), server.URL)
+		if err != nil {
+			t.Fatalf("compression %v: cached read error = %v", compression, err)
+		}
+		data, _ := io.ReadAll(reader)
+		_ = reader.Close()
+		if !reader.fromCache || string(data) != content {
+			t.Errorf("compression %v: expected the complete download in the cache", compression)
+		}
+	}
+}
+
+func TestCache_DownloadOutlivesReader(t *testing.T) {
+	cache := newTestCache(t, false)
+	content := strings.Repeat("x", 64*1024)
+
+	var hits atomic.Int32
+	release := make(chan struct{})
+	server := slowOrigin(content, &hits, release)
+	defer server.Close()
+
+	leaving, err := cache.NewReader(context.Background(), server.URL)
+	if err != nil {
+		t.Fatalf("NewReader() error = %v", err)
+	}
+	staying, err := cache.NewReader(context.Background(), server.URL)
+	if err != nil {
+		t.Fatalf("NewReader() error = %v", err)
+	}
+	defer func() { _ = staying.Close() }()
+
+	_ = leaving.Close()
+	close(release)
+
+	data, err := io.ReadAll(staying)
+	if err != nil {
+		t.Fatalf("ReadAll() error = %v", err)
+	}
+	if string(data) != content {
+		t.Errorf("got %d bytes, expected %d", len(data), len(content))
+	}
+	if n := hits.Load(); n != 1 {
+		t.Errorf("origin requested %d times, expected 1", n)
+	}
+}
+
+func TestCache_CanceledReaderStopsWaiting(t *testing.T) {
+	cache := newTestCache(t, false)
+
+	var hits atomic.Int32
+	release := make(chan struct{})
+	server := slowOrigin("#EXTM3U\n", &hits, release)
+	defer server.Close()
+	defer close(release)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	reader, err := cache.NewReader(ctx, server.URL)
+	if err != nil {
+		t.Fatalf("NewReader() error = %v", err)
+	}
+	defer func() { _ = reader.Close() }()
+
+	buf := make([]byte, 64)
+	if _, err := reader.Read(buf); err != nil {
+		t.Fatalf("Read() error = %v", err)
+	}
+
+	readErr := make(chan error, 1)
+	go func() {
+		_, err := reader.Read(buf)
+		readErr <- err
+	}()
+
+	cancel()
+	select {
+	case err := <-readErr:
+		if err != context.Canceled {
+			t.Errorf("Read() error = %v, expected %v", err, context.Canceled)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("Read() did not return after the context was canceled")
+	}
+}
+
+func TestCache_FailedDownloadReachesAllReaders(t *testing.T) {
+	cache := newTestCache(t, false)
+
+	release := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Length", "1000")
+		_, _ = w.Write([]byte("#EXTM3U\n"))
+		w.(http.Flusher).Flush()
+		<-release
+	}))
+	defer server.Close()
+
+	var readers []*Reader
+	for range 3 {
+		reader, err := cache.NewReader(context.Background(), server.URL)
+		if err != nil {
+			t.Fatalf("NewReader() error = %v", err)
+		}
+		defer func() { _ = reader.Close() }()
+		readers = append(readers, reader)
+	}
+	close(release)
+
+	for i, reader := range readers {
+		if _, err := io.ReadAll(reader); err == nil {
+			t.Errorf("reader %d: expected error for incomplete download", i)
+		}
+	}
+
+	checkFileExists(t, readers[0].FilePath, false)
+	checkFileExists(t, readers[0].FilePath+partExtension, false)
+}
+
+func TestDownload_JoinAtCommit(t *testing.T) {
+	dir := t.TempDir()
+	content := "#EXTM3U\n"
+
+	for i := range 500 {
+		d := newDownload(filepath.Join(dir, fmt.Sprintf("entry%d%s", i, uncompressedExtension)))
+		if err := os.WriteFile(d.partPath, []byte(content), 0644); err != nil {
+			t.Fatalf("failed to write part file: %v", err)
+		}
+		d.advance(len(content))
+
+		joined := make(chan error, 1)
+		go func() {
+			reader, err := d.newReader(context.Background(), false)
+			if err != nil {
+				joined <- err
+				return
+			}
+			defer func() { _ = reader.Close() }()
+
+			data, err := io.ReadAll(reader)
+			if err == nil && string(data) != content {
+				err = fmt.Errorf("got %q", string(data))
+			}
+			joined <- err
+		}()
+
+		d.mu.Lock()
+		if err := os.Rename(d.partPath, d.filePath); err != nil {
+			t.Fatalf("failed to rename part file: %v", err)
+		}
+		d.done = true
+		d.cond.Broadcast()
+		d.mu.Unlock()
+
+		if err := <-joined; err != nil {
+			t.Fatalf("iteration %d: reader joining at commit failed: %v", i, err)
+		}
+	}
+}
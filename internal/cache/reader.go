@@ -27,42 +27,26 @@ type Metadata struct {
 }
 
 type Reader struct {
-	URL             string
-	Name            string
-	FilePath        string
-	MetaPath        string
-	ReadCloser      io.ReadCloser
-	file            *os.File
-	gzipWriter      *gzip.Writer
-	originResponse  *http.Response
-	client          *http.Client
-	contentLength   int64
-	downloadedBytes int64
-	contentType     string
-	ttl             time.Duration
-	compression     bool
-	eofReached      bool
+	URL            string
+	Name           string
+	FilePath       string
+	MetaPath       string
+	ReadCloser     io.ReadCloser
+	file           *os.File
+	originResponse *http.Response
+	download       *download
+	client         *http.Client
+	ttl            time.Duration
+	compression    bool
+	cachedAt       time.Time
+	fromCache      bool
 }
 
 func (r *Reader) Read(p []byte) (n int, err error) {
-	n, err = r.ReadCloser.Read(p)
-	if err == io.EOF {
-		r.eofReached = true
-	}
-	return
+	return r.ReadCloser.Read(p)
 }
 
 func (r *Reader) Close() error {
-	if r.originResponse != nil {
-		if r.isDownloadComplete() {
-			if err := r.SaveMetadata(); err != nil {
-				return err
-			}
-		} else {
-			r.Cleanup()
-		}
-	}
-
 	var closers []func() error
 
 	if r.originResponse != nil {
@@ -71,10 +55,8 @@ func (r *Reader) Close() error {
 	if r.ReadCloser != nil {
 		closers = append(closers, r.ReadCloser.Close)
 	}
-	if r.gzipWriter != nil {
-		closers = append(closers, r.gzipWriter.Close)
-	}
-	if r.file != nil {
+	// An uncompressed cached copy is read from the file directly, which must not be closed twice.
+	if r.file != nil && r.ReadCloser != io.ReadCloser(r.file) {
 		closers = append(closers, r.file.Close)
 	}
 
@@ -88,6 +70,14 @@ func (r *Reader) Close() error {
 	return firstErr
 }
 
+// Age returns how long ago the served data was downloaded, if it came from the cache.
+func (r *Reader) Age() (time.Duration, bool) {
+	if !r.fromCache {
+		return 0, false
+	}
+	return time.Since(r.cachedAt), true
+}
+
 func (r *Reader) getCachedHeaders() map[string]string {
 	meta, err := readMetadata(r.MetaPath)
 	if err != nil {
@@ -96,33 +86,28 @@ func (r *Reader) getCachedHeaders() map[string]string {
 	return meta.Headers
 }
 
-func (r *Reader) createCacheFile() error {
-	_ = os.Remove(r.FilePath)
-	file, err := os.Create(r.FilePath)
-	if err != nil {
-		return fmt.Errorf("failed to create cache file: %w", err)
+// originHeader returns the headers of the origin response the data comes from, if any.
+func (r *Reader) originHeader() http.Header {
+	if r.download != nil {
+		return r.download.header
+	}
+	if r.originResponse != nil {
+		return r.originResponse.Header
 	}
-
-	r.file = file
 	return nil
 }
 
-func (r *Reader) isGzippedContent(resp *http.Response) bool {
+func isGzippedContent(resp *http.Response, url string) bool {
 	contentType := resp.Header.Get("Content-Type")
 	return contentType == "application/gzip" ||
 		contentType == "application/x-gzip" ||
 		resp.Header.Get("Content-Encoding") == "gzip" ||
-		strings.HasSuffix(r.URL, ".gz")
-}
-
-func (r *Reader) isDownloadComplete() bool {
-	if r.contentLength <= 0 {
-		return r.eofReached
-	}
-	return r.downloadedBytes == r.contentLength && r.eofReached
+		strings.HasSuffix(url, ".gz")
 }
 
-func (r *Reader) checkCacheStatus() status {
+// checkCacheStatus tells whether the cached copy can be served. With refresh, the copy is always revalidated
+// with the origin, ignoring the TTL and the Expires header.
+func (r *Reader) checkCacheStatus(refresh bool) status {
 	if _, err := os.Stat(r.MetaPath); err != nil {
 		return statusNotFound
 	}
@@ -134,6 +119,11 @@ func (r *Reader) checkCacheStatus() status {
 	if err != nil {
 		return statusNotFound
 	}
+	r.cachedAt = time.Unix(meta.CachedAt, 0)
+
+	if refresh {
+		return r.tryRenewal(&meta)
+	}
 
 	if r.ttl > 0 && time.Since(time.Unix(meta.CachedAt, 0)) < r.ttl {
 		return statusValid
@@ -220,6 +210,7 @@ func (r *Reader) newCachedReader() (io.ReadCloser, error) {
 	}
 
 	r.file = file
+	r.fromCache = true
 
 	if r.compression {
 		gzipR, err := gzip.NewReader(file)
@@ -250,9 +241,8 @@ func (r *Reader) newDirectReader(ctx context.Context) (io.ReadCloser, error) {
 	}
 
 	r.originResponse = resp
-	r.contentType = resp.Header.Get("Content-Type")
 
-	if r.isGzippedContent(resp) {
+	if isGzippedContent(resp, r.URL) {
 		gzipReader, err := gzip.NewReader(resp.Body)
 		if err != nil {
 			_ = resp.Body.Close()
@@ -264,72 +254,6 @@ func (r *Reader) newDirectReader(ctx context.Context) (io.ReadCloser, error) {
 	}
 }
 
-func (r *Reader) newCachingReader(ctx context.Context) (io.ReadCloser, error) {
-	req, err := http.NewRequestWithContext(ctx, "GET", r.URL, nil)
-	if err != nil {
-		return nil, fmt.Errorf("failed to create request: %w", err)
-	}
-
-	resp, err := r.client.Do(req)
-	if err != nil {
-		return nil, fmt.Errorf("failed to fetch URL: %w", err)
-	}
-
-	if resp.StatusCode != http.StatusOK {
-		_ = resp.Body.Close()
-		return nil, fmt.Errorf("unexpected status code: %d", resp.StatusCode)
-	}
-
-	r.originResponse = resp
-	r.contentType = resp.Header.Get("Content-Type")
-	r.contentLength = resp.ContentLength
-
-	err = r.createCacheFile()
-	if err != nil {
-		_ = resp.Body.Close()
-		return nil, err
-	}
-
-	var reader io.ReadCloser
-	countReader := ioutil.NewCountReadCloser(resp.Body, &r.downloadedBytes)
-
-	if r.compression {
-		if r.isGzippedContent(resp) {
-			tee := io.TeeReader(countReader, r.file)
-			gzipReader, err := gzip.NewReader(tee)
-			if err != nil {
-				_ = r.file.Close()
-				_ = countReader.Close()
-				return nil, fmt.Errorf("failed to create gzip reader: %w", err)
-			}
-			reader = ioutil.NewReaderWithCloser(gzipReader, gzipReader.Close)
-		} else {
-			gzipW, err := gzip.NewWriterLevel(r.file, gzip.BestSpeed)
-			if err != nil {
-				_ = r.file.Close()
-				_ = countReader.Close()
-				return nil, fmt.Errorf("failed to create gzip writer: %w", err)
-			}
-			r.gzipWriter = gzipW
-			reader = ioutil.NewReaderWithCloser(io.TeeReader(countReader, gzipW), gzipW.Close)
-		}
-	} else {
-		if r.isGzippedContent(resp) {
-			gzipReader, err := gzip.NewReader(countReader)
-			if err != nil {
-				_ = r.file.Close()
-				_ = countReader.Close()
-				return nil, fmt.Errorf("failed to create gzip reader: %w", err)
-			}
-			reader = ioutil.NewReaderWithCloser(io.TeeReader(gzipReader, r.file), gzipReader.Close)
-		} else {
-			reader = ioutil.NewReaderWithCloser(io.TeeReader(countReader, r.file), countReader.Close)
-		}
-	}
-
-	return reader, nil
-}
-
 func formatCacheStatus(status status) string {
 	switch status {
 	case statusValid:
@@ -1,8 +1,14 @@
 package cache
 
 import (
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"io"
 	"net/http"
 	"net/http/httptest"
+	"os"
+	"path/filepath"
 	"testing"
 	"time"
 
@@ -262,3 +268,52 @@ func TestReader_tryRenewal(t *testing.T) {
 		})
 	}
 }
+
+func TestReader_CloseCachedCopy(t *testing.T) {
+	for _, compression := range []bool{false, true} {
+		dir := t.TempDir()
+		reader := &Reader{
+			FilePath:    filepath.Join(dir, "entry"+uncompressedExtension),
+			compression: compression,
+		}
+
+		data := []byte("#EXTM3U\n")
+		if compression {
+			var buf bytes.Buffer
+			gz := gzip.NewWriter(&buf)
+			_, _ = gz.Write(data)
+			_ = gz.Close()
+			data = buf.Bytes()
+		}
+		assert.NoError(t, os.WriteFile(reader.FilePath, data, 0644))
+
+		readCloser, err := reader.newCachedReader()
+		assert.NoError(t, err)
+		reader.ReadCloser = readCloser
+
+		_, err = io.ReadAll(reader)
+		assert.NoError(t, err)
+		assert.NoError(t, reader.Close(), "compression: %v", compression)
+	}
+}
+
+func TestReader_tryRenewalKeepsValidators(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNotModified)
+	}))
+	defer server.Close()
+
+	lastModified := time.Now().Add(-time.Hour).UTC().Format(time.RFC1123)
+	metaPath := filepath.Join(t.TempDir(), "entry"+metaExtension)
+	meta := Metadata{Headers: map[string]string{"Last-Modified": lastModified}}
+	data, err := json.Marshal(meta)
+	assert.NoError(t, err)
+	assert.NoError(t, os.WriteFile(metaPath, data, 0644))
+
+	reader := &Reader{URL: server.URL, MetaPath: metaPath, client: server.Client()}
+	assert.Equal(t, statusRenewed, reader.tryRenewal(&meta))
+
+	renewed, err := readMetadata(metaPath)
+	assert.NoError(t, err)
+	assert.Equal(t, lastModified, renewed.Headers["Last-Modified"])
+}
@@ -3,6 +3,8 @@ package cache
 import (
 	"encoding/json"
 	"fmt"
+	"maps"
+	"net/http"
 	"os"
 	"time"
 )
@@ -12,14 +14,21 @@ var forwardedHeaders = []string{
 }
 
 func (r *Reader) SaveMetadata() error {
+	headers := make(map[string]string, len(forwardedHeaders))
+
+	// A 304 response may leave out the validators, so the ones of the renewed copy are kept.
+	if r.originResponse != nil && r.originResponse.StatusCode == http.StatusNotModified {
+		if meta, err := readMetadata(r.MetaPath); err == nil {
+			maps.Copy(headers, meta.Headers)
+		}
+	}
+
 	metaFile, err := os.Create(r.MetaPath)
 	if err != nil {
 		return err
 	}
 	defer func() { _ = metaFile.Close() }()
 
-	headers := make(map[string]string, len(forwardedHeaders))
-
 	if r.originResponse != nil {
 		for _, header := range forwardedHeaders {
 			if value := r.originResponse.Header.Get(header); value != "" {
@@ -28,17 +37,14 @@ func (r *Reader) SaveMetadata() error {
 		}
 	}
 
+	r.cachedAt = time.Now()
+
 	return json.NewEncoder(metaFile).Encode(Metadata{
-		CachedAt: time.Now().Unix(),
+		CachedAt: r.cachedAt.Unix(),
 		Headers:  headers,
 	})
 }
 
-func (r *Reader) Cleanup() {
-	_ = os.Remove(r.FilePath)
-	_ = os.Remove(r.MetaPath)
-}
-
 func readMetadata(metaPath string) (Metadata, error) {
 	metaFile, err := os.Open(metaPath)
 	if err != nil {
@@ -2,14 +2,16 @@ package cache
 
 import (
 	"net/http"
+	"strconv"
 )
 
 type cachingTransport struct {
-	cache *Cache
+	cache   *Cache
+	refresh bool
 }
 
 func (t *cachingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
-	reader, err := t.cache.NewReader(req.Context(), req.URL.String())
+	reader, err := t.cache.newReader(req.Context(), req.URL.String(), t.refresh)
 	if err != nil {
 		return nil, err
 	}
@@ -25,9 +27,9 @@ func (t *cachingTransport) RoundTrip(req *http.Request) (*http.Response, error)
 		Close:         false,
 	}
 
-	if reader.originResponse != nil {
+	if originHeader := reader.originHeader(); originHeader != nil {
 		for _, header := range forwardedHeaders {
-			if value := reader.originResponse.Header.Get(header); value != "" {
+			if value := originHeader.Get(header); value != "" {
 				resp.Header.Set(header, value)
 			}
 		}
@@ -37,5 +39,10 @@ func (t *cachingTransport) RoundTrip(req *http.Request) (*http.Response, error)
 		}
 	}
 
+	// Age tells how old a cached response is, which is how stale data shows up in the source freshness.
+	if age, ok := reader.Age(); ok {
+		resp.Header.Set("Age", strconv.Itoa(int(age.Seconds())))
+	}
+
 	return resp, nil
 }
@@ -0,0 +1,30 @@
+package config
+
+import (
+	"fmt"
+	"majmun/internal/config/common"
+)
+
+// Account is a set of provider credentials with its own limit of concurrent streams.
+type Account struct {
+	Name              string             `yaml:"name"`
+	URL               *common.Template   `yaml:"url,omitempty"`
+	TemplateVars      []common.NameValue `yaml:"template_variables,omitempty"`
+	ConcurrentStreams int64              `yaml:"concurrency"`
+}
+
+func (a *Account) Validate() error {
+	if a.Name == "" {
+		return fmt.Errorf("account name is required")
+	}
+	if a.ConcurrentStreams <= 0 {
+		return fmt.Errorf("account %s: concurrency must be greater than 0", a.Name)
+	}
+	for i, v := range a.TemplateVars {
+		if err := v.Validate(); err != nil {
+			return fmt.Errorf("account %s: template_variables[%d]: %w", a.Name, i, err)
+		}
+	}
+
+	return nil
+}
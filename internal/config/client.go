@@ -12,6 +12,8 @@ type Client struct {
 	Playlists common.StringOrArr `yaml:"playlists"`
 	EPGs      common.StringOrArr `yaml:"epgs"`
 	Proxy     proxy.Proxy        `yaml:"proxy,omitempty"`
+	Priority  int                `yaml:"priority,omitempty"`
+	HDHomeRun HDHomeRun          `yaml:"hdhomerun,omitempty"`
 }
 
 func (c *Client) Validate(playlistNames, epgNames map[string]bool) error {
@@ -34,5 +36,9 @@ func (c *Client) Validate(playlistNames, epgNames map[string]bool) error {
 		}
 	}
 
+	if err := c.HDHomeRun.Validate(); err != nil {
+		return err
+	}
+
 	return nil
 }
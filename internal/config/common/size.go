@@ -0,0 +1,43 @@
+package common
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+
+	"gopkg.in/yaml.v3"
+)
+
+type ByteSize int64
+
+func (s *ByteSize) UnmarshalYAML(value *yaml.Node) error {
+	var sizeStr string
+	if err := value.Decode(&sizeStr); err != nil {
+		return err
+	}
+
+	re := regexp.MustCompile(`^(\d+)\s*(B|KB|MB|GB)?$`)
+	matches := re.FindStringSubmatch(sizeStr)
+
+	if matches == nil {
+		return fmt.Errorf("invalid size format: %s", sizeStr)
+	}
+
+	val, err := strconv.ParseInt(matches[1], 10, 64)
+	if err != nil {
+		return fmt.Errorf("invalid size value: %s", matches[1])
+	}
+
+	switch matches[2] {
+	case "", "B":
+		*s = ByteSize(val)
+	case "KB":
+		*s = ByteSize(val << 10)
+	case "MB":
+		*s = ByteSize(val << 20)
+	case "GB":
+		*s = ByteSize(val << 30)
+	}
+
+	return nil
+}
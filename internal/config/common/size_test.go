@@ -0,0 +1,70 @@
+package common
+
+import (
+	"testing"
+
+	"gopkg.in/yaml.v3"
+)
+
+func TestByteSize_UnmarshalYAML(t *testing.T) {
+	tests := []struct {
+		name     string
+		yamlData string
+		expected ByteSize
+		wantErr  bool
+	}{
+		{
+			name:     "bytes",
+			yamlData: `1024`,
+			expected: ByteSize(1024),
+		},
+		{
+			name:     "kilobytes",
+			yamlData: `512KB`,
+			expected: ByteSize(512 * 1024),
+		},
+		{
+			name:     "megabytes",
+			yamlData: `32MB`,
+			expected: ByteSize(32 * 1024 * 1024),
+		},
+		{
+			name:     "gigabytes",
+			yamlData: `1GB`,
+			expected: ByteSize(1024 * 1024 * 1024),
+		},
+		{
+			name:     "invalid unit",
+			yamlData: `10TB`,
+			wantErr:  true,
+		},
+		{
+			name:     "invalid format",
+			yamlData: `MB`,
+			wantErr:  true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var s ByteSize
+			err := yaml.Unmarshal([]byte(tt.yamlData), &s)
+
+			if tt.wantErr {
+				if err == nil {
+					t.Errorf("expected error, got nil")
+				}
+				return
+			}
+
+			if err != nil {
+				t.Errorf("unexpected error: %v", err)
+				return
+			}
+
+			if s != tt.expected {
+				t.Errorf("expected %v, got %v", tt.expected, s)
+			}
+		})
+	}
+}
@@ -0,0 +1,23 @@
+package config
+
+import (
+	"fmt"
+)
+
+// ConcurrencyGroup limits the upstream streams of the channels a set_concurrency_group rule puts into it.
+type ConcurrencyGroup struct {
+	Name              string `yaml:"name"`
+	ConcurrentStreams int64  `yaml:"concurrency"`
+}
+
+func (g *ConcurrencyGroup) Validate() error {
+	if g.Name == "" {
+		return fmt.Errorf("concurrency group name is required")
+	}
+
+	if g.ConcurrentStreams <= 0 {
+		return fmt.Errorf("concurrency group %s: concurrency must be greater than 0", g.Name)
+	}
+
+	return nil
+}
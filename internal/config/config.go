@@ -7,20 +7,24 @@ import (
 	"majmun/internal/config/rules/channel"
 	"majmun/internal/config/rules/playlist"
 	"strings"
+	"time"
 )
 
 type Config struct {
-	YamlSnippets  map[string]any     `yaml:",inline"`
-	Server        ServerConfig       `yaml:"server"`
-	Logs          Logs               `yaml:"logs"`
-	URLGenerator  URLGeneratorConfig `yaml:"url_generator"`
-	Cache         CacheConfig        `yaml:"cache"`
-	Proxy         proxy.Proxy        `yaml:"proxy"`
-	Clients       []Client           `yaml:"clients"`
-	Playlists     []Playlist         `yaml:"playlists"`
-	EPGs          []EPG              `yaml:"epgs"`
-	ChannelRules  channel.Rules      `yaml:"channel_rules,omitempty"`
-	PlaylistRules playlist.Rules     `yaml:"playlist_rules,omitempty"`
+	YamlSnippets      map[string]any     `yaml:",inline"`
+	Server            ServerConfig       `yaml:"server"`
+	Logs              Logs               `yaml:"logs"`
+	URLGenerator      URLGeneratorConfig `yaml:"url_generator"`
+	Cache             CacheConfig        `yaml:"cache"`
+	Refresh           RefreshConfig      `yaml:"refresh"`
+	Proxy             proxy.Proxy        `yaml:"proxy"`
+	ProxyProfiles     []ProxyProfile     `yaml:"proxy_profiles,omitempty"`
+	ConcurrencyGroups []ConcurrencyGroup `yaml:"concurrency_groups,omitempty"`
+	Clients           []Client           `yaml:"clients"`
+	Playlists         []Playlist         `yaml:"playlists"`
+	EPGs              []EPG              `yaml:"epgs"`
+	ChannelRules      channel.Rules      `yaml:"channel_rules,omitempty"`
+	PlaylistRules     playlist.Rules     `yaml:"playlist_rules,omitempty"`
 }
 
 func (c *Config) Validate() error {
@@ -46,10 +50,36 @@ func (c *Config) Validate() error {
 		return fmt.Errorf("cache configuration validation failed: %w", err)
 	}
 
+	if err := c.Refresh.Validate(); err != nil {
+		return fmt.Errorf("refresh configuration validation failed: %w", err)
+	}
+
 	if err := c.Proxy.Validate(); err != nil {
 		return fmt.Errorf("proxy configuration validation failed: %w", err)
 	}
 
+	profileNames := make(map[string]bool)
+	for i, profile := range c.ProxyProfiles {
+		if err := profile.Validate(); err != nil {
+			return fmt.Errorf("proxy_profiles[%d] validation failed: %w", i, err)
+		}
+		if profileNames[profile.Name] {
+			return fmt.Errorf("duplicate proxy profile name: %s", profile.Name)
+		}
+		profileNames[profile.Name] = true
+	}
+
+	groupNames := make(map[string]bool)
+	for i, group := range c.ConcurrencyGroups {
+		if err := group.Validate(); err != nil {
+			return fmt.Errorf("concurrency_groups[%d] validation failed: %w", i, err)
+		}
+		if groupNames[group.Name] {
+			return fmt.Errorf("duplicate concurrency group name: %s", group.Name)
+		}
+		groupNames[group.Name] = true
+	}
+
 	playlistNames := make(map[string]bool)
 	epgNames := make(map[string]bool)
 
@@ -57,6 +87,9 @@ func (c *Config) Validate() error {
 		if err := pl.Validate(); err != nil {
 			return fmt.Errorf("playlist[%d] validation failed: %w", i, err)
 		}
+		if err := c.validateRefreshInterval(pl.RefreshInterval); err != nil {
+			return fmt.Errorf("playlist[%d] validation failed: %w", i, err)
+		}
 		if pl.Name != "" {
 			if playlistNames[pl.Name] {
 				return fmt.Errorf("duplicate playlist name: %s", pl.Name)
@@ -69,6 +102,9 @@ func (c *Config) Validate() error {
 		if err := epg.Validate(); err != nil {
 			return fmt.Errorf("epg[%d] validation failed: %w", i, err)
 		}
+		if err := c.validateRefreshInterval(epg.RefreshInterval); err != nil {
+			return fmt.Errorf("epg[%d] validation failed: %w", i, err)
+		}
 		if epg.Name != "" {
 			if epgNames[epg.Name] {
 				return fmt.Errorf("duplicate EPG name: %s", epg.Name)
@@ -105,7 +141,7 @@ func (c *Config) Validate() error {
 		if err := rule.Validate(); err != nil {
 			return fmt.Errorf("channel_rules[%d] validation failed: %w", i, err)
 		}
-		if err := c.validateChannelRuleReferences(rule, clientNames, playlistNames); err != nil {
+		if err := c.validateChannelRuleReferences(rule, clientNames, playlistNames, profileNames, groupNames); err != nil {
 			return fmt.Errorf("channel_rules[%d] reference validation failed: %w", i, err)
 		}
 	}
@@ -122,7 +158,18 @@ func (c *Config) Validate() error {
 	return nil
 }
 
-func (c *Config) validateChannelRuleReferences(rule *channel.Rule, clientNames, playlistNames map[string]bool) error {
+// validateRefreshInterval makes sure a scheduled refresh, with the largest jitter, always runs before
+// the cached copy expires, so client requests never wait for the origin.
+func (c *Config) validateRefreshInterval(interval common.Duration) error {
+	if interval > 0 && interval+c.Refresh.Jitter >= c.Cache.TTL {
+		return fmt.Errorf("refresh_interval %s plus refresh jitter %s must be shorter than the cache ttl %s",
+			time.Duration(interval), time.Duration(c.Refresh.Jitter), time.Duration(c.Cache.TTL))
+	}
+	return nil
+}
+
+func (c *Config) validateChannelRuleReferences(
+	rule *channel.Rule, clientNames, playlistNames, profileNames, groupNames map[string]bool) error {
 	if rule.SetField != nil && rule.SetField.Condition != nil {
 		return c.validateConditionReferences(*rule.SetField.Condition, clientNames, playlistNames)
 	}
@@ -135,6 +182,22 @@ func (c *Config) validateChannelRuleReferences(rule *channel.Rule, clientNames,
 	if rule.MarkHidden != nil && rule.MarkHidden.Condition != nil {
 		return c.validateConditionReferences(*rule.MarkHidden.Condition, clientNames, playlistNames)
 	}
+	if rule.SetProxyProfile != nil {
+		if !profileNames[rule.SetProxyProfile.Profile] {
+			return fmt.Errorf("rule references unknown proxy profile: %s", rule.SetProxyProfile.Profile)
+		}
+		if rule.SetProxyProfile.Condition != nil {
+			return c.validateConditionReferences(*rule.SetProxyProfile.Condition, clientNames, playlistNames)
+		}
+	}
+	if rule.SetConcurrencyGroup != nil {
+		if !groupNames[rule.SetConcurrencyGroup.Group] {
+			return fmt.Errorf("rule references unknown concurrency group: %s", rule.SetConcurrencyGroup.Group)
+		}
+		if rule.SetConcurrencyGroup.Condition != nil {
+			return c.validateConditionReferences(*rule.SetConcurrencyGroup.Condition, clientNames, playlistNames)
+		}
+	}
 	return nil
 }
 
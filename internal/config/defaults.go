@@ -13,6 +13,8 @@ func DefaultConfig() *Config {
 		panic(err)
 	}
 
+	failoverEnabled := true
+
 	return &Config{
 		Server: ServerConfig{
 			ListenAddr: ":8080",
@@ -32,7 +34,28 @@ func DefaultConfig() *Config {
 			Retention:   common.Duration(24 * time.Hour * 30),
 			Compression: false,
 		},
+		Refresh: RefreshConfig{
+			Concurrency: 2,
+			Jitter:      common.Duration(time.Minute),
+		},
 		Proxy: proxy.Proxy{
+			Output: proxy.OutputMPEGTS,
+			HLS: proxy.HLS{
+				SegmentDuration: common.Duration(6 * time.Second),
+				PlaylistSize:    6,
+				IdleTimeout:     common.Duration(30 * time.Second),
+			},
+			Failover: proxy.Failover{
+				Enabled:      &failoverEnabled,
+				Restarts:     3,
+				StallTimeout: common.Duration(10 * time.Second),
+			},
+			ReplayBuffer: common.ByteSize(32 * 1024 * 1024),
+			Probe:        proxy.ProbeMPEGTS,
+			SlowClient: proxy.SlowClient{
+				Policy:  proxy.SlowClientDrop,
+				Timeout: common.Duration(5 * time.Second),
+			},
 			Stream: proxy.Handler{
 				Command: common.StringOrArr{
 					"ffmpeg",
@@ -83,6 +106,16 @@ func DefaultConfig() *Config {
 						{Name: "message", Value: "Unable to play stream\n\nPlease try again later or contact administrator"},
 					},
 				},
+				Waiting: proxy.Handler{
+					TemplateVars: []common.NameValue{
+						{Name: "message", Value: "Waiting for a free slot\n\nThe stream will start shortly"},
+					},
+				},
+				Preempted: proxy.Handler{
+					TemplateVars: []common.NameValue{
+						{Name: "message", Value: "Stream taken over by another device\n\nPlease try again later"},
+					},
+				},
 			},
 		},
 	}
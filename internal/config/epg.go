@@ -7,9 +7,10 @@ import (
 )
 
 type EPG struct {
-	Name    string             `yaml:"name"`
-	Sources common.StringOrArr `yaml:"sources"`
-	Proxy   proxy.Proxy        `yaml:"proxy,omitempty"`
+	Name            string             `yaml:"name"`
+	Sources         common.StringOrArr `yaml:"sources"`
+	Proxy           proxy.Proxy        `yaml:"proxy,omitempty"`
+	RefreshInterval common.Duration    `yaml:"refresh_interval,omitempty"`
 }
 
 func (e *EPG) Validate() error {
@@ -24,5 +25,8 @@ func (e *EPG) Validate() error {
 			return fmt.Errorf("EPG source[%d] cannot be empty", i)
 		}
 	}
+	if e.RefreshInterval < 0 {
+		return fmt.Errorf("EPG refresh_interval cannot be negative")
+	}
 	return nil
 }
@@ -0,0 +1,35 @@
+package config
+
+import (
+	"encoding/hex"
+	"fmt"
+
+	"gopkg.in/yaml.v3"
+)
+
+type HDHomeRun struct {
+	Enabled      bool   `yaml:"enabled"`
+	DeviceID     string `yaml:"device_id"`
+	FriendlyName string `yaml:"friendly_name"`
+}
+
+func (h *HDHomeRun) Validate() error {
+	if h.DeviceID == "" {
+		return nil
+	}
+	if _, err := hex.DecodeString(h.DeviceID); err != nil || len(h.DeviceID) != 8 {
+		return fmt.Errorf("hdhomerun device_id must be 8 hex characters")
+	}
+	return nil
+}
+
+func (h *HDHomeRun) UnmarshalYAML(value *yaml.Node) error {
+	var enabled bool
+	if err := value.Decode(&enabled); err == nil {
+		h.Enabled = enabled
+		return nil
+	}
+
+	type hdhomerunYAML HDHomeRun
+	return value.Decode((*hdhomerunYAML)(h))
+}
@@ -1,9 +1,11 @@
 package config
 
 import (
+	"majmun/internal/config/common"
 	"os"
 	"path/filepath"
 	"testing"
+	"time"
 )
 
 func TestLoad(t *testing.T) {
@@ -65,6 +67,191 @@ url_generator:
 				}
 			},
 		},
+		{
+			name: "proxy profile selected by channel rule",
+			configContent: `server:
+  listen_addr: ":8080"
+  public_url: "http://example.com"
+url_generator:
+  secret: "test-secret"
+proxy_profiles:
+  - name: radio
+    proxy:
+      stream:
+        command: ["ffmpeg", "-i", "{{ .url }}", "-vn", "-f", "mpegts", "pipe:1"]
+channel_rules:
+  - set_proxy_profile:
+      profile: radio
+      condition:
+        selector: attr/group-title
+        patterns: ["^Radio$"]`,
+			expectError: false,
+			validate: func(t *testing.T, cfg *Config) {
+				if len(cfg.ProxyProfiles) != 1 || len(cfg.ProxyProfiles[0].Proxy.Stream.Command) != 7 {
+					t.Errorf("unexpected proxy profiles: %+v", cfg.ProxyProfiles)
+				}
+				if len(cfg.ChannelRules) != 1 || cfg.ChannelRules[0].SetProxyProfile == nil ||
+					cfg.ChannelRules[0].SetProxyProfile.Profile != "radio" {
+					t.Errorf("unexpected channel rules: %+v", cfg.ChannelRules)
+				}
+			},
+		},
+		{
+			name: "unknown proxy profile",
+			configContent: `server:
+  listen_addr: ":8080"
+  public_url: "http://example.com"
+url_generator:
+  secret: "test-secret"
+channel_rules:
+  - set_proxy_profile:
+      profile: missing`,
+			expectError: true,
+			validate:    nil,
+		},
+		{
+			name: "concurrency group selected by channel rule",
+			configContent: `server:
+  listen_addr: ":8080"
+  public_url: "http://example.com"
+url_generator:
+  secret: "test-secret"
+concurrency_groups:
+  - name: sports
+    concurrency: 2
+channel_rules:
+  - set_concurrency_group:
+      group: sports
+      condition:
+        selector: attr/group-title
+        patterns: ["^Sports$"]`,
+			expectError: false,
+			validate: func(t *testing.T, cfg *Config) {
+				if len(cfg.ConcurrencyGroups) != 1 || cfg.ConcurrencyGroups[0].ConcurrentStreams != 2 {
+					t.Errorf("unexpected concurrency groups: %+v", cfg.ConcurrencyGroups)
+				}
+				if len(cfg.ChannelRules) != 1 || cfg.ChannelRules[0].SetConcurrencyGroup == nil ||
+					cfg.ChannelRules[0].SetConcurrencyGroup.Group != "sports" {
+					t.Errorf("unexpected channel rules: %+v", cfg.ChannelRules)
+				}
+			},
+		},
+		{
+			name: "unknown concurrency group",
+			configContent: `server:
+  listen_addr: ":8080"
+  public_url: "http://example.com"
+url_generator:
+  secret: "test-secret"
+channel_rules:
+  - set_concurrency_group:
+      group: missing`,
+			expectError: true,
+			validate:    nil,
+		},
+		{
+			name: "concurrency group without concurrency",
+			configContent: `server:
+  listen_addr: ":8080"
+  public_url: "http://example.com"
+url_generator:
+  secret: "test-secret"
+concurrency_groups:
+  - name: sports`,
+			expectError: true,
+			validate:    nil,
+		},
+		{
+			name: "playlist accounts",
+			configContent: `server:
+  listen_addr: ":8080"
+  public_url: "http://example.com"
+url_generator:
+  secret: "test-secret"
+playlists:
+  - name: provider
+    sources: ["http://provider.com/get.php?username=main&password=secret"]
+    accounts:
+      - name: main
+        concurrency: 1
+      - name: second
+        concurrency: 2
+        url: '{{ .url | replace "main" .username }}'
+        template_variables:
+          - name: username
+            value: second`,
+			expectError: false,
+			validate: func(t *testing.T, cfg *Config) {
+				accounts := cfg.Playlists[0].Accounts
+				if len(accounts) != 2 || accounts[1].URL == nil || accounts[1].ConcurrentStreams != 2 {
+					t.Errorf("unexpected accounts: %+v", accounts)
+				}
+			},
+		},
+		{
+			name: "scheduled source refresh",
+			configContent: `server:
+  listen_addr: ":8080"
+  public_url: "http://example.com"
+url_generator:
+  secret: "test-secret"
+refresh:
+  concurrency: 1
+  jitter: 30s
+playlists:
+  - name: provider
+    sources: ["http://provider.com/playlist.m3u"]
+    refresh_interval: 6h
+epgs:
+  - name: guide
+    sources: ["http://provider.com/epg.xml"]
+    refresh_interval: 12h`,
+			expectError: false,
+			validate: func(t *testing.T, cfg *Config) {
+				if cfg.Refresh.Concurrency != 1 || cfg.Refresh.Jitter != common.Duration(30*time.Second) {
+					t.Errorf("unexpected refresh config: %+v", cfg.Refresh)
+				}
+				if cfg.Playlists[0].RefreshInterval != common.Duration(6*time.Hour) {
+					t.Errorf("unexpected playlist refresh interval: %v", cfg.Playlists[0].RefreshInterval)
+				}
+				if cfg.EPGs[0].RefreshInterval != common.Duration(12*time.Hour) {
+					t.Errorf("unexpected EPG refresh interval: %v", cfg.EPGs[0].RefreshInterval)
+				}
+			},
+		},
+		{
+			name: "refresh interval not shorter than cache ttl",
+			configContent: `server:
+  listen_addr: ":8080"
+  public_url: "http://example.com"
+url_generator:
+  secret: "test-secret"
+cache:
+  ttl: 6h
+refresh:
+  jitter: 5m
+epgs:
+  - name: guide
+    sources: ["http://provider.com/epg.xml"]
+    refresh_interval: 6h`,
+			expectError: true,
+			validate:    nil,
+		},
+		{
+			name: "account without concurrency",
+			configContent: `server:
+  listen_addr: ":8080"
+  public_url: "http://example.com"
+url_generator:
+  secret: "test-secret"
+playlists:
+  - name: provider
+    sources: ["http://provider.com/playlist.m3u"]
+    accounts:
+      - name: main`,
+			expectError: true,
+			validate:    nil,
+		},
 	}
 
 	for _, tt := range tests {
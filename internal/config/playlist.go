@@ -7,9 +7,12 @@ import (
 )
 
 type Playlist struct {
-	Name    string             `yaml:"name"`
-	Sources common.StringOrArr `yaml:"sources"`
-	Proxy   proxy.Proxy        `yaml:"proxy,omitempty"`
+	Name            string             `yaml:"name"`
+	Sources         common.StringOrArr `yaml:"sources"`
+	Proxy           proxy.Proxy        `yaml:"proxy,omitempty"`
+	Accounts        []Account          `yaml:"accounts,omitempty"`
+	Required        bool               `yaml:"required,omitempty"`
+	RefreshInterval common.Duration    `yaml:"refresh_interval,omitempty"`
 }
 
 func (p *Playlist) Validate() error {
@@ -25,5 +28,20 @@ func (p *Playlist) Validate() error {
 		}
 	}
 
+	if p.RefreshInterval < 0 {
+		return fmt.Errorf("playlist refresh_interval cannot be negative")
+	}
+
+	accountNames := make(map[string]bool, len(p.Accounts))
+	for i, account := range p.Accounts {
+		if err := account.Validate(); err != nil {
+			return fmt.Errorf("accounts[%d]: %w", i, err)
+		}
+		if accountNames[account.Name] {
+			return fmt.Errorf("duplicate account name: %s", account.Name)
+		}
+		accountNames[account.Name] = true
+	}
+
 	return nil
 }
@@ -7,9 +7,17 @@ type Error struct {
 	UpstreamError     Handler `yaml:"upstream_error"`
 	RateLimitExceeded Handler `yaml:"rate_limit_exceeded"`
 	LinkExpired       Handler `yaml:"link_expired"`
+	Waiting           Handler `yaml:"waiting"`
+	Preempted         Handler `yaml:"preempted"`
 }
 
 func (e *Error) Validate() error {
+	for _, h := range []Handler{e.Handler, e.UpstreamError, e.RateLimitExceeded, e.LinkExpired, e.Waiting, e.Preempted} {
+		if h.Mode != "" && h.Mode != StreamModeCommand {
+			return fmt.Errorf("error handlers only support the %s mode", StreamModeCommand)
+		}
+	}
+
 	if err := e.Handler.Validate(); err != nil {
 		return fmt.Errorf("error inline handler: %w", err)
 	}
@@ -26,5 +34,13 @@ func (e *Error) Validate() error {
 		return fmt.Errorf("link expired handler: %w", err)
 	}
 
+	if err := e.Waiting.Validate(); err != nil {
+		return fmt.Errorf("waiting handler: %w", err)
+	}
+
+	if err := e.Preempted.Validate(); err != nil {
+		return fmt.Errorf("preempted handler: %w", err)
+	}
+
 	return nil
 }
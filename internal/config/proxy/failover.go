@@ -0,0 +1,41 @@
+package proxy
+
+import (
+	"fmt"
+	"majmun/internal/config/common"
+
+	"gopkg.in/yaml.v3"
+)
+
+type Failover struct {
+	Enabled      *bool           `yaml:"enabled"`
+	Restarts     int             `yaml:"restarts,omitempty"`
+	StallTimeout common.Duration `yaml:"stall_timeout,omitempty"`
+}
+
+func (f *Failover) Validate() error {
+	if f.Restarts < 0 {
+		return fmt.Errorf("restarts cannot be negative")
+	}
+
+	if f.StallTimeout < 0 {
+		return fmt.Errorf("stall_timeout cannot be negative")
+	}
+
+	return nil
+}
+
+func (f *Failover) IsEnabled() bool {
+	return f.Enabled != nil && *f.Enabled
+}
+
+func (f *Failover) UnmarshalYAML(value *yaml.Node) error {
+	var enabled bool
+	if err := value.Decode(&enabled); err == nil {
+		f.Enabled = &enabled
+		return nil
+	}
+
+	type failoverYAML Failover
+	return value.Decode((*failoverYAML)(f))
+}
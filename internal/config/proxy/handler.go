@@ -5,13 +5,36 @@ import (
 	"majmun/internal/config/common"
 )
 
+const (
+	StreamModeCommand = "command"
+	StreamModeHTTP    = "http"
+	StreamModeHLS     = "hls"
+)
+
 type Handler struct {
-	Command      common.StringOrArr `yaml:"command,omitempty"`
-	TemplateVars []common.NameValue `yaml:"template_variables,omitempty"`
-	EnvVars      []common.NameValue `yaml:"env_variables,omitempty"`
+	Mode          string             `yaml:"mode,omitempty"`
+	Command       common.StringOrArr `yaml:"command,omitempty"`
+	TemplateVars  []common.NameValue `yaml:"template_variables,omitempty"`
+	EnvVars       []common.NameValue `yaml:"env_variables,omitempty"`
+	NoDataTimeout common.Duration    `yaml:"no_data_timeout,omitempty"`
+	Variant       Variant            `yaml:"variant,omitempty"`
 }
 
 func (h *Handler) Validate() error {
+	switch h.Mode {
+	case "", StreamModeCommand, StreamModeHTTP, StreamModeHLS:
+	default:
+		return fmt.Errorf("mode must be one of: %s, %s, %s", StreamModeCommand, StreamModeHTTP, StreamModeHLS)
+	}
+
+	if err := h.Variant.Validate(); err != nil {
+		return fmt.Errorf("variant: %w", err)
+	}
+
+	if h.NoDataTimeout < 0 {
+		return fmt.Errorf("no_data_timeout cannot be negative")
+	}
+
 	for i, templateVar := range h.TemplateVars {
 		if err := templateVar.Validate(); err != nil {
 			return fmt.Errorf("template_variables[%d]: %w", i, err)
@@ -0,0 +1,28 @@
+package proxy
+
+import (
+	"fmt"
+	"majmun/internal/config/common"
+)
+
+type HLS struct {
+	SegmentDuration common.Duration `yaml:"segment_duration,omitempty"`
+	PlaylistSize    int             `yaml:"playlist_size,omitempty"`
+	IdleTimeout     common.Duration `yaml:"idle_timeout,omitempty"`
+}
+
+func (h *HLS) Validate() error {
+	if h.SegmentDuration < 0 {
+		return fmt.Errorf("segment_duration cannot be negative")
+	}
+
+	if h.PlaylistSize < 0 {
+		return fmt.Errorf("playlist_size cannot be negative")
+	}
+
+	if h.IdleTimeout < 0 {
+		return fmt.Errorf("idle_timeout cannot be negative")
+	}
+
+	return nil
+}
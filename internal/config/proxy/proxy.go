@@ -2,15 +2,34 @@ package proxy
 
 import (
 	"fmt"
+	"majmun/internal/config/common"
 
 	"gopkg.in/yaml.v3"
 )
 
+const (
+	OutputMPEGTS = "mpegts"
+	OutputHLS    = "hls"
+
+	ProbeMPEGTS = "mpegts"
+	ProbeADTS   = "adts"
+	ProbeNone   = "none"
+)
+
 type Proxy struct {
-	Enabled           *bool   `yaml:"enabled"`
-	ConcurrentStreams int64   `yaml:"concurrency"`
-	Stream            Handler `yaml:"stream,omitempty"`
-	Error             Error   `yaml:"error,omitempty"`
+	Enabled           *bool           `yaml:"enabled"`
+	ConcurrentStreams int64           `yaml:"concurrency"`
+	Queue             Queue           `yaml:"queue,omitempty"`
+	Preempt           *bool           `yaml:"preempt,omitempty"`
+	Output            string          `yaml:"output,omitempty"`
+	HLS               HLS             `yaml:"hls,omitempty"`
+	Failover          Failover        `yaml:"failover,omitempty"`
+	Linger            common.Duration `yaml:"linger,omitempty"`
+	ReplayBuffer      common.ByteSize `yaml:"replay_buffer,omitempty"`
+	SlowClient        SlowClient      `yaml:"slow_client,omitempty"`
+	Probe             string          `yaml:"probe,omitempty"`
+	Stream            Handler         `yaml:"stream,omitempty"`
+	Error             Error           `yaml:"error,omitempty"`
 }
 
 func (p *Proxy) Validate() error {
@@ -18,6 +37,42 @@ func (p *Proxy) Validate() error {
 		return fmt.Errorf("proxy concurrent streams cannot be negative")
 	}
 
+	switch p.Output {
+	case "", OutputMPEGTS, OutputHLS:
+	default:
+		return fmt.Errorf("proxy output must be one of: %s, %s", OutputMPEGTS, OutputHLS)
+	}
+
+	switch p.Probe {
+	case "", ProbeMPEGTS, ProbeADTS, ProbeNone:
+	default:
+		return fmt.Errorf("proxy probe must be one of: %s, %s, %s", ProbeMPEGTS, ProbeADTS, ProbeNone)
+	}
+
+	if p.Linger < 0 {
+		return fmt.Errorf("proxy linger cannot be negative")
+	}
+
+	if p.ReplayBuffer < 0 {
+		return fmt.Errorf("proxy replay buffer cannot be negative")
+	}
+
+	if err := p.Queue.Validate(); err != nil {
+		return fmt.Errorf("proxy queue: %w", err)
+	}
+
+	if err := p.HLS.Validate(); err != nil {
+		return fmt.Errorf("proxy hls: %w", err)
+	}
+
+	if err := p.Failover.Validate(); err != nil {
+		return fmt.Errorf("proxy failover: %w", err)
+	}
+
+	if err := p.SlowClient.Validate(); err != nil {
+		return fmt.Errorf("proxy slow client: %w", err)
+	}
+
 	if err := p.Stream.Validate(); err != nil {
 		return fmt.Errorf("proxy stream handler: %w", err)
 	}
@@ -29,6 +84,10 @@ func (p *Proxy) Validate() error {
 	return nil
 }
 
+func (p *Proxy) IsPreemptEnabled() bool {
+	return p.Preempt != nil && *p.Preempt
+}
+
 func (p *Proxy) UnmarshalYAML(value *yaml.Node) error {
 	var enabled bool
 	if err := value.Decode(&enabled); err == nil {
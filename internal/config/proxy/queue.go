@@ -0,0 +1,36 @@
+package proxy
+
+import (
+	"fmt"
+	"majmun/internal/config/common"
+)
+
+const (
+	QueueOrderFIFO     = "fifo"
+	QueueOrderPriority = "priority"
+)
+
+// Queue makes requests wait for a concurrency slot instead of failing right away.
+type Queue struct {
+	Timeout common.Duration `yaml:"timeout,omitempty"`
+	Order   string          `yaml:"order,omitempty"`
+	Slate   *bool           `yaml:"slate,omitempty"`
+}
+
+func (q *Queue) Validate() error {
+	if q.Timeout < 0 {
+		return fmt.Errorf("timeout cannot be negative")
+	}
+
+	switch q.Order {
+	case "", QueueOrderFIFO, QueueOrderPriority:
+	default:
+		return fmt.Errorf("order must be one of: %s, %s", QueueOrderFIFO, QueueOrderPriority)
+	}
+
+	return nil
+}
+
+func (q *Queue) IsSlateEnabled() bool {
+	return q.Slate != nil && *q.Slate
+}
@@ -0,0 +1,31 @@
+package proxy
+
+import (
+	"fmt"
+	"majmun/internal/config/common"
+)
+
+const (
+	SlowClientDrop       = "drop"
+	SlowClientBlock      = "block"
+	SlowClientDisconnect = "disconnect"
+)
+
+type SlowClient struct {
+	Policy  string          `yaml:"policy,omitempty"`
+	Timeout common.Duration `yaml:"timeout,omitempty"`
+}
+
+func (s *SlowClient) Validate() error {
+	switch s.Policy {
+	case "", SlowClientDrop, SlowClientBlock, SlowClientDisconnect:
+	default:
+		return fmt.Errorf("policy must be one of: %s, %s, %s", SlowClientDrop, SlowClientBlock, SlowClientDisconnect)
+	}
+
+	if s.Timeout < 0 {
+		return fmt.Errorf("timeout cannot be negative")
+	}
+
+	return nil
+}
@@ -0,0 +1,44 @@
+package proxy
+
+import (
+	"fmt"
+
+	"gopkg.in/yaml.v3"
+)
+
+const (
+	VariantHighestBandwidth  = "highest_bandwidth"
+	VariantLowestBandwidth   = "lowest_bandwidth"
+	VariantHighestResolution = "highest_resolution"
+)
+
+type Variant struct {
+	Policy    string `yaml:"policy,omitempty"`
+	MaxHeight int    `yaml:"max_height,omitempty"`
+}
+
+func (v *Variant) UnmarshalYAML(value *yaml.Node) error {
+	var policy string
+	if err := value.Decode(&policy); err == nil {
+		v.Policy = policy
+		return nil
+	}
+
+	type variantYAML Variant
+	return value.Decode((*variantYAML)(v))
+}
+
+func (v *Variant) Validate() error {
+	switch v.Policy {
+	case "", VariantHighestBandwidth, VariantLowestBandwidth, VariantHighestResolution:
+	default:
+		return fmt.Errorf("policy must be one of: %s, %s, %s",
+			VariantHighestBandwidth, VariantLowestBandwidth, VariantHighestResolution)
+	}
+
+	if v.MaxHeight < 0 {
+		return fmt.Errorf("max_height cannot be negative")
+	}
+
+	return nil
+}
@@ -0,0 +1,23 @@
+package config
+
+import (
+	"fmt"
+	"majmun/internal/config/proxy"
+)
+
+type ProxyProfile struct {
+	Name  string      `yaml:"name"`
+	Proxy proxy.Proxy `yaml:"proxy"`
+}
+
+func (p *ProxyProfile) Validate() error {
+	if p.Name == "" {
+		return fmt.Errorf("proxy profile name is required")
+	}
+
+	if err := p.Proxy.Validate(); err != nil {
+		return fmt.Errorf("proxy profile %s: %w", p.Name, err)
+	}
+
+	return nil
+}
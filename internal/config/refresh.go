@@ -0,0 +1,22 @@
+package config
+
+import (
+	"fmt"
+	"majmun/internal/config/common"
+)
+
+// RefreshConfig limits the background refresh of playlist and EPG sources with a refresh_interval.
+type RefreshConfig struct {
+	Concurrency int             `yaml:"concurrency"`
+	Jitter      common.Duration `yaml:"jitter"`
+}
+
+func (r *RefreshConfig) Validate() error {
+	if r.Concurrency <= 0 {
+		return fmt.Errorf("refresh: concurrency must be greater than 0")
+	}
+	if r.Jitter < 0 {
+		return fmt.Errorf("refresh: jitter cannot be negative")
+	}
+	return nil
+}
@@ -31,6 +31,9 @@ type Rule struct {
 	RemoveField   *RemoveFieldRule   `yaml:"remove_field,omitempty"`
 	RemoveChannel *RemoveChannelRule `yaml:"remove_channel,omitempty"`
 	MarkHidden    *MarkHiddenRule    `yaml:"mark_hidden,omitempty"`
+
+	SetProxyProfile     *SetProxyProfileRule     `yaml:"set_proxy_profile,omitempty"`
+	SetConcurrencyGroup *SetConcurrencyGroupRule `yaml:"set_concurrency_group,omitempty"`
 }
 
 func (r *Rule) UnmarshalYAML(value *yaml.Node) error {
@@ -52,6 +55,10 @@ func (r *Rule) UnmarshalYAML(value *yaml.Node) error {
 		rule.Validate = rule.RemoveChannel.Validate
 	case rule.MarkHidden != nil:
 		rule.Validate = rule.MarkHidden.Validate
+	case rule.SetProxyProfile != nil:
+		rule.Validate = rule.SetProxyProfile.Validate
+	case rule.SetConcurrencyGroup != nil:
+		rule.Validate = rule.SetConcurrencyGroup.Validate
 	default:
 		return fmt.Errorf("unrecognized rule type")
 	}
@@ -0,0 +1,25 @@
+package channel
+
+import (
+	"fmt"
+	"majmun/internal/config/common"
+)
+
+type SetConcurrencyGroupRule struct {
+	Group     string            `yaml:"group"`
+	Condition *common.Condition `yaml:"condition,omitempty"`
+}
+
+func (s *SetConcurrencyGroupRule) Validate() error {
+	if s.Group == "" {
+		return fmt.Errorf("set_concurrency_group: group is required")
+	}
+
+	if s.Condition != nil {
+		if err := s.Condition.Validate(); err != nil {
+			return fmt.Errorf("set_concurrency_group: %w", err)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,25 @@
+package channel
+
+import (
+	"fmt"
+	"majmun/internal/config/common"
+)
+
+type SetProxyProfileRule struct {
+	Profile   string            `yaml:"profile"`
+	Condition *common.Condition `yaml:"condition,omitempty"`
+}
+
+func (s *SetProxyProfileRule) Validate() error {
+	if s.Profile == "" {
+		return fmt.Errorf("set_proxy_profile: profile is required")
+	}
+
+	if s.Condition != nil {
+		if err := s.Condition.Validate(); err != nil {
+			return fmt.Errorf("set_proxy_profile: %w", err)
+		}
+	}
+
+	return nil
+}
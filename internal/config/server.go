@@ -8,7 +8,10 @@ import (
 type ServerConfig struct {
 	ListenAddr  string     `yaml:"listen_addr"`
 	MetricsAddr string     `yaml:"metrics_addr"`
+	AdminAddr   string     `yaml:"admin_addr"`
+	AdminSecret string     `yaml:"admin_secret"`
 	PublicURL   common.URL `yaml:"public_url"`
+	SSDP        bool       `yaml:"ssdp"`
 }
 
 func (s *ServerConfig) Validate() error {
@@ -18,5 +21,8 @@ func (s *ServerConfig) Validate() error {
 	if s.PublicURL.String() == "" {
 		return fmt.Errorf("public_url is required")
 	}
+	if s.AdminAddr != "" && s.AdminSecret == "" {
+		return fmt.Errorf("admin_secret is required when admin_addr is set")
+	}
 	return nil
 }
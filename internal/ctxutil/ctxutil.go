@@ -11,6 +11,8 @@ type contextKey string
 const (
 	clientKey        contextKey = "client"
 	clientNameKey    contextKey = "client_name"
+	priorityKey      contextKey = "priority"
+	waitNotifyKey    contextKey = "wait_notify"
 	requestIDKey     contextKey = "request_id"
 	requestTypeKey   contextKey = "request_type"
 	channelHiddenKey contextKey = "channel_hidden"
@@ -32,9 +34,17 @@ func WithClient(ctx context.Context, client any) context.Context {
 	if namer, ok := client.(interface{ Name() string }); ok {
 		ctx = context.WithValue(ctx, clientNameKey, namer.Name())
 	}
+	if p, ok := client.(interface{ Priority() int }); ok {
+		ctx = context.WithValue(ctx, priorityKey, p.Priority())
+	}
 	return context.WithValue(ctx, clientKey, client)
 }
 
+// WithWaitNotify sets a function called when the request has to wait in a semaphore queue.
+func WithWaitNotify(ctx context.Context, notify func()) context.Context {
+	return context.WithValue(ctx, waitNotifyKey, notify)
+}
+
 func WithStreamData(ctx context.Context, data any) context.Context {
 	return context.WithValue(ctx, streamDataKey, data)
 }
@@ -78,6 +88,20 @@ func Client(ctx context.Context) any {
 	return ctx.Value(clientKey)
 }
 
+func Priority(ctx context.Context) int {
+	if v := ctx.Value(priorityKey); v != nil {
+		return v.(int)
+	}
+	return 0
+}
+
+func WaitNotify(ctx context.Context) func() {
+	if v := ctx.Value(waitNotifyKey); v != nil {
+		return v.(func())
+	}
+	return nil
+}
+
 func ClientName(ctx context.Context) string {
 	if v := ctx.Value(clientNameKey); v != nil {
 		return v.(string)
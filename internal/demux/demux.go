@@ -5,30 +5,54 @@ import (
 	"errors"
 	"io"
 	"majmun/internal/ctxutil"
+	"majmun/internal/ioutil"
 	"majmun/internal/logging"
 	"majmun/internal/metrics"
 	"majmun/internal/utils"
 	"sync"
 	"time"
-
-	"golang.org/x/sync/semaphore"
 )
 
 const semaphoreTimeout = 200 * time.Millisecond
 
 var (
 	ErrSubscriptionSemaphore = errors.New("failed to acquire subscription semaphore")
+	ErrDisconnected          = errors.New("disconnected by administrator")
+	ErrNoFreeAccount         = errors.New("no free provider account")
+	ErrPreempted             = errors.New("preempted by a client with higher priority")
+	ErrChannelLimit          = errors.New("concurrency group limit reached")
+
+	// errStartAbandoned ends the start of a stream whose first client left while waiting for a slot,
+	// so the clients that joined it meanwhile try to start it themselves.
+	errStartAbandoned = errors.New("stream start abandoned")
 )
 
 type Streamer interface {
 	Stream(ctx context.Context, w io.Writer) (int64, error)
 }
 
+// AccountStreamer is a streamer that needs a provider account of its own while the stream runs.
+// The account is acquired together with the playlist slot and released when the stream ends.
+type AccountStreamer interface {
+	Streamer
+	AcquireAccount() bool
+	ReleaseAccount()
+}
+
 type Request struct {
-	Context   context.Context
-	StreamKey string
-	Streamer  Streamer
-	Semaphore *semaphore.Weighted
+	Context    context.Context
+	StreamKey  string
+	Streamer   Streamer
+	Semaphore  *utils.Semaphore
+	Failover   Failover
+	Fallbacks  []Source
+	Linger     time.Duration
+	BufferSize int
+	SlowClient SlowClient
+	// Group is the slot of the concurrency group of the channel, held together with the playlist slot.
+	Group *utils.Semaphore
+	// Preempt allows stopping a stream of clients with lower priority when the playlist has no free slot.
+	Preempt bool
 }
 
 type Demuxer struct {
@@ -36,6 +60,12 @@ type Demuxer struct {
 	rootCtx       context.Context
 	rootCtxCancel context.CancelFunc
 	streamLocks   sync.Map
+
+	idle   map[string]*idleStream
+	idleMu sync.Mutex
+
+	running   map[string]*failover
+	runningMu sync.Mutex
 }
 
 func NewDemuxer() *Demuxer {
@@ -45,6 +75,8 @@ func NewDemuxer() *Demuxer {
 		rootCtx:       rootCtx,
 		rootCtxCancel: cancel,
 		streamLocks:   sync.Map{},
+		idle:          make(map[string]*idleStream),
+		running:       make(map[string]*failover),
 	}
 }
 
@@ -55,6 +87,29 @@ func (m *Demuxer) Stop() {
 	m.pool.Stop()
 }
 
+func (m *Demuxer) Streams() []StreamInfo {
+	return m.pool.Streams()
+}
+
+func (m *Demuxer) StopStream(streamKey string) bool {
+	unlock := m.LockStream(streamKey)
+	defer unlock()
+
+	return m.pool.StopStream(streamKey, ErrDisconnected)
+}
+
+func (m *Demuxer) DisconnectSubscriber(streamKey, subscriberID string) bool {
+	return m.pool.DisconnectSubscribers(streamKey, func(s Subscriber) bool {
+		return s.ID == subscriberID
+	}, ErrDisconnected) > 0
+}
+
+func (m *Demuxer) DisconnectClient(clientName string) int {
+	return m.pool.DisconnectSubscribers("", func(s Subscriber) bool {
+		return s.ClientName == clientName
+	}, ErrDisconnected)
+}
+
 func (m *Demuxer) LockStream(streamKey string) func() {
 	mutex, _ := m.streamLocks.LoadOrStore(streamKey, &sync.Mutex{})
 	mtx := mutex.(*sync.Mutex)
@@ -63,15 +118,46 @@ func (m *Demuxer) LockStream(streamKey string) func() {
 	return mtx.Unlock
 }
 
+// GetReader subscribes to the stream of the request, starting it when it is not running. The slots of a new stream
+// are waited for without holding the stream lock, so clients joining it meanwhile wait for it to start instead of
+// for the lock, and a client leaving the queue gives up its place right away.
 func (m *Demuxer) GetReader(ctx context.Context, req Request) (io.ReadCloser, error) {
+	clientCtx := ctxutil.WithStreamID(ctx, req.StreamKey)
+
+	for {
+		sr, writer, isNewStream := m.addReader(clientCtx, req)
+
+		var err error
+		if isNewStream {
+			err = m.acquireStream(clientCtx, req, writer)
+		} else {
+			err = writer.waitStarted(clientCtx)
+		}
+
+		if errors.Is(err, errStartAbandoned) {
+			_ = sr.Close()
+			continue
+		}
+		if err != nil {
+			_ = sr.Close()
+			return nil, err
+		}
+
+		if !isNewStream {
+			metrics.IncStreamsReused(ctx)
+			logging.Info(clientCtx, "joined existing stream")
+		}
+		return sr, nil
+	}
+}
+
+// addReader subscribes a new reader to the writer of the stream key, creating the writer when there is none.
+func (m *Demuxer) addReader(clientCtx context.Context, req Request) (*streamReader, *StreamWriter, bool) {
 	unlock := m.LockStream(req.StreamKey)
 	defer unlock()
 
 	pr, pw := io.Pipe()
 
-	clientCtx := ctxutil.WithStreamID(ctx, req.StreamKey)
-	streamCtx := context.WithoutCancel(clientCtx)
-
 	sr := &streamReader{
 		PipeReader: pr,
 	}
@@ -81,29 +167,83 @@ func (m *Demuxer) GetReader(ctx context.Context, req Request) (io.ReadCloser, er
 		_ = sr.Close()
 	})
 
-	sr.closeFunc = func() {
+	sr.closeFunc = func(err error) {
 		stop()
 		_ = pw.Close()
-		m.pool.RemoveClient(req.StreamKey, pw)
+		m.pool.RemoveClient(req.StreamKey, pw, err)
 		logging.Debug(clientCtx, "reader closed")
 	}
 
-	isNewStream := m.pool.AddClient(req.StreamKey, pw)
-	if isNewStream {
-		if utils.AcquireSemaphore(streamCtx, req.Semaphore, semaphoreTimeout, "subscription") {
-			logging.Debug(streamCtx, "acquired subscription semaphore")
-		} else {
-			_ = sr.Close()
-			return nil, ErrSubscriptionSemaphore
+	isNewStream := m.pool.AddClient(clientCtx, req, pw)
+	return sr, m.pool.GetWriter(req.StreamKey), isNewStream
+}
+
+// acquireStream takes the playlist and group slots and the account of a new stream and starts it.
+// The slots are waited for with the client context, so the stream is not started for a client that left.
+func (m *Demuxer) acquireStream(clientCtx context.Context, req Request, writer *StreamWriter) error {
+	streamCtx := context.WithoutCancel(clientCtx)
+
+	abort := func(err error) error {
+		if clientCtx.Err() != nil {
+			err = errStartAbandoned
 		}
-		go m.startStream(streamCtx, req)
-		logging.Info(streamCtx, "started new stream")
+
+		unlock := m.LockStream(req.StreamKey)
+		m.pool.RemoveWriter(req.StreamKey, writer)
+		unlock()
+
+		writer.markStarted(err)
+		writer.CloseWithError(err)
+
+		if errors.Is(err, errStartAbandoned) {
+			return clientCtx.Err()
+		}
+		return err
+	}
+
+	// A lingering stream without clients gives up its slot before the request joins the queue.
+	if req.Semaphore == nil || req.Semaphore.TryAcquire() || m.evictIdle(clientCtx, req.Semaphore) ||
+		(req.Preempt && m.preemptStream(clientCtx, req.Semaphore)) ||
+		utils.AcquireSemaphore(clientCtx, req.Semaphore, semaphoreTimeout, "subscription") {
+		logging.Debug(clientCtx, "acquired subscription semaphore")
 	} else {
-		metrics.IncStreamsReused(ctx)
-		logging.Info(clientCtx, "joined existing stream")
+		return abort(ErrSubscriptionSemaphore)
+	}
+	if req.Group != nil && !req.Group.TryAcquire() && !m.evictIdle(clientCtx, req.Group) &&
+		!utils.AcquireSemaphore(clientCtx, req.Group, semaphoreTimeout, "channel") {
+		release(req.Semaphore)
+		return abort(ErrChannelLimit)
+	}
+	if as, ok := req.Streamer.(AccountStreamer); ok && !as.AcquireAccount() {
+		release(req.Semaphore, req.Group)
+		return abort(ErrNoFreeAccount)
 	}
 
-	return sr, nil
+	// The stream may have been stopped while waiting.
+	unlock := m.LockStream(req.StreamKey)
+	current := m.pool.GetWriter(req.StreamKey) == writer
+	unlock()
+	if !current || clientCtx.Err() != nil {
+		if as, ok := req.Streamer.(AccountStreamer); ok {
+			as.ReleaseAccount()
+		}
+		release(req.Semaphore, req.Group)
+		return abort(ErrDisconnected)
+	}
+
+	go m.startStream(streamCtx, req)
+	writer.markStarted(nil)
+	logging.Info(streamCtx, "started new stream")
+
+	return nil
+}
+
+func release(sems ...*utils.Semaphore) {
+	for _, sem := range sems {
+		if sem != nil {
+			sem.Release()
+		}
+	}
 }
 
 func (m *Demuxer) startStream(ctx context.Context, req Request) {
@@ -112,6 +252,14 @@ func (m *Demuxer) startStream(ctx context.Context, req Request) {
 	metrics.IncPlaylistStreamsActive(ctx)
 	defer metrics.DecPlaylistStreamsActive(ctx)
 
+	f := newFailover(ctx, req)
+	m.setRunning(key, f)
+	defer func() {
+		m.unsetRunning(key, f)
+		logging.Debug(ctx, "releasing subscription semaphore")
+		f.release()
+	}()
+
 	unlock := m.LockStream(key)
 	writer := m.pool.GetWriter(key)
 	unlock()
@@ -124,35 +272,41 @@ func (m *Demuxer) startStream(ctx context.Context, req Request) {
 	streamID := ctxutil.StreamID(ctx)
 	streamCtx, cancel := context.WithCancel(ctxutil.WithStreamID(context.Background(), streamID))
 	defer cancel()
-	defer writer.Close()
 
-	go func() {
-		emptyCh := writer.IsEmptyChannel()
-		defer writer.CancelEmptyChannel(emptyCh)
+	defer func() {
+		unlock := m.LockStream(key)
+		m.pool.RemoveWriter(key, writer)
+		unlock()
+		writer.Close()
+	}()
 
-		if req.Semaphore != nil {
-			defer req.Semaphore.Release(1)
-			defer logging.Debug(ctx, "releasing subscription semaphore")
-		}
+	go m.watchSubscribers(streamCtx, key, writer, req.Linger, f, cancel)
 
-		select {
-		case <-emptyCh:
-			logging.Debug(ctx, "no clients left, stopping stream")
-			cancel()
-			return
-		case <-streamCtx.Done():
-			logging.Debug(ctx, "context canceled, stopping stream")
+	for {
+		startedAt := time.Now()
+
+		_, err := runSource(streamCtx, f.current().Streamer, writer, f.StallTimeout)
+		if streamCtx.Err() != nil {
+			logging.Debug(streamCtx, "stream canceled")
 			return
 		}
-	}()
 
-	_, err := req.Streamer.Stream(streamCtx, writer)
-	if err != nil {
-		if errors.Is(err, context.Canceled) {
-			logging.Debug(streamCtx, "stream canceled")
-		} else {
+		reason := metrics.FailoverReasonEnded
+		if errors.Is(err, ErrStalled) || errors.Is(err, ioutil.ErrReaderTimeout) {
+			reason = metrics.FailoverReasonStalled
+			metrics.IncStreamsFailures(ctx, metrics.FailureReasonStalled)
+		} else if err != nil {
 			logging.Error(streamCtx, err, "stream failed")
 		}
-		logging.Debug(streamCtx, "stream ended")
+		logging.Debug(streamCtx, "stream ended", "reason", reason)
+
+		// Streams that never produced data are left to the caller, which tries the next source itself,
+		// and lingering streams without clients are not worth restarting.
+		if writer.BytesWritten() == 0 || writer.IsEmpty() || !f.next(streamCtx, time.Since(startedAt)) {
+			return
+		}
+
+		metrics.IncStreamsFailovers(ctx, reason)
+		logging.Info(ctx, "upstream failover", "reason", reason, "source", f.current().Name, "restart", f.restarts)
 	}
 }
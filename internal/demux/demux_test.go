@@ -0,0 +1,148 @@
+package demux
+
+import (
+	"context"
+	"errors"
+	"majmun/internal/ctxutil"
+	"majmun/internal/metrics"
+	"majmun/internal/utils"
+	"testing"
+)
+
+// gaugeValue returns the value of the gauge with the given labels, or zero when it is not registered.
+func gaugeValue(t *testing.T, name string, labels map[string]string) float64 {
+	t.Helper()
+
+	families, err := metrics.Registry.Gather()
+	if err != nil {
+		t.Fatalf("Gather() error = %v", err)
+	}
+
+	for _, family := range families {
+		if family.GetName() != name {
+			continue
+		}
+	metric:
+		for _, m := range family.GetMetric() {
+			for _, label := range m.GetLabel() {
+				if value, ok := labels[label.GetName()]; ok && value != label.GetValue() {
+					continue metric
+				}
+			}
+			return m.GetGauge().GetValue()
+		}
+	}
+	return 0
+}
+
+func TestStopStreamReleasesSlots(t *testing.T) {
+	d := NewDemuxer()
+	defer d.Stop()
+
+	sem := utils.NewSemaphore(metrics.SemaphoreLevelPlaylist, "stop-stream", 1)
+	ctx := ctxutil.WithProviderName(clientContext("viewer", 0), "stop-stream")
+
+	reader, err := d.GetReader(ctx, Request{StreamKey: "stop", Streamer: &countingStreamer{}, Semaphore: sem})
+	if err != nil {
+		t.Fatalf("GetReader() error = %v", err)
+	}
+	defer reader.Close()
+	readSome(t, reader)
+
+	semLabels := map[string]string{"level": metrics.SemaphoreLevelPlaylist, "name": "stop-stream"}
+	streamLabels := map[string]string{"playlist_name": "stop-stream"}
+	if v := gaugeValue(t, "iptv_semaphores_in_use", semLabels); v != 1 {
+		t.Errorf("semaphores in use gauge = %v, expected 1", v)
+	}
+	if v := gaugeValue(t, "iptv_playlist_streams_active", streamLabels); v != 1 {
+		t.Errorf("playlist streams gauge = %v, expected 1", v)
+	}
+
+	if !d.StopStream("stop") {
+		t.Fatal("StopStream() = false for a running stream")
+	}
+	if err := readUntilError(reader); !errors.Is(err, ErrDisconnected) {
+		t.Errorf("Read() error = %v, expected %v", err, ErrDisconnected)
+	}
+
+	waitFor(t, func() bool { return sem.InUse() == 0 })
+	waitFor(t, func() bool { return gaugeValue(t, "iptv_playlist_streams_active", streamLabels) == 0 })
+	if v := gaugeValue(t, "iptv_semaphores_in_use", semLabels); v != 0 {
+		t.Errorf("semaphores in use gauge = %v, expected 0", v)
+	}
+	if streams := d.Streams(); len(streams) != 0 {
+		t.Errorf("Streams() = %v, expected no streams", streams)
+	}
+
+	if d.StopStream("stop") {
+		t.Error("StopStream() = true for a stopped stream")
+	}
+}
+
+func TestDisconnectSubscriber(t *testing.T) {
+	d := NewDemuxer()
+	defer d.Stop()
+
+	req := Request{StreamKey: "shared", Streamer: &countingStreamer{}, BufferSize: 1024}
+
+	first, err := d.GetReader(ctxutil.WithRequestID(clientContext("viewer", 0)), req)
+	if err != nil {
+		t.Fatalf("GetReader() error = %v", err)
+	}
+	defer first.Close()
+	readSome(t, first)
+
+	second, err := d.GetReader(ctxutil.WithRequestID(clientContext("viewer", 0)), req)
+	if err != nil {
+		t.Fatalf("GetReader() error = %v", err)
+	}
+	defer second.Close()
+	readSome(t, second)
+
+	subscribers := d.Streams()[0].Subscribers
+	if len(subscribers) != 2 {
+		t.Fatalf("stream has %d subscribers, expected 2", len(subscribers))
+	}
+
+	if d.DisconnectSubscriber("shared", "unknown") {
+		t.Error("DisconnectSubscriber() = true for an unknown subscriber")
+	}
+	if !d.DisconnectSubscriber("shared", subscribers[0].ID) {
+		t.Fatal("DisconnectSubscriber() = false for a subscriber of the stream")
+	}
+
+	if subscribers := d.Streams()[0].Subscribers; len(subscribers) != 1 {
+		t.Errorf("stream has %d subscribers after disconnect, expected 1", len(subscribers))
+	}
+}
+
+func TestDisconnectClient(t *testing.T) {
+	d := NewDemuxer()
+	defer d.Stop()
+
+	readers := map[string]context.Context{
+		"a": clientContext("kitchen", 0),
+		"b": clientContext("kitchen", 0),
+		"c": clientContext("bedroom", 0),
+	}
+	for key, ctx := range readers {
+		reader, err := d.GetReader(ctx, Request{StreamKey: key, Streamer: &countingStreamer{}})
+		if err != nil {
+			t.Fatalf("GetReader() error = %v", err)
+		}
+		defer reader.Close()
+		readSome(t, reader)
+	}
+
+	if n := d.DisconnectClient("kitchen"); n != 2 {
+		t.Errorf("DisconnectClient() = %d, expected 2", n)
+	}
+	if n := d.DisconnectClient("unknown"); n != 0 {
+		t.Errorf("DisconnectClient() = %d for an unknown client, expected 0", n)
+	}
+
+	waitFor(t, func() bool { return len(d.Streams()) == 1 })
+	if streams := d.Streams(); streams[0].StreamKey != "c" {
+		t.Errorf("Streams() = %v, expected only the stream of the other client", streams)
+	}
+}
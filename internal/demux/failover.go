@@ -0,0 +1,195 @@
+package demux
+
+import (
+	"context"
+	"errors"
+	"io"
+	"majmun/internal/ctxutil"
+	"majmun/internal/ioutil"
+	"majmun/internal/logging"
+	"majmun/internal/utils"
+	"sync"
+	"time"
+)
+
+const (
+	failoverDelay      = time.Second
+	failoverResetAfter = time.Minute
+)
+
+var ErrStalled = errors.New("upstream stalled")
+
+type Source struct {
+	Name      string
+	Streamer  Streamer
+	Semaphore *utils.Semaphore
+	Group     *utils.Semaphore
+}
+
+// Failover restarts a stream that ends or stalls after it has started producing data,
+// trying the fallback sources in order before restarting the current one.
+type Failover struct {
+	Restarts     int
+	StallTimeout time.Duration
+}
+
+type failover struct {
+	Failover
+
+	sources  []Source
+	index    int
+	restarts int
+
+	mu      sync.Mutex
+	held    *utils.Semaphore
+	group   *utils.Semaphore
+	account AccountStreamer
+}
+
+func newFailover(ctx context.Context, req Request) *failover {
+	sources := make([]Source, 0, len(req.Fallbacks)+1)
+	sources = append(sources, Source{
+		Name:      ctxutil.ProviderName(ctx),
+		Streamer:  req.Streamer,
+		Semaphore: req.Semaphore,
+		Group:     req.Group,
+	})
+	sources = append(sources, req.Fallbacks...)
+
+	account, _ := req.Streamer.(AccountStreamer)
+
+	return &failover{
+		Failover: req.Failover,
+		sources:  sources,
+		held:     req.Semaphore,
+		group:    req.Group,
+		account:  account,
+	}
+}
+
+func (f *failover) current() Source {
+	return f.sources[f.index]
+}
+
+// next switches to the next source that has a free slot and account, waiting a moment between restarts.
+// The playlist and group slots of the previous source are released only after the next ones are acquired.
+func (f *failover) next(ctx context.Context, streamed time.Duration) bool {
+	if streamed >= failoverResetAfter {
+		f.restarts = 0
+	}
+
+	for f.restarts < f.Restarts {
+		f.restarts++
+
+		select {
+		case <-ctx.Done():
+			return false
+		case <-time.After(failoverDelay):
+		}
+
+		f.index = (f.index + 1) % len(f.sources)
+		source := f.current()
+
+		sameSlot := source.Semaphore == f.semaphore()
+		if !sameSlot && !utils.AcquireSemaphore(ctx, source.Semaphore, semaphoreTimeout, "failover") {
+			logging.Debug(ctx, "failover source has no free slot", "source", source.Name)
+			continue
+		}
+
+		sameGroup := source.Group == f.groupSemaphore()
+		if !sameGroup && !utils.AcquireSemaphore(ctx, source.Group, semaphoreTimeout, "failover") {
+			if !sameSlot {
+				release(source.Semaphore)
+			}
+			logging.Debug(ctx, "failover source has no free group slot", "source", source.Name)
+			continue
+		}
+
+		if !f.switchAccount(source.Streamer) {
+			if !sameSlot {
+				release(source.Semaphore)
+			}
+			if !sameGroup {
+				release(source.Group)
+			}
+			logging.Debug(ctx, "failover source has no free account", "source", source.Name)
+			continue
+		}
+
+		f.mu.Lock()
+		if !sameSlot {
+			release(f.held)
+			f.held = source.Semaphore
+		}
+		if !sameGroup {
+			release(f.group)
+			f.group = source.Group
+		}
+		f.mu.Unlock()
+		return true
+	}
+
+	return false
+}
+
+// switchAccount acquires the account needed by the streamer, keeping the held one when the streamer
+// is the one already running. The previous stream has ended by now, so its account is released first,
+// which lets sources share a provider account.
+func (f *failover) switchAccount(streamer Streamer) bool {
+	account, _ := streamer.(AccountStreamer)
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if account == f.account {
+		return true
+	}
+	if f.account != nil {
+		f.account.ReleaseAccount()
+		f.account = nil
+	}
+	if account != nil && !account.AcquireAccount() {
+		return false
+	}
+	f.account = account
+	return true
+}
+
+// semaphore returns the playlist slot currently held by the stream.
+func (f *failover) semaphore() *utils.Semaphore {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.held
+}
+
+// groupSemaphore returns the concurrency group slot currently held by the stream.
+func (f *failover) groupSemaphore() *utils.Semaphore {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.group
+}
+
+func (f *failover) release() {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	release(f.held, f.group)
+	f.held, f.group = nil, nil
+
+	if f.account != nil {
+		f.account.ReleaseAccount()
+		f.account = nil
+	}
+}
+
+// runSource streams a single source, canceling it when no data arrives within the stall timeout.
+func runSource(ctx context.Context, streamer Streamer, w io.Writer, stallTimeout time.Duration) (int64, error) {
+	ctx, watchdog := ioutil.NewWatchdog(ctx, stallTimeout, ErrStalled)
+	defer watchdog.Stop()
+
+	n, err := streamer.Stream(ctx, watchdog.Writer(w))
+	if errors.Is(context.Cause(ctx), ErrStalled) {
+		return n, ErrStalled
+	}
+	return n, err
+}
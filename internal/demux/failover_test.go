@@ -0,0 +1,229 @@
+package demux
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"majmun/internal/utils"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+type fakeStreamer struct {
+	data  []byte
+	block bool
+}
+
+func (f *fakeStreamer) Stream(ctx context.Context, w io.Writer) (int64, error) {
+	n, err := w.Write(f.data)
+	if err != nil {
+		return int64(n), err
+	}
+	if f.block {
+		<-ctx.Done()
+		return int64(n), ctx.Err()
+	}
+	return int64(n), nil
+}
+
+func TestFailoverSwitchesSource(t *testing.T) {
+	d := NewDemuxer()
+	defer d.Stop()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	reader, err := d.GetReader(ctx, Request{
+		StreamKey: "failover",
+		Streamer:  &fakeStreamer{data: []byte("first")},
+		Failover:  Failover{Restarts: 1},
+		Fallbacks: []Source{{Name: "backup", Streamer: &fakeStreamer{data: []byte("second"), block: true}}},
+	})
+	if err != nil {
+		t.Fatalf("GetReader() error = %v", err)
+	}
+	defer reader.Close()
+
+	var got []byte
+	buf := make([]byte, 64)
+	for !bytes.Equal(got, []byte("firstsecond")) {
+		n, err := reader.Read(buf)
+		if err != nil {
+			t.Fatalf("Read() error = %v, got %q", err, got)
+		}
+		got = append(got, buf[:n]...)
+	}
+}
+
+func TestFailoverDisabled(t *testing.T) {
+	d := NewDemuxer()
+	defer d.Stop()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	reader, err := d.GetReader(ctx, Request{
+		StreamKey: "no-failover",
+		Streamer:  &fakeStreamer{data: []byte("first")},
+		Fallbacks: []Source{{Streamer: &fakeStreamer{data: []byte("second"), block: true}}},
+	})
+	if err != nil {
+		t.Fatalf("GetReader() error = %v", err)
+	}
+	defer reader.Close()
+
+	got, err := io.ReadAll(reader)
+	if err != nil {
+		t.Fatalf("ReadAll() error = %v", err)
+	}
+	if string(got) != "first" {
+		t.Errorf("ReadAll() = %q, expected %q", got, "first")
+	}
+}
+
+func TestRunSourceStall(t *testing.T) {
+	start := time.Now()
+
+	_, err := runSource(context.Background(), &fakeStreamer{data: []byte("data"), block: true}, io.Discard, 100*time.Millisecond)
+	if !errors.Is(err, ErrStalled) {
+		t.Fatalf("runSource() error = %v, expected %v", err, ErrStalled)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("runSource() took %v to detect the stall", elapsed)
+	}
+}
+
+type accountStreamer struct {
+	fakeStreamer
+	free *atomic.Int32
+}
+
+func (a *accountStreamer) AcquireAccount() bool {
+	if a.free.Add(-1) < 0 {
+		a.free.Add(1)
+		return false
+	}
+	return true
+}
+
+func (a *accountStreamer) ReleaseAccount() {
+	a.free.Add(1)
+}
+
+func TestAccountHeldWhileStreaming(t *testing.T) {
+	d := NewDemuxer()
+	defer d.Stop()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	free := &atomic.Int32{}
+	free.Store(1)
+
+	reader, err := d.GetReader(ctx, Request{
+		StreamKey: "first",
+		Streamer:  &accountStreamer{fakeStreamer: fakeStreamer{data: []byte("data"), block: true}, free: free},
+	})
+	if err != nil {
+		t.Fatalf("GetReader() error = %v", err)
+	}
+
+	_, err = d.GetReader(ctx, Request{
+		StreamKey: "second",
+		Streamer:  &accountStreamer{fakeStreamer: fakeStreamer{data: []byte("data"), block: true}, free: free},
+	})
+	if !errors.Is(err, ErrNoFreeAccount) {
+		t.Fatalf("GetReader() error = %v, expected %v", err, ErrNoFreeAccount)
+	}
+
+	_ = reader.Close()
+
+	deadline := time.Now().Add(time.Second)
+	for free.Load() != 1 {
+		if time.Now().After(deadline) {
+			t.Fatal("account was not released after the stream ended")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestFailoverSwitchesAccount(t *testing.T) {
+	d := NewDemuxer()
+	defer d.Stop()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	free := &atomic.Int32{}
+	free.Store(1)
+
+	first := &accountStreamer{fakeStreamer: fakeStreamer{data: []byte("first")}, free: free}
+	backup := &accountStreamer{fakeStreamer: fakeStreamer{data: []byte("second"), block: true}, free: free}
+
+	reader, err := d.GetReader(ctx, Request{
+		StreamKey: "failover-account",
+		Streamer:  first,
+		Failover:  Failover{Restarts: 1},
+		Fallbacks: []Source{{Name: "backup", Streamer: backup}},
+	})
+	if err != nil {
+		t.Fatalf("GetReader() error = %v", err)
+	}
+	defer reader.Close()
+
+	var got []byte
+	buf := make([]byte, 64)
+	for !bytes.Equal(got, []byte("firstsecond")) {
+		n, err := reader.Read(buf)
+		if err != nil {
+			t.Fatalf("Read() error = %v, got %q", err, got)
+		}
+		got = append(got, buf[:n]...)
+	}
+}
+
+func TestGroupLimitAcrossStreams(t *testing.T) {
+	d := NewDemuxer()
+	defer d.Stop()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	group := utils.NewSemaphore("channel", "sports", 1)
+	playlist := utils.NewSemaphore("playlist", "test", 2)
+
+	reader, err := d.GetReader(ctx, Request{
+		StreamKey: "first",
+		Streamer:  &fakeStreamer{data: []byte("data"), block: true},
+		Semaphore: playlist,
+		Group:     group,
+	})
+	if err != nil {
+		t.Fatalf("GetReader() error = %v", err)
+	}
+
+	_, err = d.GetReader(ctx, Request{
+		StreamKey: "second",
+		Streamer:  &fakeStreamer{data: []byte("data"), block: true},
+		Semaphore: playlist,
+		Group:     group,
+	})
+	if !errors.Is(err, ErrChannelLimit) {
+		t.Fatalf("GetReader() error = %v, expected %v", err, ErrChannelLimit)
+	}
+	if inUse := playlist.InUse(); inUse != 1 {
+		t.Errorf("playlist semaphore in use = %d, expected 1", inUse)
+	}
+
+	_ = reader.Close()
+
+	deadline := time.Now().Add(time.Second)
+	for group.InUse() != 0 || playlist.InUse() != 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("slots were not released after the stream ended")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
@@ -0,0 +1,69 @@
+package demux
+
+import "majmun/internal/mpegts"
+
+// joinIndex follows the MPEG-TS structure of the buffered stream, so that new clients
+// can be started on a packet boundary at the most recent keyframe.
+type joinIndex struct {
+	tracker mpegts.Tracker
+	partial []byte
+
+	packet   int64 // offset of the latest packet, -1 until one is seen
+	keyframe int64 // offset of the latest random access point, -1 until one is seen
+}
+
+func newJoinIndex() joinIndex {
+	return joinIndex{packet: -1, keyframe: -1}
+}
+
+// observe scans data written at the given stream offset.
+func (j *joinIndex) observe(p []byte, offset int64) {
+	data := p
+	if len(j.partial) > 0 {
+		offset -= int64(len(j.partial))
+		j.partial = append(j.partial, p...)
+		data = j.partial
+	}
+
+	i := 0
+	for len(data)-i >= mpegts.PacketSize {
+		if data[i] != mpegts.SyncByte {
+			i++
+			continue
+		}
+		j.observePacket(data[i:i+mpegts.PacketSize], offset+int64(i))
+		i += mpegts.PacketSize
+	}
+
+	j.partial = append(j.partial[:0], data[i:]...)
+}
+
+func (j *joinIndex) observePacket(p []byte, offset int64) {
+	j.tracker.Observe(p)
+	j.packet = offset
+
+	timingPID, known := j.tracker.TimingPID()
+	if !known || mpegts.PID(p) != timingPID || !mpegts.PayloadUnitStart(p) {
+		return
+	}
+	if !j.tracker.HasVideo() || mpegts.RandomAccess(p) {
+		j.keyframe = offset
+	}
+}
+
+// start returns the offset a new client should start from, given the oldest buffered offset,
+// and the tables to send before it. Streams that are not MPEG-TS are replayed as they are.
+func (j *joinIndex) start(oldest int64) (int64, [][]byte) {
+	if !j.tracker.HasPMT() {
+		return oldest, nil
+	}
+
+	switch {
+	case j.keyframe >= oldest:
+		return j.keyframe, j.tracker.Tables()
+	case j.packet >= oldest:
+		return oldest + (j.packet-oldest)%mpegts.PacketSize, j.tracker.Tables()
+	default:
+		return oldest, nil
+	}
+}
@@ -0,0 +1,130 @@
+package demux
+
+import (
+	"bytes"
+	"context"
+	"majmun/internal/mpegts"
+	"testing"
+)
+
+const (
+	testPMTPID   = 0x1000
+	testVideoPID = 0x100
+)
+
+func newPacket(pid uint16, pusi, keyframe bool) []byte {
+	p := bytes.Repeat([]byte{0xff}, mpegts.PacketSize)
+	p[0] = mpegts.SyncByte
+	p[1] = byte(pid>>8) & 0x1f
+	if pusi {
+		p[1] |= 0x40
+	}
+	p[2] = byte(pid)
+	p[3] = 0x10
+	if keyframe {
+		p[3], p[4], p[5] = 0x30, 1, 0x40
+	}
+	return p
+}
+
+func newTables() [][]byte {
+	pat := newPacket(mpegts.PATPID, true, false)
+	copy(pat[4:], []byte{0, 0x00, 0xb0, 13, 0, 1, 0xc1, 0, 0, 0, 1, 0xe0 | testPMTPID>>8, testPMTPID & 0xff, 0, 0, 0, 0})
+
+	pmt := newPacket(testPMTPID, true, false)
+	copy(pmt[4:], []byte{0, 0x02, 0xb0, 18, 0, 1, 0xc1, 0, 0, 0xe1, 0x00, 0xf0, 0,
+		0x1b, 0xe0 | testVideoPID>>8, testVideoPID & 0xff, 0xf0, 0, 0, 0, 0, 0})
+
+	return [][]byte{pat, pmt}
+}
+
+// buildStream produces tables followed by video frames of two packets, with a keyframe every keyframeInterval frames.
+func buildStream(frames, keyframeInterval int) []byte {
+	var buf bytes.Buffer
+	for _, table := range newTables() {
+		buf.Write(table)
+	}
+	for i := 0; i < frames; i++ {
+		buf.Write(newPacket(testVideoPID, true, i%keyframeInterval == 0))
+		buf.Write(newPacket(testVideoPID, false, false))
+	}
+	return buf.Bytes()
+}
+
+type bufferClient struct {
+	bytes.Buffer
+}
+
+func (c *bufferClient) Close() error {
+	return nil
+}
+
+// replay returns what a client joining the writer receives before any new data.
+func replay(sw *StreamWriter) []byte {
+	client := &bufferClient{}
+	sw.AddClient(context.Background(), client, SlowClient{})
+	sw.Close()
+	return client.Bytes()
+}
+
+// writeChunks writes data in chunks that do not line up with packet boundaries.
+func writeChunks(sw *StreamWriter, data []byte) {
+	for len(data) > 0 {
+		n := min(1000, len(data))
+		_, _ = sw.Write(data[:n])
+		data = data[n:]
+	}
+}
+
+func assertJoinPoint(t *testing.T, data []byte, keyframe bool) {
+	t.Helper()
+
+	if len(data) == 0 || len(data)%mpegts.PacketSize != 0 {
+		t.Fatalf("replay length %d is not a multiple of the packet size", len(data))
+	}
+	for i := 0; i < len(data); i += mpegts.PacketSize {
+		if data[i] != mpegts.SyncByte {
+			t.Fatalf("replay packet at %d has no sync byte", i)
+		}
+	}
+
+	tables := newTables()
+	if !bytes.Equal(data[:mpegts.PacketSize], tables[0]) ||
+		!bytes.Equal(data[mpegts.PacketSize:2*mpegts.PacketSize], tables[1]) {
+		t.Fatal("replay does not start with PAT and PMT")
+	}
+
+	first := data[2*mpegts.PacketSize:]
+	if keyframe && (mpegts.PID(first) != testVideoPID || !mpegts.RandomAccess(first)) {
+		t.Error("replay does not start at a keyframe")
+	}
+}
+
+func TestJoinStartsAtLatestKeyframe(t *testing.T) {
+	sw := NewStreamWriter("channel", "playlist", 1024*1024)
+	writeChunks(sw, buildStream(100, 10))
+
+	data := replay(sw)
+	assertJoinPoint(t, data, true)
+
+	// the last keyframe is frame 90, followed by 10 frames of two packets
+	if packets := len(data)/mpegts.PacketSize - 2; packets != 20 {
+		t.Errorf("replay has %d stream packets, expected 20", packets)
+	}
+}
+
+func TestJoinWithoutKeyframeInBuffer(t *testing.T) {
+	sw := NewStreamWriter("channel", "playlist", 50*mpegts.PacketSize+37)
+	writeChunks(sw, buildStream(100, 1000))
+
+	assertJoinPoint(t, replay(sw), false)
+}
+
+func TestJoinReplaysOtherFormats(t *testing.T) {
+	sw := NewStreamWriter("channel", "playlist", 16)
+	_, _ = sw.Write([]byte("not a transport stream"))
+
+	if data := string(replay(sw)); data != "transport stream" {
+		t.Errorf("replay() = %q, expected %q", data, "transport stream")
+	}
+}
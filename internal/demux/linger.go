@@ -0,0 +1,140 @@
+package demux
+
+import (
+	"context"
+	"majmun/internal/logging"
+	"majmun/internal/utils"
+	"sort"
+	"time"
+)
+
+const evictTimeout = 2 * time.Second
+
+type idleStream struct {
+	writer    *StreamWriter
+	semaphore *utils.Semaphore
+	group     *utils.Semaphore
+	cancel    context.CancelFunc
+}
+
+// watchSubscribers stops the stream once it has had no subscribers for the linger duration.
+// Only a stream whose subscribers left on their own lingers, one left after an error or a disconnect is stopped.
+// While lingering, the stream keeps its playlist and group slots and is registered as idle,
+// so a new stream can take a slot over when the playlist or group has no free one.
+func (m *Demuxer) watchSubscribers(
+	ctx context.Context, key string, writer *StreamWriter, linger time.Duration, f *failover, cancel context.CancelFunc) {
+
+	defer m.setIdle(key, nil)
+
+	for {
+		emptyCh := writer.IsEmptyChannel()
+		if !writer.IsEmpty() {
+			select {
+			case <-emptyCh:
+			case <-ctx.Done():
+				writer.CancelEmptyChannel(emptyCh)
+				logging.Debug(ctx, "context canceled, stopping stream")
+				return
+			}
+		}
+		writer.CancelEmptyChannel(emptyCh)
+
+		if err := writer.LeaveError(); err != nil {
+			logging.Debug(ctx, "clients left with an error, not lingering", "error", err)
+		} else if linger > 0 && m.pool.GetWriter(key) == writer {
+			logging.Debug(ctx, "no clients left, lingering", "linger", linger)
+			m.setIdle(key, &idleStream{writer: writer, semaphore: f.semaphore(), group: f.groupSemaphore(), cancel: cancel})
+
+			if !m.linger(ctx, writer, linger) {
+				return
+			}
+			m.setIdle(key, nil)
+		}
+
+		unlock := m.LockStream(key)
+		if writer.IsEmpty() {
+			m.pool.RemoveWriter(key, writer)
+			unlock()
+
+			logging.Debug(ctx, "no clients left, stopping stream")
+			cancel()
+			return
+		}
+		unlock()
+
+		logging.Debug(ctx, "client rejoined lingering stream")
+	}
+}
+
+// linger waits until the writer has been idle for the linger duration or gets a client again.
+// It returns false when the stream context ends first.
+func (m *Demuxer) linger(ctx context.Context, writer *StreamWriter, linger time.Duration) bool {
+	for {
+		idle := writer.IdleFor()
+		if idle == 0 || idle >= linger {
+			return true
+		}
+
+		select {
+		case <-ctx.Done():
+			return false
+		case <-time.After(min(linger-idle, time.Second)):
+		}
+	}
+}
+
+func (m *Demuxer) setIdle(key string, stream *idleStream) {
+	m.idleMu.Lock()
+	defer m.idleMu.Unlock()
+
+	if stream == nil {
+		delete(m.idle, key)
+		return
+	}
+	m.idle[key] = stream
+}
+
+// evictIdle stops the longest idle stream holding the given playlist or group slot and waits for the slot.
+func (m *Demuxer) evictIdle(ctx context.Context, sem *utils.Semaphore) bool {
+	if sem == nil {
+		return false
+	}
+
+	m.idleMu.Lock()
+	keys := make([]string, 0, len(m.idle))
+	for key, stream := range m.idle {
+		if stream.semaphore == sem || stream.group == sem {
+			keys = append(keys, key)
+		}
+	}
+	candidates := make(map[string]*idleStream, len(keys))
+	for _, key := range keys {
+		candidates[key] = m.idle[key]
+	}
+	m.idleMu.Unlock()
+
+	sort.Slice(keys, func(i, j int) bool {
+		return candidates[keys[i]].writer.IdleFor() > candidates[keys[j]].writer.IdleFor()
+	})
+
+	for _, key := range keys {
+		stream := candidates[key]
+
+		unlock := m.LockStream(key)
+		evicted := stream.writer.IsEmpty() && m.pool.GetWriter(key) == stream.writer
+		if evicted {
+			m.pool.RemoveWriter(key, stream.writer)
+		}
+		unlock()
+
+		if !evicted {
+			continue
+		}
+
+		logging.Info(ctx, "stopping idle stream to free playlist slot", "idle_stream_id", key)
+		stream.cancel()
+		return utils.AcquireSemaphore(ctx, sem, evictTimeout, "subscription")
+	}
+
+	return false
+}
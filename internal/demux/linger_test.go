@@ -0,0 +1,154 @@
+package demux
+
+import (
+	"context"
+	"errors"
+	"io"
+	"majmun/internal/utils"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+type countingStreamer struct {
+	starts atomic.Int32
+}
+
+func (c *countingStreamer) Stream(ctx context.Context, w io.Writer) (int64, error) {
+	c.starts.Add(1)
+	n, _ := w.Write([]byte("data"))
+	<-ctx.Done()
+	return int64(n), ctx.Err()
+}
+
+func readSome(t *testing.T, r io.Reader) {
+	t.Helper()
+	if _, err := r.Read(make([]byte, 16)); err != nil {
+		t.Fatalf("Read() error = %v", err)
+	}
+}
+
+func waitFor(t *testing.T, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(3 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatal("condition not met in time")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestLingerKeepsStream(t *testing.T) {
+	d := NewDemuxer()
+	defer d.Stop()
+
+	streamer := &countingStreamer{}
+	req := Request{StreamKey: "linger", Streamer: streamer, Linger: 300 * time.Millisecond, BufferSize: 1024}
+
+	reader, err := d.GetReader(context.Background(), req)
+	if err != nil {
+		t.Fatalf("GetReader() error = %v", err)
+	}
+	readSome(t, reader)
+	_ = reader.Close()
+
+	reader, err = d.GetReader(context.Background(), req)
+	if err != nil {
+		t.Fatalf("GetReader() error = %v", err)
+	}
+	readSome(t, reader)
+	_ = reader.Close()
+
+	if starts := streamer.starts.Load(); starts != 1 {
+		t.Errorf("stream started %d times, expected 1", starts)
+	}
+
+	waitFor(t, func() bool { return len(d.Streams()) == 0 })
+}
+
+func TestLingerEvictsIdleStream(t *testing.T) {
+	d := NewDemuxer()
+	defer d.Stop()
+
+	sem := utils.NewSemaphore("playlist", "test", 1)
+
+	idle, err := d.GetReader(context.Background(), Request{
+		StreamKey: "idle", Streamer: &countingStreamer{}, Semaphore: sem, Linger: time.Hour})
+	if err != nil {
+		t.Fatalf("GetReader() error = %v", err)
+	}
+	readSome(t, idle)
+	_ = idle.Close()
+
+	waitFor(t, func() bool {
+		d.idleMu.Lock()
+		defer d.idleMu.Unlock()
+		return d.idle["idle"] != nil
+	})
+
+	reader, err := d.GetReader(context.Background(), Request{
+		StreamKey: "new", Streamer: &countingStreamer{}, Semaphore: sem, Linger: time.Hour})
+	if err != nil {
+		t.Fatalf("GetReader() error = %v", err)
+	}
+	defer reader.Close()
+	readSome(t, reader)
+
+	streams := d.Streams()
+	if len(streams) != 1 || streams[0].StreamKey != "new" {
+		t.Errorf("Streams() = %v, expected only the new stream", streams)
+	}
+	if inUse := sem.InUse(); inUse != 1 {
+		t.Errorf("semaphore in use = %d, expected 1", inUse)
+	}
+}
+
+func TestLingerSkippedWhenClientsForcedOut(t *testing.T) {
+	tests := []struct {
+		name  string
+		leave func(d *Demuxer, reader io.ReadCloser)
+	}{
+		{"stop stream", func(d *Demuxer, _ io.ReadCloser) { d.StopStream("forced") }},
+		{"disconnect subscribers", func(d *Demuxer, _ io.ReadCloser) { d.DisconnectClient("viewer") }},
+		{"client error", func(_ *Demuxer, reader io.ReadCloser) {
+			_ = reader.(interface{ CloseWithError(error) error }).CloseWithError(errors.New("probe failed"))
+		}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d := NewDemuxer()
+			defer d.Stop()
+
+			sem := utils.NewSemaphore("playlist", "test", 1)
+			streamer := &countingStreamer{}
+			req := Request{StreamKey: "forced", Streamer: streamer, Semaphore: sem, Linger: time.Hour}
+
+			reader, err := d.GetReader(clientContext("viewer", 0), req)
+			if err != nil {
+				t.Fatalf("GetReader() error = %v", err)
+			}
+			readSome(t, reader)
+
+			tt.leave(d, reader)
+			_ = reader.Close()
+
+			waitFor(t, func() bool { return sem.InUse() == 0 })
+			if streams := d.Streams(); len(streams) != 0 {
+				t.Errorf("Streams() = %v, expected no streams", streams)
+			}
+
+			reader, err = d.GetReader(clientContext("viewer", 0), req)
+			if err != nil {
+				t.Fatalf("GetReader() error = %v", err)
+			}
+			readSome(t, reader)
+			_ = reader.Close()
+
+			if starts := streamer.starts.Load(); starts != 2 {
+				t.Errorf("stream started %d times, expected a new stream", starts)
+			}
+		})
+	}
+}
@@ -0,0 +1,104 @@
+package demux
+
+import (
+	"context"
+	"majmun/internal/ctxutil"
+	"majmun/internal/logging"
+	"majmun/internal/utils"
+)
+
+// PreemptSubscriber disconnects the subscriber with the lowest priority, below the priority of the request,
+// so the slot it holds can go to the request. Among equal priorities the one that joined last is chosen.
+func (m *Demuxer) PreemptSubscriber(ctx context.Context) bool {
+	priority := ctxutil.Priority(ctx)
+
+	var victim *Subscriber
+	var victimKey string
+	for _, stream := range m.pool.Streams() {
+		for _, sub := range stream.Subscribers {
+			if sub.Priority >= priority {
+				continue
+			}
+			if victim == nil || sub.Priority < victim.Priority ||
+				(sub.Priority == victim.Priority && sub.JoinedAt.After(victim.JoinedAt)) {
+				victim = &sub
+				victimKey = stream.StreamKey
+			}
+		}
+	}
+
+	if victim == nil {
+		return false
+	}
+
+	logging.Info(ctx, "preempting subscriber", "preempted_client", victim.ClientName, "preempted_stream_id", victimKey)
+	return m.pool.DisconnectSubscribers(victimKey, func(s Subscriber) bool {
+		return s.ID == victim.ID
+	}, ErrPreempted) > 0
+}
+
+// preemptStream stops the stream holding the given playlist slot whose subscribers all have a lower priority
+// than the request, picking the one with the lowest priority, and waits for the slot.
+func (m *Demuxer) preemptStream(ctx context.Context, sem *utils.Semaphore) bool {
+	priority := ctxutil.Priority(ctx)
+
+	m.runningMu.Lock()
+	streams := make(map[string]*failover, len(m.running))
+	for key, f := range m.running {
+		streams[key] = f
+	}
+	m.runningMu.Unlock()
+
+	var victimKey string
+	victimPriority := priority
+	for key, f := range streams {
+		if f.semaphore() != sem {
+			continue
+		}
+
+		writer := m.pool.GetWriter(key)
+		if writer == nil {
+			continue
+		}
+
+		subscribers := writer.Subscribers()
+		if len(subscribers) == 0 {
+			continue
+		}
+
+		streamPriority := subscribers[0].Priority
+		for _, sub := range subscribers[1:] {
+			streamPriority = max(streamPriority, sub.Priority)
+		}
+		if streamPriority < victimPriority {
+			victimKey, victimPriority = key, streamPriority
+		}
+	}
+
+	if victimKey == "" {
+		return false
+	}
+
+	logging.Info(ctx, "preempting stream to free playlist slot", "preempted_stream_id", victimKey)
+
+	unlock := m.LockStream(victimKey)
+	m.pool.StopStream(victimKey, ErrPreempted)
+	unlock()
+
+	return utils.AcquireSemaphore(ctx, sem, evictTimeout, "subscription")
+}
+
+func (m *Demuxer) setRunning(key string, f *failover) {
+	m.runningMu.Lock()
+	defer m.runningMu.Unlock()
+	m.running[key] = f
+}
+
+// unsetRunning forgets the stream, unless the key already belongs to a new stream.
+func (m *Demuxer) unsetRunning(key string, f *failover) {
+	m.runningMu.Lock()
+	defer m.runningMu.Unlock()
+	if m.running[key] == f {
+		delete(m.running, key)
+	}
+}
@@ -0,0 +1,178 @@
+package demux
+
+import (
+	"context"
+	"errors"
+	"io"
+	"majmun/internal/config/proxy"
+	"majmun/internal/ctxutil"
+	"majmun/internal/utils"
+	"testing"
+	"time"
+)
+
+type priorityClient struct {
+	name     string
+	priority int
+}
+
+func (c priorityClient) Name() string  { return c.name }
+func (c priorityClient) Priority() int { return c.priority }
+
+func clientContext(name string, priority int) context.Context {
+	return ctxutil.WithClient(context.Background(), priorityClient{name: name, priority: priority})
+}
+
+func readUntilError(r io.Reader) error {
+	buf := make([]byte, 16)
+	for {
+		if _, err := r.Read(buf); err != nil {
+			return err
+		}
+	}
+}
+
+func TestPreemptSubscriberPicksLowestPriority(t *testing.T) {
+	d := NewDemuxer()
+	defer d.Stop()
+
+	low, err := d.GetReader(clientContext("low", 1), Request{StreamKey: "low", Streamer: &countingStreamer{}})
+	if err != nil {
+		t.Fatalf("GetReader() error = %v", err)
+	}
+	defer low.Close()
+	readSome(t, low)
+
+	mid, err := d.GetReader(clientContext("mid", 5), Request{StreamKey: "mid", Streamer: &countingStreamer{}})
+	if err != nil {
+		t.Fatalf("GetReader() error = %v", err)
+	}
+	defer mid.Close()
+	readSome(t, mid)
+
+	if d.PreemptSubscriber(clientContext("equal", 1)) {
+		t.Error("PreemptSubscriber() preempted a subscriber with the same priority")
+	}
+
+	if !d.PreemptSubscriber(clientContext("high", 10)) {
+		t.Fatal("PreemptSubscriber() = false, expected true")
+	}
+	if err := readUntilError(low); !errors.Is(err, ErrPreempted) {
+		t.Errorf("low priority reader error = %v, expected %v", err, ErrPreempted)
+	}
+
+	for _, stream := range d.Streams() {
+		for _, sub := range stream.Subscribers {
+			if sub.ClientName == "low" {
+				t.Error("low priority subscriber still connected")
+			}
+		}
+	}
+}
+
+func TestPreemptStreamFreesPlaylistSlot(t *testing.T) {
+	d := NewDemuxer()
+	defer d.Stop()
+
+	sem := utils.NewSemaphore("playlist", "test", 1)
+
+	low, err := d.GetReader(clientContext("low", 1), Request{
+		StreamKey: "low", Streamer: &countingStreamer{}, Semaphore: sem})
+	if err != nil {
+		t.Fatalf("GetReader() error = %v", err)
+	}
+	defer low.Close()
+	readSome(t, low)
+
+	if _, err := d.GetReader(clientContext("other", 1), Request{
+		StreamKey: "other", Streamer: &countingStreamer{}, Semaphore: sem, Preempt: true}); !errors.Is(err, ErrSubscriptionSemaphore) {
+		t.Fatalf("GetReader() error = %v, expected %v", err, ErrSubscriptionSemaphore)
+	}
+
+	high, err := d.GetReader(clientContext("high", 10), Request{
+		StreamKey: "high", Streamer: &countingStreamer{}, Semaphore: sem, Preempt: true})
+	if err != nil {
+		t.Fatalf("GetReader() error = %v", err)
+	}
+	defer high.Close()
+	readSome(t, high)
+
+	if err := readUntilError(low); !errors.Is(err, ErrPreempted) {
+		t.Errorf("low priority reader error = %v, expected %v", err, ErrPreempted)
+	}
+
+	streams := d.Streams()
+	if len(streams) != 1 || streams[0].StreamKey != "high" {
+		t.Errorf("Streams() = %v, expected only the high priority stream", streams)
+	}
+	if inUse := sem.InUse(); inUse != 1 {
+		t.Errorf("semaphore in use = %d, expected 1", inUse)
+	}
+}
+
+func TestCanceledWaiterLeavesQueue(t *testing.T) {
+	d := NewDemuxer()
+	defer d.Stop()
+
+	sem := utils.NewSemaphore("playlist", "test", 1).WithQueue(5*time.Second, proxy.QueueOrderFIFO)
+
+	running, err := d.GetReader(context.Background(), Request{
+		StreamKey: "running", Streamer: &countingStreamer{}, Semaphore: sem})
+	if err != nil {
+		t.Fatalf("GetReader() error = %v", err)
+	}
+	readSome(t, running)
+
+	// The first waiter hangs up while a second client of the same stream waits for it to start.
+	ctx, cancel := context.WithCancel(context.Background())
+	canceled := &countingStreamer{}
+	canceledErr := make(chan error, 1)
+	go func() {
+		_, err := d.GetReader(ctx, Request{StreamKey: "waiting", Streamer: canceled, Semaphore: sem})
+		canceledErr <- err
+	}()
+	waitFor(t, func() bool { return sem.Queued() == 1 })
+
+	joined := make(chan io.ReadCloser, 1)
+	go func() {
+		reader, err := d.GetReader(context.Background(), Request{
+			StreamKey: "waiting", Streamer: canceled, Semaphore: sem})
+		if err != nil {
+			t.Errorf("GetReader() of the joining client error = %v", err)
+		}
+		joined <- reader
+	}()
+	time.Sleep(50 * time.Millisecond)
+
+	cancel()
+	select {
+	case err := <-canceledErr:
+		if !errors.Is(err, context.Canceled) {
+			t.Errorf("GetReader() error = %v, expected %v", err, context.Canceled)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("GetReader() kept waiting after the context was canceled")
+	}
+
+	// The joining client takes over the start and waits in the queue behind nobody.
+	waitFor(t, func() bool { return sem.Queued() == 1 })
+	_ = running.Close()
+
+	select {
+	case reader := <-joined:
+		if reader == nil {
+			t.FailNow()
+		}
+		defer reader.Close()
+		readSome(t, reader)
+	case <-time.After(3 * time.Second):
+		t.Fatal("joining client did not get the slot after it was released")
+	}
+
+	if starts := canceled.starts.Load(); starts != 1 {
+		t.Errorf("stream started %d times, expected 1 for the joining client", starts)
+	}
+	if inUse := sem.InUse(); inUse != 1 {
+		t.Errorf("semaphore in use = %d, expected 1", inUse)
+	}
+}
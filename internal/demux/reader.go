@@ -7,17 +7,23 @@ import (
 
 type streamReader struct {
 	*io.PipeReader
-	closeFunc func()
+	closeFunc func(error)
 	once      sync.Once
 }
 
 func (sr *streamReader) Close() error {
-	var err error
+	return sr.CloseWithError(nil)
+}
+
+// CloseWithError closes the reader and removes its client from the stream with the error it failed with.
+// A stream whose last client left with an error is stopped instead of lingering.
+func (sr *streamReader) CloseWithError(err error) error {
+	var closeErr error
 	sr.once.Do(func() {
 		if sr.closeFunc != nil {
-			sr.closeFunc()
+			sr.closeFunc(err)
 		}
-		err = sr.PipeReader.Close()
+		closeErr = sr.PipeReader.Close()
 	})
-	return err
+	return closeErr
 }
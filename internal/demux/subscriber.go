@@ -0,0 +1,180 @@
+package demux
+
+import (
+	"context"
+	"errors"
+	"io"
+	"majmun/internal/logging"
+	"majmun/internal/metrics"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	// minBufferSize keeps room in the ring for subscribers that fall behind, even without replay.
+	minBufferSize = 4 * 1024 * 1024
+
+	// readChunkSize is the size of the scratch buffer each subscriber copies ring data into.
+	readChunkSize = 64 * 1024
+
+	blockPollInterval = 10 * time.Millisecond
+
+	// drainTimeout bounds how long a subscriber without a slow client timeout gets to receive the end of the stream.
+	drainTimeout = 10 * time.Second
+)
+
+var ErrSlowClient = errors.New("client too slow for the stream")
+
+type SlowClientPolicy string
+
+const (
+	// SlowClientDrop skips a subscriber that fell out of the ring ahead to the latest join point.
+	SlowClientDrop SlowClientPolicy = "drop"
+	// SlowClientBlock holds the stream for up to the timeout before the subscriber is disconnected.
+	SlowClientBlock SlowClientPolicy = "block"
+	// SlowClientDisconnect disconnects a subscriber as soon as it falls out of the ring.
+	SlowClientDisconnect SlowClientPolicy = "disconnect"
+)
+
+// SlowClient decides what happens to a subscriber that does not read fast enough to keep up with the stream.
+type SlowClient struct {
+	Policy  SlowClientPolicy
+	Timeout time.Duration
+}
+
+type Subscriber struct {
+	ID         string    `json:"id"`
+	ClientName string    `json:"client_name"`
+	Priority   int       `json:"priority"`
+	JoinedAt   time.Time `json:"joined_at"`
+}
+
+type subscriberState int
+
+const (
+	subscriberActive subscriberState = iota
+	subscriberDraining
+	subscriberStopped
+)
+
+type subscriber struct {
+	ctx        context.Context
+	client     io.WriteCloser
+	info       Subscriber
+	slowClient SlowClient
+
+	// pending holds the tables sent before the buffered data, only used by the subscriber goroutine.
+	// cursor is the next stream offset to send, it is read by the writer when holding the stream for the subscriber.
+	pending []byte
+	cursor  atomic.Int64
+
+	// state is guarded by the writer's buffer lock.
+	state subscriberState
+	done  chan struct{}
+}
+
+// serve copies data from the ring to the client until the subscriber is stopped or the client fails.
+func (sw *StreamWriter) serve(sub *subscriber) {
+	defer close(sub.done)
+
+	if len(sub.pending) > 0 {
+		if _, err := sub.client.Write(sub.pending); err != nil {
+			return
+		}
+		sub.pending = nil
+	}
+
+	scratch := make([]byte, readChunkSize)
+	for {
+		n, dropped, err := sw.next(sub, scratch)
+		if dropped > 0 {
+			metrics.AddStreamsDroppedBytes(sub.ctx, dropped)
+			logging.Info(sub.ctx, "client fell behind the stream, skipping data", "dropped_bytes", dropped)
+		}
+		if errors.Is(err, ErrSlowClient) {
+			metrics.IncSlowClientsDisconnected(sub.ctx)
+			logging.Info(sub.ctx, "client fell behind the stream, disconnecting")
+			closeClient(sub.client, err)
+			return
+		}
+		if err != nil {
+			return
+		}
+
+		if _, err := sub.client.Write(scratch[:n]); err != nil {
+			return
+		}
+	}
+}
+
+// next waits for data past the subscriber's cursor and copies it into p.
+// It returns io.EOF once the subscriber is stopped, and applies the slow client policy
+// when the subscriber fell out of the ring, returning the number of skipped bytes.
+func (sw *StreamWriter) next(sub *subscriber, p []byte) (int, int64, error) {
+	sw.bufferLock.RLock()
+	defer sw.bufferLock.RUnlock()
+
+	cursor := sub.cursor.Load()
+	for cursor == sw.bufferEnd && sub.state == subscriberActive {
+		sw.dataReady.Wait()
+	}
+
+	if sub.state == subscriberStopped || cursor == sw.bufferEnd {
+		return 0, 0, io.EOF
+	}
+
+	var dropped int64
+	if oldest := sw.oldest(); cursor < oldest {
+		if sub.slowClient.Policy == SlowClientBlock || sub.slowClient.Policy == SlowClientDisconnect {
+			return 0, 0, ErrSlowClient
+		}
+		start, _ := sw.join.start(oldest)
+		dropped = start - cursor
+		cursor = start
+	}
+
+	n := sw.readAt(p, cursor)
+	sub.cursor.Store(cursor + int64(n))
+	return n, dropped, nil
+}
+
+// waitForSlowClients holds a write of n bytes while it would overwrite data a blocking subscriber has not read yet,
+// for at most the subscriber's timeout.
+func (sw *StreamWriter) waitForSlowClients(n int) {
+	start := time.Now()
+	for sw.wouldOverrun(n, time.Since(start)) {
+		time.Sleep(blockPollInterval)
+	}
+}
+
+func (sw *StreamWriter) wouldOverrun(n int, waited time.Duration) bool {
+	sw.clientsLock.RLock()
+	defer sw.clientsLock.RUnlock()
+
+	sw.bufferLock.RLock()
+	defer sw.bufferLock.RUnlock()
+
+	limit := sw.bufferEnd + int64(n) - int64(len(sw.buffer))
+	for _, sub := range sw.clients {
+		if sub.slowClient.Policy != SlowClientBlock || waited >= sub.slowClient.Timeout {
+			continue
+		}
+		if sub.state == subscriberActive && sub.cursor.Load() < limit {
+			return true
+		}
+	}
+	return false
+}
+
+// stop ends the subscriber goroutine, after it has sent all buffered data when draining.
+func (sw *StreamWriter) stop(sub *subscriber, drain bool) {
+	sw.bufferLock.Lock()
+	if drain {
+		sub.state = subscriberDraining
+	} else {
+		sub.state = subscriberStopped
+	}
+	sw.bufferLock.Unlock()
+
+	sw.dataReady.Broadcast()
+}
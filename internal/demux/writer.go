@@ -1,125 +1,181 @@
 package demux
 
 import (
+	"context"
 	"io"
-	"majmun/internal/ioutil"
+	"majmun/internal/ctxutil"
+	"majmun/internal/logging"
+	"majmun/internal/metrics"
+	"maps"
 	"sync"
-)
-
-const (
-	bufferSize = 32 * 1024 * 1024
+	"sync/atomic"
+	"time"
 )
 
 type StreamWriter struct {
-	clients     map[io.WriteCloser]*ioutil.AsyncWriter
+	clients     map[io.WriteCloser]*subscriber
 	clientsLock sync.RWMutex
 
+	channelName  string
+	playlistName string
+	startedAt    time.Time
+	bytesWritten atomic.Int64
+
+	// buffer is a ring shared by all subscribers, each reading it at its own offset.
+	// bufferEnd is the stream offset right after the last written byte.
 	buffer     []byte
-	bufferPos  int
-	bufferFull bool
+	bufferEnd  int64
+	replaySize int64
+	join       joinIndex
 	bufferLock sync.RWMutex
+	dataReady  *sync.Cond
+	blocking   atomic.Int32
 
 	emptyNotify     chan struct{}
 	notifyListeners sync.Map
+	emptySince      time.Time
+
+	// leaveErr is the error the last removed client left with, nil when it left on its own.
+	leaveErr error
+
+	// started is closed once the first client acquired the slots and started the stream, or failed with startErr.
+	started  chan struct{}
+	startErr error
 }
 
-func NewStreamWriter() *StreamWriter {
-	return &StreamWriter{
-		clients:     make(map[io.WriteCloser]*ioutil.AsyncWriter),
-		buffer:      make([]byte, bufferSize),
-		bufferPos:   0,
-		bufferFull:  false,
-		emptyNotify: make(chan struct{}),
+// NewStreamWriter creates a writer that starts joining clients with up to replaySize bytes of recent data.
+// The ring is never smaller than minBufferSize, which leaves room for clients that fall behind.
+func NewStreamWriter(channelName, playlistName string, replaySize int) *StreamWriter {
+	sw := &StreamWriter{
+		clients:      make(map[io.WriteCloser]*subscriber),
+		channelName:  channelName,
+		playlistName: playlistName,
+		startedAt:    time.Now(),
+		buffer:       make([]byte, max(replaySize, minBufferSize)),
+		replaySize:   int64(max(replaySize, 0)),
+		join:         newJoinIndex(),
+		emptyNotify:  make(chan struct{}),
+		started:      make(chan struct{}),
 	}
+	sw.dataReady = sync.NewCond(sw.bufferLock.RLocker())
+
+	return sw
 }
 
-func (sw *StreamWriter) AddClient(w io.WriteCloser) {
+func (sw *StreamWriter) AddClient(ctx context.Context, w io.WriteCloser, slowClient SlowClient) {
 	sw.clientsLock.Lock()
 	defer sw.clientsLock.Unlock()
 
-	cw := ioutil.NewAsyncWriter(w)
-	sw.clients[w] = cw
+	sub := &subscriber{
+		ctx:        ctx,
+		client:     w,
+		slowClient: slowClient,
+		info: Subscriber{
+			ID:         ctxutil.RequestID(ctx),
+			ClientName: ctxutil.ClientName(ctx),
+			Priority:   ctxutil.Priority(ctx),
+			JoinedAt:   time.Now(),
+		},
+		done: make(chan struct{}),
+	}
 
 	sw.bufferLock.RLock()
-	defer sw.bufferLock.RUnlock()
+	oldest := max(sw.oldest(), sw.bufferEnd-sw.replaySize)
+	start, tables := sw.join.start(oldest)
+	sw.bufferLock.RUnlock()
 
-	if !sw.bufferFull && sw.bufferPos == 0 {
-		return
+	sub.cursor.Store(start)
+	for _, table := range tables {
+		sub.pending = append(sub.pending, table...)
 	}
 
-	if sw.bufferFull {
-		fullBuffer := make([]byte, bufferSize)
-		copy(fullBuffer, sw.buffer[sw.bufferPos:])
-		copy(fullBuffer[len(sw.buffer)-sw.bufferPos:], sw.buffer[:sw.bufferPos])
-		cw.Write(fullBuffer)
-	} else {
-		validData := make([]byte, sw.bufferPos)
-		copy(validData, sw.buffer[:sw.bufferPos])
-		cw.Write(validData)
+	if slowClient.Policy == SlowClientBlock {
+		sw.blocking.Add(1)
 	}
+
+	sw.emptySince = time.Time{}
+	sw.leaveErr = nil
+	sw.clients[w] = sub
+
+	go sw.serve(sub)
 }
 
+// Write stores the data in the ring and wakes up the subscribers.
+// It only waits for subscribers with the block policy that would lose data otherwise.
 func (sw *StreamWriter) Write(p []byte) (n int, err error) {
 	if len(p) == 0 {
 		return 0, nil
 	}
 
-	data := make([]byte, len(p))
-	copy(data, p)
+	if sw.blocking.Load() > 0 {
+		sw.waitForSlowClients(len(p))
+	}
+
+	sw.bytesWritten.Add(int64(len(p)))
 
 	sw.bufferLock.Lock()
-	if len(data) >= bufferSize {
-		copy(sw.buffer, data[len(data)-bufferSize:])
-		sw.bufferPos = 0
-		sw.bufferFull = true
-	} else {
-		remaining := bufferSize - sw.bufferPos
-		if len(data) <= remaining {
-			copy(sw.buffer[sw.bufferPos:], data)
-			sw.bufferPos += len(data)
-			if sw.bufferPos == bufferSize {
-				sw.bufferPos = 0
-				sw.bufferFull = true
-			}
-		} else {
-			copy(sw.buffer[sw.bufferPos:], data[:remaining])
-			copy(sw.buffer, data[remaining:])
-			sw.bufferPos = len(data) - remaining
-			sw.bufferFull = true
-		}
-	}
+	sw.join.observe(p, sw.bufferEnd)
+	sw.writeBuffer(p)
 	sw.bufferLock.Unlock()
 
-	sw.clientsLock.RLock()
-	clientCount := len(sw.clients)
+	sw.dataReady.Broadcast()
+
+	return len(p), nil
+}
 
-	if clientCount == 0 {
-		sw.clientsLock.RUnlock()
-		return len(data), nil
+func (sw *StreamWriter) writeBuffer(data []byte) {
+	size := len(sw.buffer)
+	if len(data) > size {
+		sw.bufferEnd += int64(len(data) - size)
+		data = data[len(data)-size:]
 	}
 
-	sharedData := make([]byte, len(data))
-	copy(sharedData, data)
+	pos := int(sw.bufferEnd % int64(size))
+	n := copy(sw.buffer[pos:], data)
+	copy(sw.buffer, data[n:])
+	sw.bufferEnd += int64(len(data))
+}
 
-	for _, cw := range sw.clients {
-		cw.Write(sharedData)
-	}
-	sw.clientsLock.RUnlock()
+// oldest returns the offset of the oldest byte still in the ring.
+func (sw *StreamWriter) oldest() int64 {
+	return max(0, sw.bufferEnd-int64(len(sw.buffer)))
+}
+
+// readAt copies buffered data starting at the given offset into p, up to the end of the ring.
+func (sw *StreamWriter) readAt(p []byte, offset int64) int {
+	pos := int(offset % int64(len(sw.buffer)))
+	n := min(len(p), len(sw.buffer)-pos, int(sw.bufferEnd-offset))
+	return copy(p[:n], sw.buffer[pos:])
+}
+
+func (sw *StreamWriter) markStarted(err error) {
+	sw.startErr = err
+	close(sw.started)
+}
 
-	return len(data), nil
+// waitStarted waits until the stream the writer belongs to is started and returns the error it failed with.
+func (sw *StreamWriter) waitStarted(ctx context.Context) error {
+	select {
+	case <-sw.started:
+		return sw.startErr
+	case <-ctx.Done():
+		return ctx.Err()
+	}
 }
 
-func (sw *StreamWriter) RemoveClient(w io.WriteCloser) {
+func (sw *StreamWriter) RemoveClient(w io.WriteCloser, err error) {
 	sw.clientsLock.Lock()
 	defer sw.clientsLock.Unlock()
 
-	cw, exists := sw.clients[w]
+	sub, exists := sw.clients[w]
 	if !exists {
 		return
 	}
 
-	cw.Close()
-	delete(sw.clients, w)
+	sw.stop(sub, false)
+	<-sub.done
+	sw.remove(w, sub)
+	sw.leaveErr = err
 
 	if len(sw.clients) == 0 {
 		sw.notifyEmpty()
@@ -127,13 +183,25 @@ func (sw *StreamWriter) RemoveClient(w io.WriteCloser) {
 }
 
 func (sw *StreamWriter) Close() {
+	sw.CloseWithError(nil)
+}
+
+func (sw *StreamWriter) CloseWithError(err error) {
+	if err == nil {
+		sw.drain()
+	}
+
 	sw.clientsLock.Lock()
 	defer sw.clientsLock.Unlock()
 
-	for client, cw := range sw.clients {
-		cw.Close()
-		_ = client.Close()
-		delete(sw.clients, client)
+	if len(sw.clients) > 0 {
+		sw.leaveErr = err
+	}
+	for client, sub := range sw.clients {
+		sw.stop(sub, false)
+		closeClient(client, err)
+		<-sub.done
+		sw.remove(client, sub)
 	}
 
 	if len(sw.clients) == 0 {
@@ -141,18 +209,134 @@ func (sw *StreamWriter) Close() {
 	}
 }
 
+// drain lets the subscribers send the rest of the buffered data before their connection is closed on a normal end.
+// A subscriber that does not finish within its slow client timeout, or drainTimeout without one, is disconnected,
+// so a stalled client cannot hold the stream teardown. The clients lock is only held to take the subscribers,
+// so leaving clients and the admin API are not blocked while slow clients are waited for.
+func (sw *StreamWriter) drain() {
+	sw.clientsLock.Lock()
+	clients := maps.Clone(sw.clients)
+	for _, sub := range clients {
+		sw.stop(sub, true)
+	}
+	sw.clientsLock.Unlock()
+
+	start := time.Now()
+	for client, sub := range clients {
+		timeout := drainTimeout
+		if sub.slowClient.Timeout > 0 {
+			timeout = sub.slowClient.Timeout
+		}
+
+		timer := time.NewTimer(time.Until(start.Add(timeout)))
+		select {
+		case <-sub.done:
+			closeClient(client, nil)
+		case <-timer.C:
+			metrics.IncSlowClientsDisconnected(sub.ctx)
+			logging.Info(sub.ctx, "client did not receive the end of the stream in time, disconnecting")
+			sw.stop(sub, false)
+			closeClient(client, ErrSlowClient)
+			<-sub.done
+		}
+		timer.Stop()
+
+		sw.clientsLock.Lock()
+		if sw.clients[client] == sub {
+			sw.remove(client, sub)
+		}
+		sw.clientsLock.Unlock()
+	}
+}
+
+func (sw *StreamWriter) DisconnectSubscribers(match func(Subscriber) bool, err error) int {
+	sw.clientsLock.Lock()
+	defer sw.clientsLock.Unlock()
+
+	disconnected := 0
+	for client, sub := range sw.clients {
+		if !match(sub.info) {
+			continue
+		}
+		sw.stop(sub, false)
+		closeClient(client, err)
+		<-sub.done
+		sw.remove(client, sub)
+		sw.leaveErr = err
+		disconnected++
+	}
+
+	if disconnected > 0 && len(sw.clients) == 0 {
+		sw.notifyEmpty()
+	}
+
+	return disconnected
+}
+
+func (sw *StreamWriter) remove(client io.WriteCloser, sub *subscriber) {
+	if sub.slowClient.Policy == SlowClientBlock {
+		sw.blocking.Add(-1)
+	}
+	delete(sw.clients, client)
+}
+
 func (sw *StreamWriter) ClientCount() int {
 	sw.clientsLock.RLock()
 	defer sw.clientsLock.RUnlock()
 	return len(sw.clients)
 }
 
+func (sw *StreamWriter) Subscribers() []Subscriber {
+	sw.clientsLock.RLock()
+	defer sw.clientsLock.RUnlock()
+
+	result := make([]Subscriber, 0, len(sw.clients))
+	for _, sub := range sw.clients {
+		result = append(result, sub.info)
+	}
+	return result
+}
+
+func (sw *StreamWriter) ChannelName() string {
+	return sw.channelName
+}
+
+func (sw *StreamWriter) PlaylistName() string {
+	return sw.playlistName
+}
+
+func (sw *StreamWriter) StartedAt() time.Time {
+	return sw.startedAt
+}
+
+func (sw *StreamWriter) BytesWritten() int64 {
+	return sw.bytesWritten.Load()
+}
+
 func (sw *StreamWriter) IsEmpty() bool {
 	sw.clientsLock.RLock()
 	defer sw.clientsLock.RUnlock()
 	return len(sw.clients) == 0
 }
 
+// IdleFor returns how long the writer has had no clients, or zero while it has any.
+func (sw *StreamWriter) IdleFor() time.Duration {
+	sw.clientsLock.RLock()
+	defer sw.clientsLock.RUnlock()
+
+	if len(sw.clients) > 0 || sw.emptySince.IsZero() {
+		return 0
+	}
+	return time.Since(sw.emptySince)
+}
+
+// LeaveError returns the error the last removed client left with, nil when it left on its own.
+func (sw *StreamWriter) LeaveError() error {
+	sw.clientsLock.RLock()
+	defer sw.clientsLock.RUnlock()
+	return sw.leaveErr
+}
+
 func (sw *StreamWriter) CancelEmptyChannel(ch <-chan struct{}) {
 	sw.notifyListeners.Delete(ch)
 }
@@ -163,7 +347,17 @@ func (sw *StreamWriter) IsEmptyChannel() <-chan struct{} {
 	return notifyCh
 }
 
+func closeClient(client io.WriteCloser, err error) {
+	if ec, ok := client.(interface{ CloseWithError(error) error }); ok && err != nil {
+		_ = ec.CloseWithError(err)
+		return
+	}
+	_ = client.Close()
+}
+
 func (sw *StreamWriter) notifyEmpty() {
+	sw.emptySince = time.Now()
+
 	sw.notifyListeners.Range(func(key, value any) bool {
 		ch, ok := key.(chan struct{})
 		if ok {
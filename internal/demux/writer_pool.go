@@ -1,10 +1,24 @@
 package demux
 
 import (
+	"context"
 	"io"
+	"majmun/internal/ctxutil"
+	"sort"
 	"sync"
+	"time"
 )
 
+type StreamInfo struct {
+	StreamKey    string       `json:"stream_key"`
+	ChannelName  string       `json:"channel_name"`
+	PlaylistName string       `json:"playlist_name"`
+	StartedAt    time.Time    `json:"started_at"`
+	Uptime       string       `json:"uptime"`
+	BytesSent    int64        `json:"bytes_sent"`
+	Subscribers  []Subscriber `json:"subscribers"`
+}
+
 type WriterPool struct {
 	writers map[string]*StreamWriter
 	mutex   sync.Mutex
@@ -20,65 +34,142 @@ func NewWriterPool() *WriterPool {
 	return pool
 }
 
+// Stop closes all writers. They are removed under the pool lock and closed concurrently without it,
+// so the slow clients of one stream do not hold up the others or the pool.
 func (p *WriterPool) Stop() {
 	p.mutex.Lock()
-	defer p.mutex.Unlock()
-
 	select {
 	case <-p.doneCh:
 	default:
 		close(p.doneCh)
 	}
 
+	writers := make([]*StreamWriter, 0, len(p.writers))
 	for key, writer := range p.writers {
-		writer.Close()
+		writers = append(writers, writer)
 		delete(p.writers, key)
 	}
+	p.mutex.Unlock()
+
+	var wg sync.WaitGroup
+	for _, writer := range writers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			writer.Close()
+		}()
+	}
+	wg.Wait()
 }
 
-func (p *WriterPool) AddClient(streamKey string, client io.WriteCloser) bool {
+func (p *WriterPool) AddClient(ctx context.Context, req Request, client io.WriteCloser) bool {
 	p.mutex.Lock()
 	defer p.mutex.Unlock()
 
-	writer, exists := p.writers[streamKey]
+	writer, exists := p.writers[req.StreamKey]
 	if !exists {
-		writer = NewStreamWriter()
-		p.writers[streamKey] = writer
+		writer = NewStreamWriter(ctxutil.ChannelName(ctx), ctxutil.ProviderName(ctx), req.BufferSize)
+		p.writers[req.StreamKey] = writer
 	}
 
-	writer.AddClient(client)
+	writer.AddClient(ctx, client, req.SlowClient)
 	return !exists
 }
 
-func (p *WriterPool) RemoveClient(streamKey string, client io.WriteCloser) {
+// RemoveClient removes the client from its stream. A client removed with an error that leaves the stream
+// without clients also removes the writer, so a retry does not join the stream it failed on.
+func (p *WriterPool) RemoveClient(streamKey string, client io.WriteCloser, err error) {
 	p.mutex.Lock()
 	writer, exists := p.writers[streamKey]
 	p.mutex.Unlock()
 
 	if exists {
-		writer.RemoveClient(client)
-		p.cleanup()
+		writer.RemoveClient(client, err)
+		if err != nil {
+			p.removeIfEmpty(streamKey, writer)
+		}
 	}
 }
 
-func (p *WriterPool) GetWriter(streamKey string) *StreamWriter {
+// removeIfEmpty removes the writer when no client joined it in the meantime.
+func (p *WriterPool) removeIfEmpty(streamKey string, writer *StreamWriter) {
 	p.mutex.Lock()
 	defer p.mutex.Unlock()
-	return p.writers[streamKey]
+
+	if p.writers[streamKey] == writer && writer.IsEmpty() {
+		delete(p.writers, streamKey)
+	}
 }
 
-func (p *WriterPool) cleanup() {
+// RemoveWriter removes the writer of an ended stream, unless the key already belongs to a new stream.
+func (p *WriterPool) RemoveWriter(streamKey string, writer *StreamWriter) {
 	p.mutex.Lock()
 	defer p.mutex.Unlock()
 
-	var keysToRemove []string
+	if p.writers[streamKey] == writer {
+		delete(p.writers, streamKey)
+	}
+}
+
+func (p *WriterPool) StopStream(streamKey string, err error) bool {
+	p.mutex.Lock()
+	writer, exists := p.writers[streamKey]
+	delete(p.writers, streamKey)
+	p.mutex.Unlock()
+
+	if exists {
+		writer.CloseWithError(err)
+	}
+	return exists
+}
+
+func (p *WriterPool) DisconnectSubscribers(streamKey string, match func(Subscriber) bool, err error) int {
+	p.mutex.Lock()
+	writers := make(map[string]*StreamWriter, len(p.writers))
 	for key, writer := range p.writers {
-		if writer.IsEmpty() {
-			keysToRemove = append(keysToRemove, key)
+		if streamKey == "" || key == streamKey {
+			writers[key] = writer
 		}
 	}
+	p.mutex.Unlock()
 
-	for _, key := range keysToRemove {
-		delete(p.writers, key)
+	disconnected := 0
+	for key, writer := range writers {
+		if n := writer.DisconnectSubscribers(match, err); n > 0 {
+			disconnected += n
+			p.removeIfEmpty(key, writer)
+		}
+	}
+
+	return disconnected
+}
+
+func (p *WriterPool) GetWriter(streamKey string) *StreamWriter {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	return p.writers[streamKey]
+}
+
+func (p *WriterPool) Streams() []StreamInfo {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	result := make([]StreamInfo, 0, len(p.writers))
+	for key, writer := range p.writers {
+		result = append(result, StreamInfo{
+			StreamKey:    key,
+			ChannelName:  writer.ChannelName(),
+			PlaylistName: writer.PlaylistName(),
+			StartedAt:    writer.StartedAt(),
+			Uptime:       time.Since(writer.StartedAt()).Round(time.Second).String(),
+			BytesSent:    writer.BytesWritten(),
+			Subscribers:  writer.Subscribers(),
+		})
 	}
+
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].StartedAt.Before(result[j].StartedAt)
+	})
+
+	return result
 }
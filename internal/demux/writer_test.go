@@ -0,0 +1,289 @@
+package demux
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+	"testing"
+	"time"
+)
+
+type discardClient struct {
+	written int
+}
+
+func (c *discardClient) Write(p []byte) (int, error) {
+	c.written += len(p)
+	return len(p), nil
+}
+
+func (c *discardClient) Close() error {
+	return nil
+}
+
+// blockingClient accepts no data until it is released.
+type blockingClient struct {
+	bufferClient
+	release chan struct{}
+	err     error
+}
+
+func newBlockingClient() *blockingClient {
+	return &blockingClient{release: make(chan struct{})}
+}
+
+func (c *blockingClient) Write(p []byte) (int, error) {
+	<-c.release
+	return c.bufferClient.Write(p)
+}
+
+func (c *blockingClient) CloseWithError(err error) error {
+	if c.err == nil {
+		c.err = err
+	}
+	return nil
+}
+
+// overrun writes twice the ring size in chunks.
+func overrun(sw *StreamWriter) int {
+	chunk := bytes.Repeat([]byte{1}, readChunkSize)
+	for i := 0; i < 2*minBufferSize/readChunkSize; i++ {
+		_, _ = sw.Write(chunk)
+	}
+	return 2 * minBufferSize
+}
+
+func TestStreamWriterFanOut(t *testing.T) {
+	sw := NewStreamWriter("channel", "playlist", 0)
+
+	clients := make([]*bufferClient, 5)
+	for i := range clients {
+		clients[i] = &bufferClient{}
+		sw.AddClient(context.Background(), clients[i], SlowClient{})
+	}
+
+	var expected bytes.Buffer
+	for i := 0; i < 1000; i++ {
+		chunk := []byte(fmt.Sprintf("chunk %d;", i))
+		expected.Write(chunk)
+		_, _ = sw.Write(chunk)
+	}
+	sw.Close()
+
+	for i, client := range clients {
+		if !bytes.Equal(client.Bytes(), expected.Bytes()) {
+			t.Errorf("client %d received %d bytes, expected %d", i, client.Len(), expected.Len())
+		}
+	}
+}
+
+func TestSlowClientDrop(t *testing.T) {
+	sw := NewStreamWriter("channel", "playlist", 0)
+
+	slow := newBlockingClient()
+	sw.AddClient(context.Background(), slow, SlowClient{Policy: SlowClientDrop})
+
+	// the writer does not wait for the slow client, which skips the data that left the ring
+	overrun(sw)
+	close(slow.release)
+	sw.Close()
+
+	if received := slow.Len(); received > minBufferSize+readChunkSize {
+		t.Errorf("slow client received %d bytes, expected no more than the ring", received)
+	}
+	if slow.err != nil {
+		t.Errorf("slow client closed with %v, expected no error", slow.err)
+	}
+}
+
+func TestSlowClientDisconnect(t *testing.T) {
+	sw := NewStreamWriter("channel", "playlist", 0)
+
+	slow := newBlockingClient()
+	sw.AddClient(context.Background(), slow, SlowClient{Policy: SlowClientDisconnect})
+
+	overrun(sw)
+	close(slow.release)
+	sw.Close()
+
+	if !errors.Is(slow.err, ErrSlowClient) {
+		t.Errorf("slow client closed with %v, expected %v", slow.err, ErrSlowClient)
+	}
+}
+
+func TestSlowClientBlock(t *testing.T) {
+	sw := NewStreamWriter("channel", "playlist", 0)
+
+	slow := newBlockingClient()
+	sw.AddClient(context.Background(), slow, SlowClient{Policy: SlowClientBlock, Timeout: 5 * time.Second})
+
+	written := make(chan int)
+	go func() { written <- overrun(sw) }()
+
+	time.Sleep(100 * time.Millisecond)
+	if n := sw.BytesWritten(); n > minBufferSize+readChunkSize {
+		t.Errorf("writer wrote %d bytes while the client was blocked, expected no more than the ring", n)
+	}
+
+	close(slow.release)
+	total := <-written
+	sw.Close()
+
+	if slow.Len() != total {
+		t.Errorf("slow client received %d bytes, expected %d", slow.Len(), total)
+	}
+	if slow.err != nil {
+		t.Errorf("slow client closed with %v, expected no error", slow.err)
+	}
+}
+
+// stalledClient accepts no data until it is closed, like a pipe nobody reads.
+type stalledClient struct {
+	closed chan struct{}
+	once   sync.Once
+	err    error
+}
+
+func (c *stalledClient) Write(p []byte) (int, error) {
+	<-c.closed
+	return 0, io.ErrClosedPipe
+}
+
+func (c *stalledClient) Close() error {
+	return c.CloseWithError(nil)
+}
+
+func (c *stalledClient) CloseWithError(err error) error {
+	c.once.Do(func() {
+		c.err = err
+		close(c.closed)
+	})
+	return nil
+}
+
+func TestStreamWriterCloseStalledClient(t *testing.T) {
+	sw := NewStreamWriter("channel", "playlist", 0)
+
+	stalled := &stalledClient{closed: make(chan struct{})}
+	sw.AddClient(context.Background(), stalled, SlowClient{Policy: SlowClientBlock, Timeout: 50 * time.Millisecond})
+	healthy := &bufferClient{}
+	sw.AddClient(context.Background(), healthy, SlowClient{})
+
+	_, _ = sw.Write([]byte("data"))
+
+	closed := make(chan struct{})
+	go func() {
+		sw.Close()
+		close(closed)
+	}()
+
+	select {
+	case <-closed:
+	case <-time.After(2 * time.Second):
+		t.Fatal("Close() did not return while a client was stalled")
+	}
+	if !errors.Is(stalled.err, ErrSlowClient) {
+		t.Errorf("stalled client closed with %v, expected %v", stalled.err, ErrSlowClient)
+	}
+	if healthy.String() != "data" {
+		t.Errorf("healthy client received %q, expected the buffered data", healthy.String())
+	}
+	if !sw.IsEmpty() {
+		t.Error("clients left after Close()")
+	}
+}
+
+func TestStreamWriterDrainDoesNotBlockClients(t *testing.T) {
+	sw := NewStreamWriter("channel", "playlist", 0)
+
+	stalled := &stalledClient{closed: make(chan struct{})}
+	sw.AddClient(context.Background(), stalled, SlowClient{Policy: SlowClientBlock, Timeout: time.Second})
+	_, _ = sw.Write([]byte("data"))
+
+	closed := make(chan struct{})
+	go func() {
+		sw.Close()
+		close(closed)
+	}()
+	time.Sleep(50 * time.Millisecond)
+
+	listed := make(chan int)
+	go func() { listed <- len(sw.Subscribers()) }()
+
+	select {
+	case n := <-listed:
+		if n != 1 {
+			t.Errorf("Subscribers() returned %d subscribers while draining, expected 1", n)
+		}
+	case <-closed:
+		t.Fatal("Subscribers() blocked until the drain ended")
+	}
+	<-closed
+}
+
+func TestWriterPoolStopClosesWritersConcurrently(t *testing.T) {
+	pool := NewWriterPool()
+
+	const timeout = 500 * time.Millisecond
+	for _, key := range []string{"a", "b", "c"} {
+		stalled := &stalledClient{closed: make(chan struct{})}
+		pool.AddClient(context.Background(), Request{
+			StreamKey: key, SlowClient: SlowClient{Policy: SlowClientBlock, Timeout: timeout}}, stalled)
+		_, _ = pool.GetWriter(key).Write([]byte("data"))
+	}
+
+	start := time.Now()
+	pool.Stop()
+
+	if elapsed := time.Since(start); elapsed >= 2*timeout {
+		t.Errorf("Stop() took %v, expected the writers to be drained concurrently", elapsed)
+	}
+	if streams := pool.Streams(); len(streams) != 0 {
+		t.Errorf("Streams() = %v, expected no streams", streams)
+	}
+}
+
+func BenchmarkStreamWriterFanOut(b *testing.B) {
+	chunk := buildStream(35, 5)[:7*188*4]
+
+	for _, clients := range []int{1, 10, 50} {
+		b.Run(fmt.Sprintf("clients=%d", clients), func(b *testing.B) {
+			sw := NewStreamWriter("channel", "playlist", 0)
+			for i := 0; i < clients; i++ {
+				sw.AddClient(context.Background(), &discardClient{}, SlowClient{})
+			}
+
+			b.SetBytes(int64(len(chunk)))
+			b.ReportAllocs()
+			b.ResetTimer()
+
+			for i := 0; i < b.N; i++ {
+				_, _ = sw.Write(chunk)
+			}
+
+			b.StopTimer()
+			sw.Close()
+		})
+	}
+}
+
+// BenchmarkStreamWriterJoin measures the cost of a client joining a stream with a full replay buffer.
+func BenchmarkStreamWriterJoin(b *testing.B) {
+	sw := NewStreamWriter("channel", "playlist", 32*1024*1024)
+	stream := buildStream(10000, 250)
+	for written := 0; written < 32*1024*1024; written += len(stream) {
+		_, _ = sw.Write(stream)
+	}
+
+	b.ReportAllocs()
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		client := &discardClient{}
+		sw.AddClient(context.Background(), client, SlowClient{})
+		sw.RemoveClient(client, nil)
+	}
+}
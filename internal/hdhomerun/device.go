@@ -0,0 +1,120 @@
+package hdhomerun
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"fmt"
+	"strings"
+)
+
+const (
+	Manufacturer    = "Silicondust"
+	ModelNumber     = "HDTC-2US"
+	FirmwareName    = "hdhomeruntc_atsc"
+	FirmwareVersion = "20200101"
+	DeviceAuth      = "majmun"
+	DeviceType      = "urn:schemas-upnp-org:device:MediaServer:1"
+
+	DefaultTunerCount = 4
+)
+
+type Device struct {
+	ID           string
+	FriendlyName string
+	BaseURL      string
+	TunerCount   int
+}
+
+type Discover struct {
+	FriendlyName    string `json:"FriendlyName"`
+	Manufacturer    string `json:"Manufacturer"`
+	ModelNumber     string `json:"ModelNumber"`
+	FirmwareName    string `json:"FirmwareName"`
+	FirmwareVersion string `json:"FirmwareVersion"`
+	DeviceID        string `json:"DeviceID"`
+	DeviceAuth      string `json:"DeviceAuth"`
+	BaseURL         string `json:"BaseURL"`
+	LineupURL       string `json:"LineupURL"`
+	TunerCount      int    `json:"TunerCount"`
+}
+
+type LineupStatus struct {
+	ScanInProgress int      `json:"ScanInProgress"`
+	ScanPossible   int      `json:"ScanPossible"`
+	Source         string   `json:"Source"`
+	SourceList     []string `json:"SourceList"`
+}
+
+type Description struct {
+	XMLName     xml.Name          `xml:"urn:schemas-upnp-org:device-1-0 root"`
+	SpecVersion SpecVersion       `xml:"specVersion"`
+	URLBase     string            `xml:"URLBase"`
+	Device      DescriptionDevice `xml:"device"`
+}
+
+type SpecVersion struct {
+	Major int `xml:"major"`
+	Minor int `xml:"minor"`
+}
+
+type DescriptionDevice struct {
+	DeviceType   string `xml:"deviceType"`
+	FriendlyName string `xml:"friendlyName"`
+	Manufacturer string `xml:"manufacturer"`
+	ModelName    string `xml:"modelName"`
+	ModelNumber  string `xml:"modelNumber"`
+	SerialNumber string `xml:"serialNumber"`
+	UDN          string `xml:"UDN"`
+}
+
+// DeviceID derives a stable device ID from seed, so that media servers recognize the tuner across restarts.
+func DeviceID(seed string) string {
+	sum := sha256.Sum256([]byte(seed))
+	return strings.ToUpper(hex.EncodeToString(sum[:4]))
+}
+
+func (d Device) UUID() string {
+	sum := sha256.Sum256([]byte(d.ID))
+	return fmt.Sprintf("%x-%x-%x-%x-%x", sum[0:4], sum[4:6], sum[6:8], sum[8:10], sum[10:16])
+}
+
+func (d Device) Discover() Discover {
+	return Discover{
+		FriendlyName:    d.FriendlyName,
+		Manufacturer:    Manufacturer,
+		ModelNumber:     ModelNumber,
+		FirmwareName:    FirmwareName,
+		FirmwareVersion: FirmwareVersion,
+		DeviceID:        d.ID,
+		DeviceAuth:      DeviceAuth,
+		BaseURL:         d.BaseURL,
+		LineupURL:       d.BaseURL + "/lineup.json",
+		TunerCount:      d.TunerCount,
+	}
+}
+
+func (d Device) LineupStatus() LineupStatus {
+	return LineupStatus{
+		ScanInProgress: 0,
+		ScanPossible:   1,
+		Source:         "Cable",
+		SourceList:     []string{"Cable"},
+	}
+}
+
+func (d Device) Description() Description {
+	return Description{
+		SpecVersion: SpecVersion{Major: 1, Minor: 0},
+		URLBase:     d.BaseURL,
+		Device: DescriptionDevice{
+			DeviceType:   DeviceType,
+			FriendlyName: d.FriendlyName,
+			Manufacturer: Manufacturer,
+			ModelName:    ModelNumber,
+			ModelNumber:  ModelNumber,
+			SerialNumber: d.ID,
+			UDN:          "uuid:" + d.UUID(),
+		},
+	}
+}
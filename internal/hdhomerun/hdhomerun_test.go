@@ -0,0 +1,79 @@
+package hdhomerun
+
+import (
+	"majmun/internal/listing/m3u8/store"
+	"majmun/internal/parser/m3u8"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newTestChannel(name, number, uri string) *store.Channel {
+	track := &m3u8.Track{
+		Name:  name,
+		Attrs: map[string]string{},
+	}
+	if number != "" {
+		track.Attrs[attrChannelNumber] = number
+	}
+	if uri != "" {
+		track.URI, _ = url.Parse(uri)
+	}
+	return store.NewChannel(track, nil)
+}
+
+func TestNewLineup(t *testing.T) {
+	lineup := NewLineup([]*store.Channel{
+		newTestChannel("First", "", "http://example.com/1.ts"),
+		newTestChannel("Numbered", "101", "http://example.com/2.ts"),
+		newTestChannel("No URI", "", ""),
+		newTestChannel("Duplicate", "101", "http://example.com/3.ts"),
+		newTestChannel("Taken", "", "http://example.com/4.ts"),
+		newTestChannel("Clash", "4", "http://example.com/5.ts"),
+	})
+
+	require.Len(t, lineup, 5)
+	assert.Equal(t, LineupItem{GuideNumber: "1", GuideName: "First", URL: "http://example.com/1.ts"}, lineup[0])
+	assert.Equal(t, "101", lineup[1].GuideNumber)
+	assert.Equal(t, "3", lineup[2].GuideNumber)
+	assert.Equal(t, "4", lineup[3].GuideNumber)
+	assert.Equal(t, "5", lineup[4].GuideNumber)
+}
+
+func TestDeviceDiscover(t *testing.T) {
+	d := Device{ID: DeviceID("living-room"), FriendlyName: "Living Room", BaseURL: "http://tv.local/secret/hdhr", TunerCount: 2}
+
+	assert.Len(t, d.ID, 8)
+	assert.Equal(t, strings.ToUpper(d.ID), d.ID)
+	assert.Equal(t, d.ID, DeviceID("living-room"))
+
+	discover := d.Discover()
+	assert.Equal(t, "http://tv.local/secret/hdhr/lineup.json", discover.LineupURL)
+	assert.Equal(t, 2, discover.TunerCount)
+	assert.Equal(t, d.ID, discover.DeviceID)
+
+	desc := d.Description()
+	assert.Equal(t, d.BaseURL, desc.URLBase)
+	assert.Equal(t, "uuid:"+d.UUID(), desc.Device.UDN)
+}
+
+func TestSearchTargets(t *testing.T) {
+	d := Device{ID: "1234ABCD", BaseURL: "http://tv.local/secret/hdhr"}
+	udn := "uuid:" + d.UUID()
+
+	assert.Equal(t, []string{stRootDevice, udn, DeviceType}, d.searchTargets(stAll))
+	assert.Equal(t, []string{udn}, d.searchTargets(udn))
+	assert.Empty(t, d.searchTargets("urn:schemas-upnp-org:device:Printer:1"))
+
+	resp := string(d.searchResponse(stRootDevice))
+	assert.True(t, strings.HasPrefix(resp, "HTTP/1.1 200 OK\r\n"))
+	assert.Contains(t, resp, "LOCATION: http://tv.local/secret/hdhr/device.xml\r\n")
+	assert.Contains(t, resp, "USN: "+udn+"::"+stRootDevice+"\r\n")
+
+	bye := string(d.notifyMessage(udn, "ssdp:byebye"))
+	assert.Contains(t, bye, "USN: "+udn+"\r\n")
+	assert.NotContains(t, bye, "LOCATION")
+}
@@ -0,0 +1,42 @@
+package hdhomerun
+
+import (
+	"majmun/internal/listing/m3u8/store"
+	"strconv"
+)
+
+const attrChannelNumber = "tvg-chno"
+
+type LineupItem struct {
+	GuideNumber string `json:"GuideNumber"`
+	GuideName   string `json:"GuideName"`
+	URL         string `json:"URL"`
+}
+
+func NewLineup(channels []*store.Channel) []LineupItem {
+	lineup := make([]LineupItem, 0, len(channels))
+	used := make(map[string]bool, len(channels))
+
+	for _, ch := range channels {
+		if ch.URI() == nil {
+			continue
+		}
+
+		number, _ := ch.GetAttr(attrChannelNumber)
+		if number == "" || used[number] {
+			number = strconv.Itoa(len(lineup) + 1)
+			for used[number] {
+				number += ".1"
+			}
+		}
+		used[number] = true
+
+		lineup = append(lineup, LineupItem{
+			GuideNumber: number,
+			GuideName:   ch.Name(),
+			URL:         ch.URI().String(),
+		})
+	}
+
+	return lineup
+}
@@ -0,0 +1,150 @@
+package hdhomerun
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+
+	"majmun/internal/logging"
+)
+
+const (
+	ssdpAddr        = "239.255.255.250:1900"
+	ssdpMaxAge      = 1800
+	ssdpNotifyEvery = 5 * time.Minute
+	ssdpServer      = "majmun UPnP/1.0 HDHomeRun/1.0"
+
+	stAll        = "ssdp:all"
+	stRootDevice = "upnp:rootdevice"
+)
+
+// Responder announces devices over SSDP and answers M-SEARCH requests, so media servers
+// can discover the emulated tuners on the local network.
+type Responder struct {
+	devices func() []Device
+	addr    *net.UDPAddr
+}
+
+// NewResponder creates a responder. The devices function is called for every announcement,
+// so the returned list may change between calls.
+func NewResponder(devices func() []Device) *Responder {
+	addr, _ := net.ResolveUDPAddr("udp4", ssdpAddr)
+	return &Responder{devices: devices, addr: addr}
+}
+
+func (r *Responder) Run(ctx context.Context) error {
+	conn, err := net.ListenMulticastUDP("udp4", nil, r.addr)
+	if err != nil {
+		return fmt.Errorf("failed to listen for ssdp: %w", err)
+	}
+	defer conn.Close()
+
+	go func() {
+		<-ctx.Done()
+		r.notify(conn, "ssdp:byebye")
+		_ = conn.Close()
+	}()
+
+	go func() {
+		ticker := time.NewTicker(ssdpNotifyEvery)
+		defer ticker.Stop()
+
+		r.notify(conn, "ssdp:alive")
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				r.notify(conn, "ssdp:alive")
+			}
+		}
+	}()
+
+	buf := make([]byte, 2048)
+	for {
+		n, src, err := conn.ReadFromUDP(buf)
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			return fmt.Errorf("failed to read ssdp request: %w", err)
+		}
+
+		req, err := http.ReadRequest(bufio.NewReader(bytes.NewReader(buf[:n])))
+		if err != nil || req.Method != "M-SEARCH" || req.Header.Get("MAN") != `"ssdp:discover"` {
+			continue
+		}
+
+		st := req.Header.Get("ST")
+		for _, d := range r.devices() {
+			for _, target := range d.searchTargets(st) {
+				if _, err := conn.WriteToUDP(d.searchResponse(target), src); err != nil {
+					logging.Debug(ctx, "failed to send ssdp response", "error", err, "address", src.String())
+				}
+			}
+		}
+	}
+}
+
+func (r *Responder) notify(conn *net.UDPConn, nts string) {
+	for _, d := range r.devices() {
+		for _, target := range d.searchTargets(stAll) {
+			_, _ = conn.WriteToUDP(d.notifyMessage(target, nts), r.addr)
+		}
+	}
+}
+
+func (d Device) searchTargets(st string) []string {
+	udn := "uuid:" + d.UUID()
+
+	switch st {
+	case stAll:
+		return []string{stRootDevice, udn, DeviceType}
+	case stRootDevice, udn, DeviceType:
+		return []string{st}
+	default:
+		return nil
+	}
+}
+
+func (d Device) usn(target string) string {
+	udn := "uuid:" + d.UUID()
+	if target == udn {
+		return udn
+	}
+	return udn + "::" + target
+}
+
+func (d Device) searchResponse(target string) []byte {
+	var b strings.Builder
+	b.WriteString("HTTP/1.1 200 OK\r\n")
+	fmt.Fprintf(&b, "CACHE-CONTROL: max-age=%d\r\n", ssdpMaxAge)
+	b.WriteString("EXT:\r\n")
+	fmt.Fprintf(&b, "LOCATION: %s/device.xml\r\n", d.BaseURL)
+	fmt.Fprintf(&b, "SERVER: %s\r\n", ssdpServer)
+	fmt.Fprintf(&b, "ST: %s\r\n", target)
+	fmt.Fprintf(&b, "USN: %s\r\n", d.usn(target))
+	b.WriteString("\r\n")
+	return []byte(b.String())
+}
+
+func (d Device) notifyMessage(target, nts string) []byte {
+	var b strings.Builder
+	b.WriteString("NOTIFY * HTTP/1.1\r\n")
+	fmt.Fprintf(&b, "HOST: %s\r\n", ssdpAddr)
+	if nts != "ssdp:byebye" {
+		fmt.Fprintf(&b, "CACHE-CONTROL: max-age=%d\r\n", ssdpMaxAge)
+		fmt.Fprintf(&b, "LOCATION: %s/device.xml\r\n", d.BaseURL)
+		fmt.Fprintf(&b, "SERVER: %s\r\n", ssdpServer)
+	}
+	fmt.Fprintf(&b, "NT: %s\r\n", target)
+	fmt.Fprintf(&b, "NTS: %s\r\n", nts)
+	fmt.Fprintf(&b, "USN: %s\r\n", d.usn(target))
+	b.WriteString("\r\n")
+	return []byte(b.String())
+}
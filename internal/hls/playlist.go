@@ -0,0 +1,204 @@
+package hls
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var ErrUnsupported = errors.New("unsupported hls playlist")
+
+type variant struct {
+	uri       string
+	bandwidth int
+	width     int
+	height    int
+}
+
+type segmentKey struct {
+	uri string
+	iv  []byte
+}
+
+type mediaSegment struct {
+	uri      string
+	sequence uint64
+	duration time.Duration
+	key      *segmentKey
+}
+
+// playlist is either a master playlist with variants or a media playlist with segments.
+type playlist struct {
+	variants       []variant
+	targetDuration time.Duration
+	segments       []mediaSegment
+	endList        bool
+}
+
+func (p *playlist) lastSequence() (uint64, bool) {
+	if len(p.segments) == 0 {
+		return 0, false
+	}
+	return p.segments[len(p.segments)-1].sequence, true
+}
+
+func parsePlaylist(data []byte, base *url.URL) (*playlist, error) {
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+
+	pl := &playlist{}
+	var header bool
+	var sequence uint64
+	var duration time.Duration
+	var key *segmentKey
+	var pending *variant
+
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+		if !header {
+			if line != "#EXTM3U" {
+				return nil, fmt.Errorf("%w: missing #EXTM3U header", ErrUnsupported)
+			}
+			header = true
+			continue
+		}
+
+		tag, value, _ := strings.Cut(line, ":")
+		switch tag {
+		case "#EXT-X-STREAM-INF":
+			attrs := parseAttributes(value)
+			pending = &variant{}
+			pending.bandwidth, _ = strconv.Atoi(attrs["BANDWIDTH"])
+			if w, h, ok := strings.Cut(attrs["RESOLUTION"], "x"); ok {
+				pending.width, _ = strconv.Atoi(w)
+				pending.height, _ = strconv.Atoi(h)
+			}
+		case "#EXT-X-TARGETDURATION":
+			seconds, _ := strconv.Atoi(value)
+			pl.targetDuration = time.Duration(seconds) * time.Second
+		case "#EXT-X-MEDIA-SEQUENCE":
+			sequence, _ = strconv.ParseUint(value, 10, 64)
+		case "#EXTINF":
+			seconds, _, _ := strings.Cut(value, ",")
+			f, _ := strconv.ParseFloat(seconds, 64)
+			duration = time.Duration(f * float64(time.Second))
+		case "#EXT-X-KEY":
+			var err error
+			if key, err = parseKey(value, base); err != nil {
+				return nil, err
+			}
+		case "#EXT-X-MAP":
+			return nil, fmt.Errorf("%w: fragmented MP4 segments", ErrUnsupported)
+		case "#EXT-X-ENDLIST":
+			pl.endList = true
+		default:
+			if strings.HasPrefix(line, "#") {
+				continue
+			}
+
+			uri, err := resolveURI(base, line)
+			if err != nil {
+				return nil, err
+			}
+
+			if pending != nil {
+				pending.uri = uri
+				pl.variants = append(pl.variants, *pending)
+				pending = nil
+				continue
+			}
+
+			pl.segments = append(pl.segments, mediaSegment{
+				uri:      uri,
+				sequence: sequence,
+				duration: duration,
+				key:      key,
+			})
+			sequence++
+			duration = 0
+		}
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	if !header {
+		return nil, fmt.Errorf("%w: empty playlist", ErrUnsupported)
+	}
+
+	return pl, nil
+}
+
+func parseKey(value string, base *url.URL) (*segmentKey, error) {
+	attrs := parseAttributes(value)
+
+	switch attrs["METHOD"] {
+	case "NONE":
+		return nil, nil
+	case "AES-128":
+	default:
+		return nil, fmt.Errorf("%w: encryption method %s", ErrUnsupported, attrs["METHOD"])
+	}
+
+	uri, err := resolveURI(base, attrs["URI"])
+	if err != nil {
+		return nil, err
+	}
+
+	key := &segmentKey{uri: uri}
+	if iv := attrs["IV"]; iv != "" {
+		iv = strings.TrimPrefix(strings.TrimPrefix(iv, "0x"), "0X")
+		if key.iv, err = hex.DecodeString(iv); err != nil || len(key.iv) != 16 {
+			return nil, fmt.Errorf("invalid key IV: %s", attrs["IV"])
+		}
+	}
+
+	return key, nil
+}
+
+// parseAttributes parses an attribute list like BANDWIDTH=1280000,CODECS="avc1,mp4a".
+func parseAttributes(s string) map[string]string {
+	attrs := make(map[string]string)
+
+	for s != "" {
+		name, rest, ok := strings.Cut(s, "=")
+		if !ok {
+			break
+		}
+
+		var value string
+		if strings.HasPrefix(rest, `"`) {
+			end := strings.Index(rest[1:], `"`)
+			if end < 0 {
+				value, rest = rest[1:], ""
+			} else {
+				value, rest = rest[1:end+1], rest[end+2:]
+			}
+			rest = strings.TrimPrefix(rest, ",")
+		} else {
+			value, rest, _ = strings.Cut(rest, ",")
+		}
+
+		attrs[strings.TrimSpace(name)] = value
+		s = rest
+	}
+
+	return attrs
+}
+
+func resolveURI(base *url.URL, ref string) (string, error) {
+	u, err := url.Parse(ref)
+	if err != nil {
+		return "", fmt.Errorf("invalid uri %q: %w", ref, err)
+	}
+	return base.ResolveReference(u).String(), nil
+}
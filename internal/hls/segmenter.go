@@ -0,0 +1,284 @@
+package hls
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"majmun/internal/mpegts"
+	"math"
+	"sync"
+	"time"
+)
+
+const (
+	// segments kept in memory after they leave the playlist, for clients that lag behind
+	extraSegments = 2
+
+	// a segment is cut without a keyframe once it grows this many times over the target duration
+	maxDurationFactor = 3
+)
+
+var ErrClosed = errors.New("segmenter closed")
+
+type Segment struct {
+	Sequence uint64
+	Duration time.Duration
+	Data     []byte
+}
+
+type Segmenter struct {
+	targetDuration time.Duration
+	playlistSize   int
+
+	mu       sync.Mutex
+	updated  chan struct{}
+	segments []*Segment
+	nextSeq  uint64
+	closed   bool
+	err      error
+
+	tracker   mpegts.Tracker
+	partial   []byte
+	current   []byte
+	startPTS  int64
+	hasPTS    bool
+	lastPTS   int64
+	startTime time.Time
+	sizeHint  int
+}
+
+func NewSegmenter(targetDuration time.Duration, playlistSize int) *Segmenter {
+	return &Segmenter{
+		targetDuration: targetDuration,
+		playlistSize:   playlistSize,
+		updated:        make(chan struct{}),
+	}
+}
+
+func (s *Segmenter) TargetDuration() time.Duration {
+	return s.targetDuration
+}
+
+func (s *Segmenter) Write(p []byte) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.closed {
+		return 0, ErrClosed
+	}
+
+	data := p
+	if len(s.partial) > 0 {
+		s.partial = append(s.partial, p...)
+		data = s.partial
+	}
+
+	for len(data) >= mpegts.PacketSize {
+		if data[0] != mpegts.SyncByte {
+			data = data[1:]
+			continue
+		}
+		s.writePacket(data[:mpegts.PacketSize])
+		data = data[mpegts.PacketSize:]
+	}
+
+	s.partial = append(s.partial[:0], data...)
+	return len(p), nil
+}
+
+func (s *Segmenter) writePacket(p []byte) {
+	if !mpegts.IsValid(p) {
+		return
+	}
+
+	s.tracker.Observe(p)
+
+	timingPID, known := s.tracker.TimingPID()
+	if !known {
+		return
+	}
+	isTiming := mpegts.PID(p) == timingPID
+
+	var pts int64
+	var hasPTS bool
+	if isTiming {
+		pts, hasPTS = mpegts.PTS(p)
+	}
+
+	if len(s.current) == 0 {
+		if !s.isCutPoint(p, isTiming, false) {
+			return
+		}
+		s.startSegment(pts, hasPTS)
+	} else if isTiming && s.shouldCut(p, pts, hasPTS) {
+		s.finishSegment(pts, hasPTS)
+		s.startSegment(pts, hasPTS)
+	}
+
+	if hasPTS {
+		s.lastPTS = pts
+	}
+
+	if s.tracker.IsTable(p) {
+		return
+	}
+	s.current = append(s.current, p...)
+}
+
+func (s *Segmenter) isCutPoint(p []byte, isTiming, force bool) bool {
+	if !isTiming || !mpegts.PayloadUnitStart(p) {
+		return false
+	}
+	if !s.tracker.HasVideo() || force {
+		return true
+	}
+	return mpegts.RandomAccess(p)
+}
+
+func (s *Segmenter) shouldCut(p []byte, pts int64, hasPTS bool) bool {
+	elapsed := s.elapsed(pts, hasPTS)
+	if elapsed < s.targetDuration {
+		return false
+	}
+	return s.isCutPoint(p, true, elapsed >= maxDurationFactor*s.targetDuration)
+}
+
+func (s *Segmenter) elapsed(pts int64, hasPTS bool) time.Duration {
+	if hasPTS && s.hasPTS {
+		return time.Duration(mpegts.PTSDiff(s.startPTS, pts) * float64(time.Second))
+	}
+	return time.Since(s.startTime)
+}
+
+func (s *Segmenter) startSegment(pts int64, hasPTS bool) {
+	s.startPTS, s.hasPTS = pts, hasPTS
+	s.startTime = time.Now()
+
+	s.current = make([]byte, 0, s.sizeHint)
+	for _, table := range s.tracker.Tables() {
+		s.current = append(s.current, table...)
+	}
+}
+
+func (s *Segmenter) finishSegment(pts int64, hasPTS bool) {
+	if len(s.current) == 0 {
+		return
+	}
+
+	duration := s.elapsed(pts, hasPTS)
+	if duration <= 0 {
+		duration = time.Since(s.startTime)
+	}
+
+	s.segments = append(s.segments, &Segment{
+		Sequence: s.nextSeq,
+		Duration: duration,
+		Data:     s.current,
+	})
+	s.nextSeq++
+	s.sizeHint = len(s.current)
+	s.current = nil
+
+	if limit := s.playlistSize + extraSegments; len(s.segments) > limit {
+		s.segments = append(s.segments[:0:0], s.segments[len(s.segments)-limit:]...)
+	}
+
+	close(s.updated)
+	s.updated = make(chan struct{})
+}
+
+// Close flushes the segment in progress and ends the playlist.
+func (s *Segmenter) Close(err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.closed {
+		return
+	}
+
+	s.finishSegment(s.lastPTS, s.hasPTS)
+	s.closed = true
+	s.err = err
+	close(s.updated)
+}
+
+func (s *Segmenter) Err() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.err
+}
+
+func (s *Segmenter) Segment(seq uint64) (*Segment, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, seg := range s.segments {
+		if seg.Sequence == seq {
+			return seg, true
+		}
+	}
+	return nil, false
+}
+
+// Wait blocks until the playlist holds at least n segments or the segmenter is closed.
+func (s *Segmenter) Wait(ctx context.Context, n int) error {
+	for {
+		s.mu.Lock()
+		ready := len(s.segments) >= n
+		closed, empty, updated := s.closed, len(s.segments) == 0, s.updated
+		s.mu.Unlock()
+
+		if ready || (closed && !empty) {
+			return nil
+		}
+		if closed {
+			return ErrClosed
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-updated:
+		}
+	}
+}
+
+func (s *Segmenter) WritePlaylist(w io.Writer, segmentURI func(seq uint64) string) error {
+	s.mu.Lock()
+	segments := s.segments
+	if len(segments) > s.playlistSize {
+		segments = segments[len(segments)-s.playlistSize:]
+	}
+	closed := s.closed
+	s.mu.Unlock()
+
+	targetDuration := s.targetDuration
+	for _, seg := range segments {
+		targetDuration = max(targetDuration, seg.Duration)
+	}
+
+	var firstSeq uint64
+	if len(segments) > 0 {
+		firstSeq = segments[0].Sequence
+	}
+
+	if _, err := fmt.Fprintf(w, "#EXTM3U\n#EXT-X-VERSION:3\n#EXT-X-TARGETDURATION:%d\n#EXT-X-MEDIA-SEQUENCE:%d\n",
+		int(math.Ceil(targetDuration.Seconds())), firstSeq); err != nil {
+		return err
+	}
+
+	for _, seg := range segments {
+		if _, err := fmt.Fprintf(w, "#EXTINF:%.3f,\n%s\n", seg.Duration.Seconds(), segmentURI(seg.Sequence)); err != nil {
+			return err
+		}
+	}
+
+	if closed {
+		if _, err := io.WriteString(w, "#EXT-X-ENDLIST\n"); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,234 @@
+package hls
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"majmun/internal/mpegts"
+	"strings"
+	"testing"
+	"time"
+)
+
+const (
+	testPMTPID   = 0x1000
+	testVideoPID = 0x100
+	testAudioPID = 0x101
+)
+
+func newPacket(pid uint16, pusi bool) []byte {
+	p := bytes.Repeat([]byte{0xff}, mpegts.PacketSize)
+	p[0] = mpegts.SyncByte
+	p[1] = byte(pid>>8) & 0x1f
+	if pusi {
+		p[1] |= 0x40
+	}
+	p[2] = byte(pid)
+	p[3] = 0x10
+	return p
+}
+
+func newPESPacket(pid uint16, pts int64, keyframe bool) []byte {
+	p := newPacket(pid, true)
+	offset := 4
+	if keyframe {
+		p[3], p[4], p[5] = 0x30, 1, 0x40
+		offset = 6
+	}
+
+	pes := p[offset:]
+	copy(pes, []byte{0, 0, 1, 0xe0, 0, 0, 0x80, 0x80, 5})
+	pes[9] = byte(0x21 | (pts>>29)&0x0e)
+	pes[10] = byte(pts >> 22)
+	pes[11] = byte(0x01 | (pts>>14)&0xfe)
+	pes[12] = byte(pts >> 7)
+	pes[13] = byte(0x01 | (pts<<1)&0xfe)
+	return p
+}
+
+func newTables(streamTypes ...byte) [][]byte {
+	pat := newPacket(mpegts.PATPID, true)
+	copy(pat[4:], []byte{0, 0x00, 0xb0, 13, 0, 1, 0xc1, 0, 0, 0, 1, 0xe0 | testPMTPID>>8, testPMTPID & 0xff, 0, 0, 0, 0})
+
+	pmt := newPacket(testPMTPID, true)
+	section := []byte{0, 0x02, 0xb0, 0, 0, 1, 0xc1, 0, 0, 0xe1, 0x00, 0xf0, 0}
+	for i, st := range streamTypes {
+		pid := testVideoPID + uint16(i)
+		section = append(section, st, byte(0xe0|pid>>8), byte(pid), 0xf0, 0)
+	}
+	section = append(section, 0, 0, 0, 0)
+	section[3] = byte(len(section) - 4)
+	copy(pmt[4:], section)
+
+	return [][]byte{pat, pmt}
+}
+
+// buildStream produces a stream with one video frame every 40ms and a keyframe every keyframeInterval frames.
+func buildStream(frames, keyframeInterval int) []byte {
+	var buf bytes.Buffer
+	for _, table := range newTables(0x1b, 0x0f) {
+		buf.Write(table)
+	}
+
+	for i := 0; i < frames; i++ {
+		if i%25 == 0 {
+			for _, table := range newTables(0x1b, 0x0f) {
+				buf.Write(table)
+			}
+		}
+		buf.Write(newPESPacket(testVideoPID, int64(i)*3600, i%keyframeInterval == 0))
+		buf.Write(newPacket(testVideoPID, false))
+		buf.Write(newPESPacket(testAudioPID, int64(i)*3600, false))
+	}
+	return buf.Bytes()
+}
+
+func segmentURI(seq uint64) string {
+	return fmt.Sprintf("%d.ts", seq)
+}
+
+func TestSegmenterCutsOnKeyframes(t *testing.T) {
+	s := NewSegmenter(2*time.Second, 3)
+
+	// 10 seconds of video with a keyframe every second
+	if _, err := s.Write(buildStream(250, 25)); err != nil {
+		t.Fatalf("Write() error: %v", err)
+	}
+	s.Close(nil)
+
+	var playlist strings.Builder
+	if err := s.WritePlaylist(&playlist, segmentURI); err != nil {
+		t.Fatalf("WritePlaylist() error: %v", err)
+	}
+
+	expected := "#EXTM3U\n" +
+		"#EXT-X-VERSION:3\n" +
+		"#EXT-X-TARGETDURATION:2\n" +
+		"#EXT-X-MEDIA-SEQUENCE:2\n" +
+		"#EXTINF:2.000,\n2.ts\n" +
+		"#EXTINF:2.000,\n3.ts\n" +
+		"#EXTINF:1.960,\n4.ts\n" +
+		"#EXT-X-ENDLIST\n"
+	if playlist.String() != expected {
+		t.Errorf("WritePlaylist() =\n%s\nwant\n%s", playlist.String(), expected)
+	}
+
+	for seq := uint64(0); seq < 5; seq++ {
+		seg, ok := s.Segment(seq)
+		if !ok {
+			t.Fatalf("Segment(%d) not found", seq)
+		}
+		if len(seg.Data)%mpegts.PacketSize != 0 {
+			t.Fatalf("segment %d is not packet aligned", seq)
+		}
+
+		first, second, third := seg.Data[:188], seg.Data[188:376], seg.Data[376:564]
+		if mpegts.PID(first) != mpegts.PATPID || mpegts.PID(second) != testPMTPID {
+			t.Errorf("segment %d does not start with PAT and PMT", seq)
+		}
+		if mpegts.PID(third) != testVideoPID || !mpegts.RandomAccess(third) {
+			t.Errorf("segment %d does not start with a keyframe", seq)
+		}
+
+		for i := 2 * mpegts.PacketSize; i < len(seg.Data); i += mpegts.PacketSize {
+			pid := mpegts.PID(seg.Data[i:])
+			if pid == mpegts.PATPID || pid == testPMTPID {
+				t.Errorf("segment %d contains repeated tables", seq)
+				break
+			}
+		}
+	}
+}
+
+func TestSegmenterDropsDataBeforeFirstKeyframe(t *testing.T) {
+	s := NewSegmenter(time.Second, 3)
+
+	var buf bytes.Buffer
+	for _, table := range newTables(0x1b) {
+		buf.Write(table)
+	}
+	buf.Write(newPESPacket(testVideoPID, 0, false))
+	buf.Write(newPESPacket(testVideoPID, 90000, true))
+	buf.Write(newPESPacket(testVideoPID, 180000, false))
+
+	if _, err := s.Write(buf.Bytes()); err != nil {
+		t.Fatalf("Write() error: %v", err)
+	}
+	s.Close(nil)
+
+	seg, ok := s.Segment(0)
+	if !ok {
+		t.Fatal("Segment(0) not found")
+	}
+	if len(seg.Data) != 4*mpegts.PacketSize {
+		t.Errorf("segment length = %d packets, want 4", len(seg.Data)/mpegts.PacketSize)
+	}
+	if seg.Duration != time.Second {
+		t.Errorf("segment duration = %v, want 1s", seg.Duration)
+	}
+}
+
+func TestSegmenterUnalignedWrites(t *testing.T) {
+	data := buildStream(100, 25)
+
+	aligned := NewSegmenter(time.Second, 5)
+	_, _ = aligned.Write(data)
+	aligned.Close(nil)
+
+	unaligned := NewSegmenter(time.Second, 5)
+	_, _ = unaligned.Write([]byte{0x00, 0x01})
+	for i := 0; i < len(data); i += 100 {
+		_, _ = unaligned.Write(data[i:min(i+100, len(data))])
+	}
+	unaligned.Close(nil)
+
+	for seq := uint64(0); seq < 4; seq++ {
+		a, okA := aligned.Segment(seq)
+		u, okU := unaligned.Segment(seq)
+		if !okA || !okU {
+			t.Fatalf("Segment(%d) missing: aligned=%v unaligned=%v", seq, okA, okU)
+		}
+		if !bytes.Equal(a.Data, u.Data) {
+			t.Errorf("segment %d differs between aligned and unaligned writes", seq)
+		}
+	}
+}
+
+func TestSegmenterWait(t *testing.T) {
+	s := NewSegmenter(time.Second, 3)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if err := s.Wait(ctx, 1); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Wait() error = %v, want deadline exceeded", err)
+	}
+
+	done := make(chan error, 1)
+	go func() { done <- s.Wait(context.Background(), 2) }()
+
+	_, _ = s.Write(buildStream(100, 25))
+
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Errorf("Wait() error = %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Wait() did not return after segments were written")
+	}
+
+	s.Close(errors.New("upstream failed"))
+	if _, err := s.Write([]byte{mpegts.SyncByte}); !errors.Is(err, ErrClosed) {
+		t.Errorf("Write() after Close error = %v, want %v", err, ErrClosed)
+	}
+	if s.Err() == nil {
+		t.Error("expected close error to be kept")
+	}
+
+	empty := NewSegmenter(time.Second, 3)
+	empty.Close(nil)
+	if err := empty.Wait(context.Background(), 1); !errors.Is(err, ErrClosed) {
+		t.Errorf("Wait() on empty closed segmenter error = %v, want %v", err, ErrClosed)
+	}
+}
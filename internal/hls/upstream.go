@@ -0,0 +1,316 @@
+package hls
+
+import (
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"majmun/internal/ioutil"
+	"majmun/internal/logging"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+const (
+	requestTimeout = 30 * time.Second
+	maxRetries     = 3
+	retryDelay     = 500 * time.Millisecond
+
+	// liveEdgeSegments is how many segments from the end of a live playlist the stream starts at.
+	liveEdgeSegments = 3
+
+	defaultTargetDuration = 6 * time.Second
+)
+
+var ErrNoData = fmt.Errorf("no new hls segments: %w", ioutil.ErrReaderTimeout)
+
+type VariantPolicy string
+
+const (
+	VariantHighestBandwidth  VariantPolicy = "highest_bandwidth"
+	VariantLowestBandwidth   VariantPolicy = "lowest_bandwidth"
+	VariantHighestResolution VariantPolicy = "highest_resolution"
+)
+
+// Variant selects the stream from a master playlist. Variants taller than MaxHeight are skipped when others exist.
+type Variant struct {
+	Policy    VariantPolicy
+	MaxHeight int
+}
+
+// Upstream remuxes an HLS stream with MPEG-TS segments into a continuous transport stream.
+type Upstream struct {
+	client        *http.Client
+	url           string
+	variant       Variant
+	noDataTimeout time.Duration
+}
+
+func NewUpstream(client *http.Client, url string, variant Variant, noDataTimeout time.Duration) *Upstream {
+	return &Upstream{
+		client:        client,
+		url:           url,
+		variant:       variant,
+		noDataTimeout: noDataTimeout,
+	}
+}
+
+// Stream polls the media playlist and writes its segments in order, decrypted when needed.
+// It returns when the playlist ends, the context is canceled or no new segment appears within the no data timeout.
+func (u *Upstream) Stream(ctx context.Context, w io.Writer) (int64, error) {
+	mediaURL, err := u.mediaURL(ctx)
+	if err != nil {
+		return 0, err
+	}
+
+	s := &upstreamState{keys: make(map[string][]byte), lastProgress: time.Now()}
+
+	for {
+		pl, err := u.fetchPlaylist(ctx, mediaURL)
+		if ctx.Err() != nil {
+			return s.written, nil
+		}
+		if err != nil {
+			return s.written, err
+		}
+
+		progressed, err := u.writeSegments(ctx, w, pl, s)
+		if ctx.Err() != nil || err != nil {
+			return s.written, nil
+		}
+
+		if pl.endList {
+			return s.written, nil
+		}
+
+		wait := pl.targetDuration
+		if wait <= 0 {
+			wait = defaultTargetDuration
+		}
+
+		if progressed {
+			s.lastProgress = time.Now()
+		} else {
+			if u.noDataTimeout > 0 && time.Since(s.lastProgress) > u.noDataTimeout {
+				logging.Info(ctx, "no new hls segments, stopping stream", "timeout", u.noDataTimeout)
+				return s.written, ErrNoData
+			}
+			wait /= 2
+		}
+
+		select {
+		case <-ctx.Done():
+			return s.written, nil
+		case <-time.After(wait):
+		}
+	}
+}
+
+type upstreamState struct {
+	started      bool
+	next         uint64
+	written      int64
+	keys         map[string][]byte
+	lastProgress time.Time
+}
+
+// writeSegments writes the segments not written yet. A write error means the client is gone.
+func (u *Upstream) writeSegments(ctx context.Context, w io.Writer, pl *playlist, s *upstreamState) (bool, error) {
+	last, ok := pl.lastSequence()
+	if !ok {
+		return false, nil
+	}
+
+	if !s.started || last+1 < s.next {
+		s.next = pl.segments[0].sequence
+		if !pl.endList && len(pl.segments) > liveEdgeSegments {
+			s.next = pl.segments[len(pl.segments)-liveEdgeSegments].sequence
+		}
+		s.started = true
+	}
+
+	progressed := false
+	for _, seg := range pl.segments {
+		if seg.sequence < s.next {
+			continue
+		}
+
+		data, err := u.fetchSegment(ctx, seg, s.keys)
+		if ctx.Err() != nil {
+			return progressed, nil
+		}
+		s.next = seg.sequence + 1
+		if err != nil {
+			logging.Error(ctx, err, "failed to get hls segment, skipping it", "sequence", seg.sequence)
+			continue
+		}
+
+		if _, err := w.Write(data); err != nil {
+			return progressed, err
+		}
+		s.written += int64(len(data))
+		progressed = true
+	}
+
+	return progressed, nil
+}
+
+func (u *Upstream) mediaURL(ctx context.Context) (string, error) {
+	pl, err := u.fetchPlaylist(ctx, u.url)
+	if err != nil {
+		return "", err
+	}
+	if len(pl.variants) == 0 {
+		return u.url, nil
+	}
+
+	v := selectVariant(pl.variants, u.variant)
+	logging.Debug(ctx, "selected hls variant", "bandwidth", v.bandwidth, "height", v.height)
+	return v.uri, nil
+}
+
+func (u *Upstream) fetchPlaylist(ctx context.Context, playlistURL string) (*playlist, error) {
+	base, err := url.Parse(playlistURL)
+	if err != nil {
+		return nil, err
+	}
+
+	data, err := u.get(ctx, playlistURL)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get hls playlist: %w", err)
+	}
+
+	return parsePlaylist(data, base)
+}
+
+func (u *Upstream) fetchSegment(ctx context.Context, seg mediaSegment, keys map[string][]byte) ([]byte, error) {
+	data, err := u.get(ctx, seg.uri)
+	if err != nil || seg.key == nil {
+		return data, err
+	}
+
+	key, ok := keys[seg.key.uri]
+	if !ok {
+		if key, err = u.get(ctx, seg.key.uri); err != nil {
+			return nil, fmt.Errorf("failed to get key: %w", err)
+		}
+		if len(key) != aes.BlockSize {
+			return nil, fmt.Errorf("invalid key length %d", len(key))
+		}
+		keys[seg.key.uri] = key
+	}
+
+	iv := seg.key.iv
+	if iv == nil {
+		iv = make([]byte, aes.BlockSize)
+		binary.BigEndian.PutUint64(iv[8:], seg.sequence)
+	}
+
+	return decryptSegment(data, key, iv)
+}
+
+// get downloads a playlist, key or segment, retrying failed requests.
+func (u *Upstream) get(ctx context.Context, rawURL string) ([]byte, error) {
+	var err error
+	for attempt := 0; attempt <= maxRetries; attempt++ {
+		if attempt > 0 {
+			select {
+			case <-ctx.Done():
+				return nil, ctx.Err()
+			case <-time.After(time.Duration(attempt) * retryDelay):
+			}
+		}
+
+		var data []byte
+		if data, err = u.getOnce(ctx, rawURL); err == nil {
+			return data, nil
+		}
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		logging.Debug(ctx, "hls request failed", "url", logging.SanitizeURL(rawURL), "attempt", attempt+1, "error", err)
+	}
+
+	return nil, err
+}
+
+func (u *Upstream) getOnce(ctx context.Context, rawURL string) ([]byte, error) {
+	ctx, cancel := context.WithTimeout(ctx, requestTimeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, rawURL, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := u.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = resp.Body.Close() }()
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return nil, fmt.Errorf("unexpected status %d", resp.StatusCode)
+	}
+
+	return io.ReadAll(resp.Body)
+}
+
+func decryptSegment(data, key, iv []byte) ([]byte, error) {
+	if len(data) == 0 || len(data)%aes.BlockSize != 0 {
+		return nil, errors.New("encrypted segment is not a multiple of the block size")
+	}
+
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	cipher.NewCBCDecrypter(block, iv).CryptBlocks(data, data)
+
+	padding := int(data[len(data)-1])
+	if padding == 0 || padding > aes.BlockSize || padding > len(data) {
+		return nil, errors.New("invalid segment padding")
+	}
+	return data[:len(data)-padding], nil
+}
+
+func selectVariant(variants []variant, sel Variant) variant {
+	candidates := variants
+	if sel.MaxHeight > 0 {
+		var fitting []variant
+		for _, v := range variants {
+			if v.height <= sel.MaxHeight {
+				fitting = append(fitting, v)
+			}
+		}
+		if len(fitting) > 0 {
+			candidates = fitting
+		}
+	}
+
+	best := candidates[0]
+	for _, v := range candidates[1:] {
+		if better(v, best, sel.Policy) {
+			best = v
+		}
+	}
+	return best
+}
+
+func better(a, b variant, policy VariantPolicy) bool {
+	switch policy {
+	case VariantLowestBandwidth:
+		return a.bandwidth < b.bandwidth
+	case VariantHighestResolution:
+		if a.width*a.height != b.width*b.height {
+			return a.width*a.height > b.width*b.height
+		}
+		return a.bandwidth > b.bandwidth
+	default:
+		return a.bandwidth > b.bandwidth
+	}
+}
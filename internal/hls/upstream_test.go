@@ -0,0 +1,219 @@
+package hls
+
+import (
+	"bytes"
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+var testKey = []byte("0123456789abcdef")
+
+func encryptSegment(t *testing.T, data []byte, iv []byte) []byte {
+	t.Helper()
+
+	padding := aes.BlockSize - len(data)%aes.BlockSize
+	data = append(bytes.Clone(data), bytes.Repeat([]byte{byte(padding)}, padding)...)
+
+	block, err := aes.NewCipher(testKey)
+	if err != nil {
+		t.Fatalf("NewCipher() error: %v", err)
+	}
+	cipher.NewCBCEncrypter(block, iv).CryptBlocks(data, data)
+	return data
+}
+
+func TestParsePlaylist(t *testing.T) {
+	base, _ := url.Parse("http://example.com/live/index.m3u8?token=1")
+
+	master := "#EXTM3U\n" +
+		"#EXT-X-STREAM-INF:BANDWIDTH=800000,RESOLUTION=640x360,CODECS=\"avc1.4d401e,mp4a.40.2\"\n" +
+		"low/index.m3u8\n" +
+		"#EXT-X-STREAM-INF:BANDWIDTH=2500000,RESOLUTION=1280x720\n" +
+		"http://cdn.example.com/high.m3u8\n"
+
+	pl, err := parsePlaylist([]byte(master), base)
+	if err != nil {
+		t.Fatalf("parsePlaylist() error: %v", err)
+	}
+	expected := []variant{
+		{uri: "http://example.com/live/low/index.m3u8", bandwidth: 800000, width: 640, height: 360},
+		{uri: "http://cdn.example.com/high.m3u8", bandwidth: 2500000, width: 1280, height: 720},
+	}
+	if fmt.Sprint(pl.variants) != fmt.Sprint(expected) {
+		t.Errorf("variants = %v, want %v", pl.variants, expected)
+	}
+
+	media := "#EXTM3U\n" +
+		"#EXT-X-TARGETDURATION:4\n" +
+		"#EXT-X-MEDIA-SEQUENCE:10\n" +
+		"#EXTINF:4.0,\n10.ts\n" +
+		"#EXT-X-KEY:METHOD=AES-128,URI=\"key.bin\",IV=0x000102030405060708090a0b0c0d0e0f\n" +
+		"#EXTINF:4.0,\n11.ts\n" +
+		"#EXT-X-KEY:METHOD=NONE\n" +
+		"#EXTINF:3.5,\n12.ts\n" +
+		"#EXT-X-ENDLIST\n"
+
+	pl, err = parsePlaylist([]byte(media), base)
+	if err != nil {
+		t.Fatalf("parsePlaylist() error: %v", err)
+	}
+	if pl.targetDuration != 4*time.Second || !pl.endList || len(pl.segments) != 3 {
+		t.Fatalf("unexpected playlist: %+v", pl)
+	}
+	if last, _ := pl.lastSequence(); last != 12 {
+		t.Errorf("lastSequence() = %d, want 12", last)
+	}
+
+	if pl.segments[0].key != nil || pl.segments[2].key != nil {
+		t.Errorf("unexpected key on unencrypted segment")
+	}
+	key := pl.segments[1].key
+	if key == nil || key.uri != "http://example.com/live/key.bin" || len(key.iv) != aes.BlockSize || key.iv[15] != 0x0f {
+		t.Errorf("unexpected key: %+v", key)
+	}
+
+	_, err = parsePlaylist([]byte("#EXTM3U\n#EXT-X-MAP:URI=\"init.mp4\"\n#EXTINF:4.0,\n0.m4s\n"), base)
+	if err == nil {
+		t.Errorf("parsePlaylist() expected error for fMP4 playlist")
+	}
+}
+
+func TestSelectVariant(t *testing.T) {
+	variants := []variant{
+		{uri: "sd", bandwidth: 1000, width: 640, height: 360},
+		{uri: "fhd", bandwidth: 3000, width: 1920, height: 1080},
+		{uri: "hd-high", bandwidth: 5000, width: 1280, height: 720},
+	}
+
+	tests := []struct {
+		variant  Variant
+		expected string
+	}{
+		{Variant{}, "hd-high"},
+		{Variant{Policy: VariantLowestBandwidth}, "sd"},
+		{Variant{Policy: VariantHighestResolution}, "fhd"},
+		{Variant{Policy: VariantHighestResolution, MaxHeight: 720}, "hd-high"},
+		{Variant{Policy: VariantHighestResolution, MaxHeight: 240}, "fhd"},
+	}
+
+	for _, tt := range tests {
+		if got := selectVariant(variants, tt.variant); got.uri != tt.expected {
+			t.Errorf("selectVariant(%+v) = %s, want %s", tt.variant, got.uri, tt.expected)
+		}
+	}
+}
+
+func TestUpstreamStreamsSegments(t *testing.T) {
+	var failures atomic.Int32
+
+	iv := make([]byte, aes.BlockSize)
+	iv[15] = 1
+	encrypted := encryptSegment(t, []byte("second-"), iv)
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/master.m3u8", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, "#EXTM3U\n"+
+			"#EXT-X-STREAM-INF:BANDWIDTH=500000,RESOLUTION=640x360\nlow.m3u8\n"+
+			"#EXT-X-STREAM-INF:BANDWIDTH=3000000,RESOLUTION=1920x1080\nhigh.m3u8\n")
+	})
+	mux.HandleFunc("/high.m3u8", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, "#EXTM3U\n#EXT-X-TARGETDURATION:1\n#EXT-X-MEDIA-SEQUENCE:0\n"+
+			"#EXTINF:1.0,\n0.ts\n"+
+			"#EXT-X-KEY:METHOD=AES-128,URI=\"key.bin\"\n#EXTINF:1.0,\n1.ts\n"+
+			"#EXT-X-KEY:METHOD=NONE\n#EXTINF:1.0,\n2.ts\n"+
+			"#EXT-X-ENDLIST\n")
+	})
+	mux.HandleFunc("/key.bin", func(w http.ResponseWriter, r *http.Request) { _, _ = w.Write(testKey) })
+	mux.HandleFunc("/0.ts", func(w http.ResponseWriter, r *http.Request) { fmt.Fprint(w, "first-") })
+	mux.HandleFunc("/1.ts", func(w http.ResponseWriter, r *http.Request) { _, _ = w.Write(encrypted) })
+	mux.HandleFunc("/2.ts", func(w http.ResponseWriter, r *http.Request) {
+		if failures.Add(1) == 1 {
+			http.Error(w, "busy", http.StatusServiceUnavailable)
+			return
+		}
+		fmt.Fprint(w, "third")
+	})
+
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	var out bytes.Buffer
+	u := NewUpstream(server.Client(), server.URL+"/master.m3u8", Variant{}, time.Second)
+	n, err := u.Stream(context.Background(), &out)
+	if err != nil {
+		t.Fatalf("Stream() error: %v", err)
+	}
+
+	if out.String() != "first-second-third" {
+		t.Errorf("Stream() wrote %q, want %q", out.String(), "first-second-third")
+	}
+	if n != int64(out.Len()) {
+		t.Errorf("Stream() = %d, want %d", n, out.Len())
+	}
+	if failures.Load() != 2 {
+		t.Errorf("segment requested %d times, want 2", failures.Load())
+	}
+}
+
+func TestUpstreamStartsAtLiveEdge(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/live.m3u8" {
+			fmt.Fprint(w, r.URL.Path[1:2])
+			return
+		}
+		fmt.Fprint(w, "#EXTM3U\n#EXT-X-TARGETDURATION:1\n#EXT-X-MEDIA-SEQUENCE:0\n")
+		for i := range 6 {
+			fmt.Fprintf(w, "#EXTINF:1.0,\n%d.ts\n", i)
+		}
+	}))
+	defer server.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	out := &cancelWriter{expected: "345", cancel: cancel}
+	u := NewUpstream(server.Client(), server.URL+"/live.m3u8", Variant{}, time.Second)
+	if _, err := u.Stream(ctx, out); err != nil {
+		t.Fatalf("Stream() error: %v", err)
+	}
+
+	if out.String() != "345" {
+		t.Errorf("Stream() wrote %q, want %q", out.String(), "345")
+	}
+}
+
+func TestUpstreamNoData(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, "#EXTM3U\n#EXT-X-TARGETDURATION:1\n#EXT-X-MEDIA-SEQUENCE:0\n#EXTINF:1.0,\n0.ts\n")
+	}))
+	defer server.Close()
+
+	u := NewUpstream(server.Client(), server.URL+"/live.m3u8", Variant{}, 500*time.Millisecond)
+	if _, err := u.Stream(context.Background(), &bytes.Buffer{}); !errors.Is(err, ErrNoData) {
+		t.Errorf("Stream() error = %v, want %v", err, ErrNoData)
+	}
+}
+
+// cancelWriter cancels the stream once it received the expected data.
+type cancelWriter struct {
+	bytes.Buffer
+	expected string
+	cancel   context.CancelFunc
+}
+
+func (w *cancelWriter) Write(p []byte) (int, error) {
+	n, err := w.Buffer.Write(p)
+	if w.String() == w.expected {
+		w.cancel()
+	}
+	return n, err
+}
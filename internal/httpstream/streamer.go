@@ -0,0 +1,139 @@
+package httpstream
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"majmun/internal/cache"
+	"majmun/internal/config/common"
+	"majmun/internal/ioutil"
+	"majmun/internal/logging"
+	"net/http"
+	"time"
+)
+
+const (
+	bufferSize      = 64 * 1024
+	responseTimeout = 10 * time.Second
+	reconnectDelay  = time.Second
+
+	// maxReconnects limits reconnects in a row, the counter is reset once a connection lasted reconnectResetAfter.
+	maxReconnects       = 3
+	reconnectResetAfter = time.Minute
+)
+
+var (
+	ErrNoData = fmt.Errorf("no data from upstream: %w", ioutil.ErrReaderTimeout)
+
+	errClientWrite = errors.New("client write failed")
+)
+
+// NewHTTPClient returns a client for upstream streams. It sends the cache headers,
+// and limits the time to the response headers instead of the whole request.
+func NewHTTPClient(headers []common.NameValue) *http.Client {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.ResponseHeaderTimeout = responseTimeout
+
+	return cache.NewHTTPClient(transport, headers, 0)
+}
+
+// Streamer copies an upstream MPEG-TS stream over HTTP without an external command.
+type Streamer struct {
+	client        *http.Client
+	url           string
+	noDataTimeout time.Duration
+}
+
+func NewStreamer(client *http.Client, url string, noDataTimeout time.Duration) *Streamer {
+	return &Streamer{
+		client:        client,
+		url:           url,
+		noDataTimeout: noDataTimeout,
+	}
+}
+
+// Stream copies the response body to w. When the upstream closes the connection after sending data,
+// it reconnects to the same URL, so a short network drop does not end the stream.
+func (s *Streamer) Stream(ctx context.Context, w io.Writer) (int64, error) {
+	var written int64
+	reconnects := 0
+
+	for {
+		startedAt := time.Now()
+		n, err := s.fetch(ctx, w)
+		written += n
+
+		if ctx.Err() != nil || errors.Is(err, errClientWrite) {
+			return written, nil
+		}
+		if written == 0 || errors.Is(err, ErrNoData) {
+			return written, err
+		}
+
+		if time.Since(startedAt) >= reconnectResetAfter {
+			reconnects = 0
+		}
+		if reconnects >= maxReconnects {
+			return written, err
+		}
+		reconnects++
+
+		logging.Info(ctx, "upstream connection ended, reconnecting", "attempt", reconnects, "reason", err)
+
+		select {
+		case <-ctx.Done():
+			return written, nil
+		case <-time.After(reconnectDelay):
+		}
+	}
+}
+
+func (s *Streamer) fetch(ctx context.Context, w io.Writer) (int64, error) {
+	// The watchdog aborts the request through the context when the upstream sends no data for the no data timeout.
+	ctx, watchdog := ioutil.NewWatchdog(ctx, s.noDataTimeout, ErrNoData)
+	defer watchdog.Stop()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, s.url, nil)
+	if err != nil {
+		return 0, err
+	}
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		if errors.Is(context.Cause(ctx), ErrNoData) {
+			return 0, ErrNoData
+		}
+		return 0, err
+	}
+	defer func() { _ = resp.Body.Close() }()
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return 0, fmt.Errorf("upstream returned status %d", resp.StatusCode)
+	}
+
+	buf := make([]byte, bufferSize)
+	var written int64
+
+	for {
+		n, err := resp.Body.Read(buf)
+		if n > 0 {
+			watchdog.Kick()
+			if _, writeErr := w.Write(buf[:n]); writeErr != nil {
+				return written, errClientWrite
+			}
+			written += int64(n)
+		}
+
+		if err != nil && errors.Is(context.Cause(ctx), ErrNoData) {
+			logging.Info(ctx, "no data from upstream, stopping stream", "timeout", s.noDataTimeout)
+			return written, ErrNoData
+		}
+		if err == io.EOF {
+			return written, io.ErrUnexpectedEOF
+		}
+		if err != nil {
+			return written, err
+		}
+	}
+}
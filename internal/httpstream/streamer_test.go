@@ -0,0 +1,95 @@
+package httpstream
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"majmun/internal/config/common"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// cancelWriter cancels the stream once it received the expected data.
+type cancelWriter struct {
+	bytes.Buffer
+	expected string
+	cancel   context.CancelFunc
+}
+
+func (w *cancelWriter) Write(p []byte) (int, error) {
+	n, err := w.Buffer.Write(p)
+	if w.String() == w.expected {
+		w.cancel()
+	}
+	return n, err
+}
+
+func TestStreamReconnects(t *testing.T) {
+	var requests atomic.Int32
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("X-Token") != "secret" {
+			http.Error(w, "forbidden", http.StatusForbidden)
+			return
+		}
+		if r.URL.Path == "/" {
+			http.Redirect(w, r, "/live.ts", http.StatusFound)
+			return
+		}
+
+		if requests.Add(1) == 1 {
+			_, _ = w.Write([]byte("first"))
+			return
+		}
+		_, _ = w.Write([]byte("second"))
+		w.(http.Flusher).Flush()
+		<-r.Context().Done()
+	}))
+	defer server.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	client := NewHTTPClient([]common.NameValue{{Name: "X-Token", Value: "secret"}})
+	w := &cancelWriter{expected: "firstsecond", cancel: cancel}
+
+	written, err := NewStreamer(client, server.URL, 0).Stream(ctx, w)
+	if err != nil {
+		t.Fatalf("Stream() error = %v", err)
+	}
+	if w.String() != "firstsecond" || written != int64(len("firstsecond")) {
+		t.Errorf("Stream() wrote %q (%d bytes), expected %q", w.String(), written, "firstsecond")
+	}
+	if n := requests.Load(); n != 2 {
+		t.Errorf("upstream got %d stream requests, expected 2", n)
+	}
+}
+
+func TestStreamUpstreamError(t *testing.T) {
+	server := httptest.NewServer(http.NotFoundHandler())
+	defer server.Close()
+
+	written, err := NewStreamer(NewHTTPClient(nil), server.URL, 0).Stream(context.Background(), &bytes.Buffer{})
+	if err == nil {
+		t.Fatal("Stream() expected an error for a missing stream")
+	}
+	if written != 0 {
+		t.Errorf("Stream() wrote %d bytes, expected none", written)
+	}
+}
+
+func TestStreamNoData(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.(http.Flusher).Flush()
+		<-r.Context().Done()
+	}))
+	defer server.Close()
+
+	_, err := NewStreamer(NewHTTPClient(nil), server.URL, 100*time.Millisecond).Stream(context.Background(), &bytes.Buffer{})
+	if !errors.Is(err, ErrNoData) {
+		t.Errorf("Stream() error = %v, expected %v", err, ErrNoData)
+	}
+}
@@ -3,34 +3,109 @@ package ioutil
 import (
 	"errors"
 	"io"
+	"sync"
 	"time"
 )
 
 var ErrReaderTimeout = errors.New("timeout")
 
+// TimeoutReader fails a read that gets no data within the timeout. Reads run on a single goroutine into a buffer
+// of the reader, so a read that timed out never writes into the caller's buffer, and its data is returned by the
+// next Read instead.
 type TimeoutReader struct {
 	r       io.Reader
 	timeout time.Duration
+	timer   *time.Timer
+
+	start   sync.Once
+	stop    sync.Once
+	reqs    chan []byte
+	results chan readResult
+	done    chan struct{}
+
+	buf     []byte
+	pending []byte
+	reading bool
+	err     error
+}
+
+type readResult struct {
+	n   int
+	err error
 }
 
 func NewTimeoutReader(r io.Reader, timeout time.Duration) *TimeoutReader {
-	return &TimeoutReader{r: r, timeout: timeout}
+	return &TimeoutReader{
+		r:       r,
+		timeout: timeout,
+		reqs:    make(chan []byte, 1),
+		results: make(chan readResult, 1),
+		done:    make(chan struct{}),
+	}
 }
 
 func (r *TimeoutReader) Read(p []byte) (int, error) {
-	c := make(chan struct{})
-	var n int
-	var err error
+	if len(r.pending) > 0 {
+		n := copy(p, r.pending)
+		r.pending = r.pending[n:]
+		return n, nil
+	}
+	if r.err != nil {
+		return 0, r.err
+	}
+	if len(p) == 0 {
+		return 0, nil
+	}
 
-	go func() {
-		n, err = r.r.Read(p)
-		close(c)
-	}()
+	if !r.reading {
+		r.start.Do(func() { go r.run() })
+		if cap(r.buf) < len(p) {
+			r.buf = make([]byte, len(p))
+		}
+		r.reqs <- r.buf[:len(p)]
+		r.reading = true
+	}
+
+	if r.timer == nil {
+		r.timer = time.NewTimer(r.timeout)
+	} else {
+		r.timer.Reset(r.timeout)
+	}
 
 	select {
-	case <-c:
-		return n, err
-	case <-time.After(r.timeout):
+	case res := <-r.results:
+		r.timer.Stop()
+		r.reading = false
+		r.err = res.err
+
+		n := copy(p, r.buf[:res.n])
+		r.pending = r.buf[n:res.n]
+		if len(r.pending) > 0 {
+			return n, nil
+		}
+		return n, res.err
+	case <-r.timer.C:
 		return 0, ErrReaderTimeout
 	}
 }
+
+// Close stops the read goroutine once the read in progress returns. It does not close the underlying reader.
+func (r *TimeoutReader) Close() error {
+	r.stop.Do(func() { close(r.done) })
+	return nil
+}
+
+func (r *TimeoutReader) run() {
+	for {
+		select {
+		case p := <-r.reqs:
+			n, err := r.r.Read(p)
+			r.results <- readResult{n: n, err: err}
+			if err != nil {
+				return
+			}
+		case <-r.done:
+			return
+		}
+	}
+}
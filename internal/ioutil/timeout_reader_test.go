@@ -101,3 +101,36 @@ func TestTimeoutReader_Read_EOF(t *testing.T) {
 		t.Fatalf("Expected EOF error, got: %v", err)
 	}
 }
+
+func TestTimeoutReader_Read_DataAfterTimeout(t *testing.T) {
+	mock := &mockReader{
+		delay:    50 * time.Millisecond,
+		response: []byte("late data"),
+	}
+
+	reader := NewTimeoutReader(mock, 10*time.Millisecond)
+	defer reader.Close()
+
+	first := make([]byte, 100)
+	if _, err := reader.Read(first); !errors.Is(err, ErrReaderTimeout) {
+		t.Fatalf("expected errReaderTimeout, got: %v", err)
+	}
+
+	reader.timeout = time.Second
+	second := make([]byte, 4)
+	var got []byte
+	for len(got) < len("late data") {
+		n, err := reader.Read(second)
+		if err != nil {
+			t.Fatalf("expected no error, got: %v", err)
+		}
+		got = append(got, second[:n]...)
+	}
+
+	if string(got) != "late data" {
+		t.Fatalf("expected data 'late data', got: '%s'", got)
+	}
+	if first[0] != 0 {
+		t.Fatalf("expected the timed out buffer to stay untouched, got: '%s'", first)
+	}
+}
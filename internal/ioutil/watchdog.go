@@ -0,0 +1,57 @@
+package ioutil
+
+import (
+	"context"
+	"io"
+	"time"
+)
+
+// Watchdog cancels its context with a cause once it has not been kicked for the timeout.
+// A single timer is reset on every kick, so watching a busy stream costs no extra goroutines.
+type Watchdog struct {
+	timer   *time.Timer
+	timeout time.Duration
+	cancel  context.CancelCauseFunc
+}
+
+// NewWatchdog returns a context that is canceled with cause when the watchdog is not kicked within the timeout.
+// A watchdog without a timeout never fires. Stop must be called to release the context.
+func NewWatchdog(ctx context.Context, timeout time.Duration, cause error) (context.Context, *Watchdog) {
+	ctx, cancel := context.WithCancelCause(ctx)
+
+	w := &Watchdog{timeout: timeout, cancel: cancel}
+	if timeout > 0 {
+		w.timer = time.AfterFunc(timeout, func() { cancel(cause) })
+	}
+	return ctx, w
+}
+
+// Kick restarts the timeout.
+func (w *Watchdog) Kick() {
+	if w.timer != nil {
+		w.timer.Reset(w.timeout)
+	}
+}
+
+// Stop stops the watchdog and cancels its context.
+func (w *Watchdog) Stop() {
+	if w.timer != nil {
+		w.timer.Stop()
+	}
+	w.cancel(nil)
+}
+
+// Writer returns a writer that kicks the watchdog on every write to dst.
+func (w *Watchdog) Writer(dst io.Writer) io.Writer {
+	return &watchdogWriter{w: dst, watchdog: w}
+}
+
+type watchdogWriter struct {
+	w        io.Writer
+	watchdog *Watchdog
+}
+
+func (ww *watchdogWriter) Write(p []byte) (int, error) {
+	ww.watchdog.Kick()
+	return ww.w.Write(p)
+}
@@ -0,0 +1,58 @@
+package ioutil
+
+import (
+	"context"
+	"errors"
+	"io"
+	"testing"
+	"time"
+)
+
+var errTestStalled = errors.New("stalled")
+
+func TestWatchdog_Fires(t *testing.T) {
+	ctx, watchdog := NewWatchdog(context.Background(), 20*time.Millisecond, errTestStalled)
+	defer watchdog.Stop()
+
+	select {
+	case <-ctx.Done():
+	case <-time.After(time.Second):
+		t.Fatal("watchdog did not fire")
+	}
+
+	if !errors.Is(context.Cause(ctx), errTestStalled) {
+		t.Fatalf("expected cause %v, got: %v", errTestStalled, context.Cause(ctx))
+	}
+}
+
+func TestWatchdog_KickedByWrites(t *testing.T) {
+	ctx, watchdog := NewWatchdog(context.Background(), 50*time.Millisecond, errTestStalled)
+	defer watchdog.Stop()
+
+	w := watchdog.Writer(io.Discard)
+	for i := 0; i < 10; i++ {
+		time.Sleep(10 * time.Millisecond)
+		if _, err := w.Write([]byte("data")); err != nil {
+			t.Fatalf("expected no error, got: %v", err)
+		}
+	}
+
+	if ctx.Err() != nil {
+		t.Fatalf("watchdog fired while data was written: %v", context.Cause(ctx))
+	}
+}
+
+func TestWatchdog_NoTimeout(t *testing.T) {
+	ctx, watchdog := NewWatchdog(context.Background(), 0, errTestStalled)
+	watchdog.Kick()
+
+	time.Sleep(20 * time.Millisecond)
+	if ctx.Err() != nil {
+		t.Fatalf("watchdog without a timeout fired: %v", context.Cause(ctx))
+	}
+
+	watchdog.Stop()
+	if !errors.Is(ctx.Err(), context.Canceled) || context.Cause(ctx) == errTestStalled {
+		t.Fatalf("expected the context to be canceled by Stop, got: %v", context.Cause(ctx))
+	}
+}
@@ -8,7 +8,9 @@ import (
 	"net/http"
 	"net/url"
 	"os"
+	"strconv"
 	"strings"
+	"time"
 )
 
 func GenerateHashID(parts ...string) string {
@@ -21,29 +23,42 @@ func GenerateHashID(parts ...string) string {
 }
 
 func CreateReader(ctx context.Context, httpClient HTTPClient, resourceURL string) (io.ReadCloser, error) {
-	if isURL(resourceURL) {
+	reader, _, err := OpenSource(ctx, httpClient, resourceURL)
+	return reader, err
+}
+
+// OpenSource opens a source like CreateReader and also returns when its data was fetched from the origin,
+// which is earlier than now for a response served from the cache.
+func OpenSource(ctx context.Context, httpClient HTTPClient, resourceURL string) (io.ReadCloser, time.Time, error) {
+	if IsURL(resourceURL) {
 		req, err := http.NewRequestWithContext(ctx, "GET", resourceURL, nil)
 		if err != nil {
-			return nil, err
+			return nil, time.Time{}, err
 		}
 
 		resp, err := httpClient.Do(req)
 		if err != nil {
-			return nil, err
+			return nil, time.Time{}, err
 		}
 
-		return resp.Body, nil
+		updatedAt := time.Now()
+		if age, err := strconv.Atoi(resp.Header.Get("Age")); err == nil {
+			updatedAt = updatedAt.Add(-time.Duration(age) * time.Second)
+		}
+
+		return resp.Body, updatedAt, nil
 	}
 
 	reader, err := openLocalFile(resourceURL)
 	if err != nil {
-		return nil, err
+		return nil, time.Time{}, err
 	}
 
-	return reader, nil
+	return reader, time.Now(), nil
 }
 
-func isURL(path string) bool {
+// IsURL reports whether the source is fetched over HTTP rather than read from a local file.
+func IsURL(path string) bool {
 	u, err := url.Parse(path)
 	if err != nil {
 		return false
@@ -26,6 +26,11 @@ type Playlist interface {
 	URLGenerator() *urlgen.Generator
 	Rules() []*channel.Rule
 	IsProxied() bool
+	// IsHLS reports whether links of channels with the given proxy profile are served as HLS.
+	IsHLS(profile string) bool
+	// IsRequired reports whether the playlist fails to load when one of its sources fails,
+	// instead of being served without that source.
+	IsRequired() bool
 }
 
 type EPG interface {
@@ -3,28 +3,47 @@ package m3u8
 import (
 	"context"
 	"io"
+	"time"
 
 	"majmun/internal/listing"
 	"majmun/internal/parser/m3u8"
+	"majmun/internal/xtream"
 )
 
 type decoderWrapper struct {
 	*listing.BaseDecoder
 	subscription listing.Playlist
+	// source is the index of the source in the playlist, and updatedAt the time its data was fetched.
+	source    int
+	updatedAt time.Time
 }
 
-func newDecoderWrapper(subscription listing.Playlist, httpClient listing.HTTPClient, url string) *decoderWrapper {
+func newDecoderWrapper(
+	subscription listing.Playlist, httpClient listing.HTTPClient, url string, source int) *decoderWrapper {
+	dw := &decoderWrapper{
+		subscription: subscription,
+		source:       source,
+	}
+
 	initFunc := func(ctx context.Context, url string) (listing.Decoder, io.ReadCloser, error) {
-		reader, err := listing.CreateReader(ctx, httpClient, url)
+		if xtream.IsSource(url) {
+			decoder, err := xtream.NewSourceDecoder(ctx, httpClient, url)
+			if err != nil {
+				return nil, nil, err
+			}
+			dw.updatedAt = time.Now()
+			return decoder, nil, nil
+		}
+
+		reader, updatedAt, err := listing.OpenSource(ctx, httpClient, url)
 		if err != nil {
 			return nil, nil, err
 		}
+		dw.updatedAt = updatedAt
 		decoder := m3u8.NewDecoder(reader)
 		return decoder, reader, nil
 	}
 
-	return &decoderWrapper{
-		BaseDecoder:  listing.NewLazyBaseDecoder(url, initFunc),
-		subscription: subscription,
-	}
+	dw.BaseDecoder = listing.NewLazyBaseDecoder(url, initFunc)
+	return dw
 }
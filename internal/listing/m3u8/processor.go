@@ -8,6 +8,7 @@ import (
 	"majmun/internal/listing/m3u8/store"
 	"majmun/internal/parser/m3u8"
 	"majmun/internal/urlgen"
+	"maps"
 	"net/url"
 	"strings"
 )
@@ -49,17 +50,19 @@ func (p *Processor) Process(
 			continue
 		}
 
+		// The stream keeps the original attributes for stream command templates.
+		var streams []urlgen.Stream
+		if ch.URI() != nil {
+			streams = []urlgen.Stream{p.createStream(ch)}
+		}
+
 		if ch.Playlist().IsProxied() {
 			if err := p.proxyChannelAttributes(ch); err != nil {
 				return nil, err
 			}
 		}
 
-		if ch.URI() != nil {
-			p.channelStreams[ch] = []urlgen.Stream{p.createStream(ch)}
-		} else {
-			p.channelStreams[ch] = nil
-		}
+		p.channelStreams[ch] = streams
 
 		p.trackChannel(ch)
 	}
@@ -104,8 +107,12 @@ func (p *Processor) createStream(ch *store.Channel) urlgen.Stream {
 			ProviderType: urlgen.ProviderTypePlaylist,
 			ProviderName: ch.Playlist().Name(),
 		},
-		URL:    ch.URI().String(),
-		Hidden: ch.IsHidden(),
+		URL:          ch.URI().String(),
+		Hidden:       ch.IsHidden(),
+		Attrs:        maps.Clone(ch.Attrs()),
+		Tags:         maps.Clone(ch.Tags()),
+		ProxyProfile: ch.ProxyProfile(),
+		Group:        ch.ConcurrencyGroup(),
 	}
 }
 
@@ -156,7 +163,12 @@ func (p *Processor) updateChannelURI(ch *store.Channel) {
 	}
 
 	urlGen := ch.Playlist().URLGenerator()
-	if u, err := urlGen.CreateStreamURL(ch.Name(), streams); err == nil {
+	createURL := urlGen.CreateStreamURL
+	if ch.Playlist().IsHLS(streams[0].ProxyProfile) {
+		createURL = urlGen.CreateHLSStreamURL
+	}
+
+	if u, err := createURL(ch.Name(), streams); err == nil {
 		ch.SetURI(u)
 	}
 }
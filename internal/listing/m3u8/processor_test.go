@@ -0,0 +1,55 @@
+package m3u8
+
+import (
+	"context"
+	"majmun/internal/app"
+	"majmun/internal/config"
+	"majmun/internal/config/proxy"
+	"majmun/internal/listing/m3u8/rules/channel"
+	"majmun/internal/listing/m3u8/rules/playlist"
+	"majmun/internal/listing/m3u8/store"
+	"majmun/internal/parser/m3u8"
+	"majmun/internal/urlgen"
+	"net/url"
+	"path"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestProcessorProfileHLSOutput(t *testing.T) {
+	generator, err := urlgen.NewGenerator("http://localhost", "secret", time.Hour, time.Hour)
+	require.NoError(t, err)
+
+	client, err := app.NewClient(config.Client{Name: "test", Secret: "client-secret"}, generator, nil, nil, "http://localhost")
+	require.NoError(t, err)
+
+	enabled := true
+	profiles := []config.ProxyProfile{{Name: "hls", Proxy: proxy.Proxy{Output: proxy.OutputHLS}}}
+	err = client.BuildPlaylistProvider(
+		config.Playlist{Name: "provider"}, proxy.Proxy{Enabled: &enabled}, profiles, nil, nil, nil)
+	require.NoError(t, err)
+	pl := client.Playlists()[0]
+
+	st := store.NewStore()
+	newChannel := func(name, profile string) *store.Channel {
+		uri, err := url.Parse("http://example.com/" + name)
+		require.NoError(t, err)
+
+		ch := store.NewChannel(&m3u8.Track{Name: name, URI: uri, Attrs: map[string]string{}, Tags: map[string]string{}}, pl)
+		ch.SetProxyProfile(profile)
+		st.Add(ch)
+		return ch
+	}
+	hlsChannel := newChannel("hls-channel", "hls")
+	tsChannel := newChannel("ts-channel", "")
+
+	_, err = NewProcessor().Process(context.Background(), st,
+		channel.NewRulesProcessor("test", nil), playlist.NewRulesProcessor("test", nil))
+	require.NoError(t, err)
+
+	assert.Equal(t, ".m3u8", path.Ext(hlsChannel.URI().Path), "profile with HLS output")
+	assert.Equal(t, ".ts", path.Ext(tsChannel.URI().Path), "playlist with MPEG-TS output")
+}
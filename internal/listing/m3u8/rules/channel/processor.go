@@ -45,6 +45,10 @@ func (p *Processor) processChannelRule(ch *store.Channel, rule *channel.Rule) er
 		p.processRemoveChannel(ch, rule.RemoveChannel)
 	case rule.MarkHidden != nil:
 		p.processMarkHidden(ch, rule.MarkHidden)
+	case rule.SetProxyProfile != nil:
+		p.processSetProxyProfile(ch, rule.SetProxyProfile)
+	case rule.SetConcurrencyGroup != nil:
+		p.processSetConcurrencyGroup(ch, rule.SetConcurrencyGroup)
 	}
 	return nil
 }
@@ -121,6 +125,20 @@ func (p *Processor) processMarkHidden(ch *store.Channel, rule *channel.MarkHidde
 	ch.MarkHidden()
 }
 
+func (p *Processor) processSetProxyProfile(ch *store.Channel, rule *channel.SetProxyProfileRule) {
+	if rule.Condition != nil && !p.matchesCondition(ch, *rule.Condition) {
+		return
+	}
+	ch.SetProxyProfile(rule.Profile)
+}
+
+func (p *Processor) processSetConcurrencyGroup(ch *store.Channel, rule *channel.SetConcurrencyGroupRule) {
+	if rule.Condition != nil && !p.matchesCondition(ch, *rule.Condition) {
+		return
+	}
+	ch.SetConcurrencyGroup(rule.Group)
+}
+
 func (p *Processor) matchesCondition(ch *store.Channel, condition common.Condition) bool {
 	if ch.IsRemoved() {
 		return false
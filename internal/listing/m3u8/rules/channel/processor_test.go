@@ -24,6 +24,8 @@ func (m mockPlaylist) Playlists() []string             { return nil }
 func (m mockPlaylist) URLGenerator() *urlgen.Generator { return nil }
 func (m mockPlaylist) Rules() []*channel.Rule          { return nil }
 func (m mockPlaylist) IsProxied() bool                 { return false }
+func (m mockPlaylist) IsHLS(string) bool               { return false }
+func (m mockPlaylist) IsRequired() bool                { return false }
 
 func TestConditionLogic(t *testing.T) {
 	playlist := mockPlaylist{name: "pl1"}
@@ -216,3 +218,63 @@ func TestEvaluateFieldConditionEdgeCases(t *testing.T) {
 		t.Error("expected false for non-existent tag")
 	}
 }
+
+func TestSetProxyProfile(t *testing.T) {
+	playlist := mockPlaylist{name: "pl1"}
+	uri, _ := url.Parse("http://example.com/stream")
+	processor := NewRulesProcessor("client1", []*channel.Rule{{
+		SetProxyProfile: &channel.SetProxyProfileRule{
+			Profile: "radio",
+			Condition: &common.Condition{
+				Selector: &common.Selector{Type: common.SelectorAttr, Value: "group-title"},
+				Patterns: common.RegexpArr{mustCompile("^Radio$")},
+			},
+		},
+	}})
+
+	radio := store.NewChannel(&m3u8.Track{Name: "Radio A", URI: uri, Attrs: map[string]string{"group-title": "Radio"}}, playlist)
+	tv := store.NewChannel(&m3u8.Track{Name: "TV A", URI: uri, Attrs: map[string]string{"group-title": "News"}}, playlist)
+
+	for _, ch := range []*store.Channel{radio, tv} {
+		if err := processor.processChannelRule(ch, processor.rules[0]); err != nil {
+			t.Fatalf("processChannelRule() error: %v", err)
+		}
+	}
+
+	if radio.ProxyProfile() != "radio" {
+		t.Errorf("radio channel profile = %q, want %q", radio.ProxyProfile(), "radio")
+	}
+	if tv.ProxyProfile() != "" {
+		t.Errorf("tv channel profile = %q, want none", tv.ProxyProfile())
+	}
+}
+
+func TestSetConcurrencyGroup(t *testing.T) {
+	playlist := mockPlaylist{name: "pl1"}
+	uri, _ := url.Parse("http://example.com/stream")
+	processor := NewRulesProcessor("client1", []*channel.Rule{{
+		SetConcurrencyGroup: &channel.SetConcurrencyGroupRule{
+			Group: "sports",
+			Condition: &common.Condition{
+				Selector: &common.Selector{Type: common.SelectorAttr, Value: "group-title"},
+				Patterns: common.RegexpArr{mustCompile("^Sports$")},
+			},
+		},
+	}})
+
+	sports := store.NewChannel(&m3u8.Track{Name: "Sports A", URI: uri, Attrs: map[string]string{"group-title": "Sports"}}, playlist)
+	news := store.NewChannel(&m3u8.Track{Name: "News A", URI: uri, Attrs: map[string]string{"group-title": "News"}}, playlist)
+
+	for _, ch := range []*store.Channel{sports, news} {
+		if err := processor.processChannelRule(ch, processor.rules[0]); err != nil {
+			t.Fatalf("processChannelRule() error: %v", err)
+		}
+	}
+
+	if sports.ConcurrencyGroup() != "sports" {
+		t.Errorf("sports channel group = %q, want %q", sports.ConcurrencyGroup(), "sports")
+	}
+	if news.ConcurrencyGroup() != "" {
+		t.Errorf("news channel group = %q, want none", news.ConcurrencyGroup())
+	}
+}
@@ -24,6 +24,8 @@ func (m mockPlaylist) Playlists() []string             { return nil }
 func (m mockPlaylist) URLGenerator() *urlgen.Generator { return nil }
 func (m mockPlaylist) Rules() []*channel.Rule          { return nil }
 func (m mockPlaylist) IsProxied() bool                 { return false }
+func (m mockPlaylist) IsHLS(string) bool               { return false }
+func (m mockPlaylist) IsRequired() bool                { return false }
 
 func mustTemplate(tmpl string) *common.Template {
 	var t common.Template
@@ -14,11 +14,13 @@ type URLGenerator interface {
 }
 
 type Channel struct {
-	track    *m3u8.Track
-	playlist listing.Playlist
-	hidden   bool
-	removed  bool
-	priority int
+	track        *m3u8.Track
+	playlist     listing.Playlist
+	hidden       bool
+	removed      bool
+	priority     int
+	proxyProfile string
+	group        string
 }
 
 func NewChannel(track *m3u8.Track, playlist listing.Playlist) *Channel {
@@ -83,6 +85,22 @@ func (c *Channel) SetPriority(priority int) {
 	c.priority = priority
 }
 
+func (c *Channel) ProxyProfile() string {
+	return c.proxyProfile
+}
+
+func (c *Channel) SetProxyProfile(name string) {
+	c.proxyProfile = name
+}
+
+func (c *Channel) ConcurrencyGroup() string {
+	return c.group
+}
+
+func (c *Channel) SetConcurrencyGroup(name string) {
+	c.group = name
+}
+
 func (c *Channel) Attrs() map[string]string {
 	return c.track.Attrs
 }
@@ -2,12 +2,15 @@ package m3u8
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"io"
 	"majmun/internal/listing"
 	"majmun/internal/listing/m3u8/rules/channel"
 	"majmun/internal/listing/m3u8/rules/playlist"
 	"majmun/internal/listing/m3u8/store"
+	"majmun/internal/logging"
+	"majmun/internal/metrics"
 	"majmun/internal/parser/m3u8"
 )
 
@@ -30,7 +33,7 @@ func NewStreamer(subs []listing.Playlist, epgLink string, httpClient listing.HTT
 }
 
 func (s *Streamer) WriteTo(ctx context.Context, w io.Writer) (int64, error) {
-	channels, err := s.getChannels(ctx)
+	channels, err := s.Channels(ctx)
 	if err != nil {
 		return 0, err
 	}
@@ -40,7 +43,7 @@ func (s *Streamer) WriteTo(ctx context.Context, w io.Writer) (int64, error) {
 }
 
 func (s *Streamer) GetAllChannels(ctx context.Context) (map[string]string, error) {
-	channels, err := s.getChannels(ctx)
+	channels, err := s.Channels(ctx)
 	if err != nil {
 		return nil, err
 	}
@@ -55,7 +58,7 @@ func (s *Streamer) GetAllChannels(ctx context.Context) (map[string]string, error
 	return channelMap, nil
 }
 
-func (s *Streamer) getChannels(ctx context.Context) ([]*store.Channel, error) {
+func (s *Streamer) Channels(ctx context.Context) ([]*store.Channel, error) {
 	st, err := s.fetchPlaylists(ctx)
 	if err != nil {
 		return nil, err
@@ -66,13 +69,15 @@ func (s *Streamer) getChannels(ctx context.Context) ([]*store.Channel, error) {
 	return processor.Process(ctx, st, s.channelProcessor, s.playlistProcessor)
 }
 
+// fetchPlaylists loads the channels of all sources. A source that fails is skipped, unless its playlist is required,
+// so one broken provider does not take down the whole playlist.
 func (s *Streamer) fetchPlaylists(ctx context.Context) (*store.Store, error) {
 	st := store.NewStore()
 
 	var decoders []*decoderWrapper
 	for _, sub := range s.subscriptions {
-		for _, url := range sub.Playlists() {
-			decoders = append(decoders, newDecoderWrapper(sub, s.httpClient, url))
+		for i, url := range sub.Playlists() {
+			decoders = append(decoders, newDecoderWrapper(sub, s.httpClient, url, i))
 		}
 	}
 
@@ -84,44 +89,86 @@ func (s *Streamer) fetchPlaylists(ctx context.Context) (*store.Store, error) {
 		}
 	}()
 
-	for _, decoder := range decoders {
-		err := decoder.StartBuffering(ctx)
-		if err != nil {
-			return nil, err
+	var errs []error
+	failed := make([]bool, len(decoders))
+
+	for i, decoder := range decoders {
+		if err := decoder.StartBuffering(ctx); err != nil {
+			if err := s.skipSource(ctx, decoder, err); err != nil {
+				return nil, err
+			}
+			errs = append(errs, err)
+			failed[i] = true
 		}
 	}
 
-	for _, decoder := range decoders {
-		if err := s.processTracks(ctx, decoder, st); err != nil {
-			return nil, err
+	for i, decoder := range decoders {
+		if failed[i] {
+			continue
+		}
+
+		channels, err := s.processTracks(ctx, decoder)
+		if err != nil {
+			if err := s.skipSource(ctx, decoder, err); err != nil {
+				return nil, err
+			}
+			errs = append(errs, err)
+			continue
+		}
+
+		for _, ch := range channels {
+			st.Add(ch)
 		}
+		metrics.SetPlaylistSourceUpdated(decoder.subscription.Name(), decoder.source, decoder.updatedAt)
 	}
 
 	if st.Len() == 0 {
+		if len(errs) > 0 {
+			return nil, fmt.Errorf("no channels found in subscriptions: %w", errors.Join(errs...))
+		}
 		return nil, fmt.Errorf("no channels found in subscriptions")
 	}
 
 	return st, nil
 }
 
-func (s *Streamer) processTracks(ctx context.Context, decoder *decoderWrapper, st *store.Store) error {
+// skipSource records a failed source and returns the error if the failure must fail the whole playlist.
+func (s *Streamer) skipSource(ctx context.Context, decoder *decoderWrapper, err error) error {
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+
+	name := decoder.subscription.Name()
+	metrics.IncPlaylistSourceErrors(name, decoder.source)
+
+	if decoder.subscription.IsRequired() {
+		return fmt.Errorf("required playlist %s: %w", name, err)
+	}
+
+	// A stale cached copy would have been served instead of failing, so none is left to fall back to.
+	logging.Warn(ctx, err, "playlist source failed", "playlist", name, "source", decoder.source, "fallback", "skipped")
+	return nil
+}
+
+// processTracks reads the channels of a source, which are only added to the store once the whole source was read.
+func (s *Streamer) processTracks(ctx context.Context, decoder *decoderWrapper) ([]*store.Channel, error) {
 	decoder.StopBuffer()
 
+	var channels []*store.Channel
 	for {
 		select {
 		case <-ctx.Done():
-			return ctx.Err()
+			return nil, ctx.Err()
 		default:
 			item, err := decoder.NextItem()
 			if err == io.EOF {
-				return nil
+				return channels, nil
 			}
 			if err != nil {
-				return err
+				return nil, err
 			}
 			if track, ok := item.(*m3u8.Track); ok {
-				ch := store.NewChannel(track, decoder.subscription)
-				st.Add(ch)
+				channels = append(channels, store.NewChannel(track, decoder.subscription))
 			}
 		}
 	}
@@ -7,10 +7,12 @@ import (
 	"io"
 	"majmun/internal/app"
 	"majmun/internal/config/proxy"
+	channelconf "majmun/internal/config/rules/channel"
 	"majmun/internal/listing"
 	"majmun/internal/listing/m3u8/rules/channel"
 	"majmun/internal/listing/m3u8/rules/playlist"
 	"majmun/internal/urlgen"
+	"majmun/internal/utils"
 	"net/http"
 	"strings"
 	"testing"
@@ -19,7 +21,6 @@ import (
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/mock"
 	"github.com/stretchr/testify/require"
-	"golang.org/x/sync/semaphore"
 )
 
 func createStreamer(subscriptions []listing.Playlist, epgLink string, httpClient listing.HTTPClient) *Streamer {
@@ -47,7 +48,7 @@ func (m *MockHTTPClient) Do(req *http.Request) (*http.Response, error) {
 }
 
 func createTestSubscription(name string, playlists []string) (*app.Playlist, error) {
-	sem := semaphore.NewWeighted(1)
+	sem := utils.NewSemaphore("playlist", name, 1)
 	generator, err := urlgen.NewGenerator("http://localhost", "secret", time.Hour, time.Hour)
 	if err != nil {
 		return nil, err
@@ -59,6 +60,7 @@ func createTestSubscription(name string, playlists []string) (*app.Playlist, err
 		proxy.Proxy{},
 		nil,
 		sem,
+		nil,
 	)
 }
 
@@ -273,6 +275,52 @@ http://example.com/sports1`
 	httpClient.AssertExpectations(t)
 }
 
+type requiredPlaylist struct {
+	*app.Playlist
+}
+
+func (p requiredPlaylist) IsRequired() bool { return true }
+
+func TestStreamerSkipsFailedSource(t *testing.T) {
+	ctx := context.Background()
+	httpClient := new(MockHTTPClient)
+
+	sampleM3U := `#EXTM3U
+#EXTINF:-1 tvg-id="news1" group-title="News", News Channel 1
+http://example.com/news1`
+
+	httpClient.On("Do", mock.MatchedBy(func(req *http.Request) bool {
+		return req.URL.String() == "http://example.com/broken.m3u"
+	})).Return(nil, fmt.Errorf("connection failed"))
+
+	httpClient.On("Do", mock.MatchedBy(func(req *http.Request) bool {
+		return req.URL.String() == "http://example.com/working.m3u"
+	})).Return(&http.Response{
+		StatusCode: 200,
+		Body:       io.NopCloser(bytes.NewReader([]byte(sampleM3U))),
+	}, nil)
+
+	broken, err := createTestSubscription("broken", []string{"http://example.com/broken.m3u"})
+	require.NoError(t, err)
+
+	working, err := createTestSubscription("working", []string{"http://example.com/working.m3u"})
+	require.NoError(t, err)
+
+	streamer := createStreamer([]listing.Playlist{broken, working}, "", httpClient)
+
+	buffer := &bytes.Buffer{}
+	_, err = streamer.WriteTo(ctx, buffer)
+	require.NoError(t, err)
+	assert.Contains(t, buffer.String(), "News Channel 1")
+
+	streamer = createStreamer([]listing.Playlist{requiredPlaylist{broken}, working}, "", httpClient)
+
+	_, err = streamer.WriteTo(ctx, &bytes.Buffer{})
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "required playlist broken")
+	assert.Contains(t, err.Error(), "connection failed")
+}
+
 func TestStreamerWithMultipleSources(t *testing.T) {
 	ctx := context.Background()
 	httpClient := new(MockHTTPClient)
@@ -330,3 +378,58 @@ http://example.com/music1`
 
 	httpClient.AssertExpectations(t)
 }
+
+func TestStreamerProxiedStreamKeepsChannelData(t *testing.T) {
+	ctx := context.Background()
+	httpClient := new(MockHTTPClient)
+
+	sampleM3U := `#EXTM3U
+#EXTINF:-1 tvg-id="test1" tvg-logo="http://example.com/logo.png", Test Channel 1
+#EXTVLCOPT:http-user-agent=VLC
+http://example.com/stream1`
+
+	response := &http.Response{
+		StatusCode: 200,
+		Body:       io.NopCloser(bytes.NewReader([]byte(sampleM3U))),
+	}
+
+	httpClient.On("Do", mock.Anything).Return(response, nil)
+
+	enabled := true
+	generator, err := urlgen.NewGenerator("http://localhost", "secret", time.Hour, time.Hour)
+	require.NoError(t, err)
+	sub, err := app.NewPlaylistProvider(
+		"test-subscription", generator, []string{"http://example.com/playlist.m3u"},
+		proxy.Proxy{Enabled: &enabled}, nil, utils.NewSemaphore("playlist", "test-subscription", 1), nil,
+	)
+	require.NoError(t, err)
+
+	streamer := createStreamer([]listing.Playlist{sub}, "", httpClient)
+	streamer.channelProcessor = channel.NewRulesProcessor("test", []*channelconf.Rule{
+		{SetProxyProfile: &channelconf.SetProxyProfileRule{Profile: "deinterlace"}},
+		{SetConcurrencyGroup: &channelconf.SetConcurrencyGroupRule{Group: "sports"}},
+	})
+
+	buffer := &bytes.Buffer{}
+	_, err = streamer.WriteTo(ctx, buffer)
+	require.NoError(t, err)
+
+	var token string
+	for _, line := range strings.Split(buffer.String(), "\n") {
+		if strings.HasSuffix(line, "/f.ts") {
+			token = strings.Split(strings.TrimPrefix(line, "http://localhost/"), "/")[0]
+		}
+	}
+	require.NotEmpty(t, token)
+	assert.NotContains(t, buffer.String(), "http://example.com/logo.png")
+
+	data, err := generator.Decrypt(token)
+	require.NoError(t, err)
+	require.Len(t, data.StreamData.Streams, 1)
+
+	stream := data.StreamData.Streams[0]
+	assert.Equal(t, "http://example.com/logo.png", stream.Attrs["tvg-logo"])
+	assert.Equal(t, "http-user-agent=VLC", stream.Tags["EXTVLCOPT"])
+	assert.Equal(t, "deinterlace", stream.ProxyProfile)
+	assert.Equal(t, "sports", stream.Group)
+}
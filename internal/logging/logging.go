@@ -65,6 +65,13 @@ func Info(ctx context.Context, msg string, args ...any) {
 	log(ctx, slog.LevelInfo, msg, args...)
 }
 
+func Warn(ctx context.Context, err error, msg string, args ...any) {
+	if err != nil {
+		args = append(args, "error", err)
+	}
+	log(ctx, slog.LevelWarn, msg, args...)
+}
+
 func Error(ctx context.Context, err error, msg string, args ...any) {
 	if err != nil {
 		args = append(args, "error", err)
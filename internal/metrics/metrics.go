@@ -3,6 +3,8 @@ package metrics
 import (
 	"context"
 	"majmun/internal/ctxutil"
+	"strconv"
+	"time"
 
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/client_golang/prometheus/collectors"
@@ -12,6 +14,7 @@ const (
 	CacheStatusHit     = "hit"
 	CacheStatusMiss    = "miss"
 	CacheStatusRenewed = "renewed"
+	CacheStatusStale   = "stale"
 )
 
 const (
@@ -25,6 +28,29 @@ const (
 	FailureReasonPlaylistLimit = "playlist_limit"
 	FailureReasonClientLimit   = "client_limit"
 	FailureReasonUpstreamError = "upstream_error"
+	FailureReasonStalled       = "stalled"
+	FailureReasonInvalidData   = "invalid_data"
+	FailureReasonAccountLimit  = "account_limit"
+	FailureReasonPreempted     = "preempted"
+	FailureReasonChannelLimit  = "channel_limit"
+)
+
+const (
+	FailoverReasonEnded   = "ended"
+	FailoverReasonStalled = "stalled"
+)
+
+const (
+	RefreshResultSuccess = "success"
+	RefreshResultFailure = "failure"
+)
+
+const (
+	SemaphoreLevelGlobal   = "global"
+	SemaphoreLevelClient   = "client"
+	SemaphoreLevelPlaylist = "playlist"
+	SemaphoreLevelAccount  = "account"
+	SemaphoreLevelChannel  = "channel"
 )
 
 var (
@@ -60,6 +86,46 @@ var (
 		[]string{"client_name", "playlist_name", "channel_name", "reason"},
 	)
 
+	streamsFailoversTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "iptv_streams_failovers_total",
+			Help: "Total number of upstream restarts of running streams",
+		},
+		[]string{"playlist_name", "channel_name", "reason"},
+	)
+
+	streamsDroppedBytesTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "iptv_streams_dropped_bytes_total",
+			Help: "Total bytes skipped for clients that could not keep up with the stream",
+		},
+		[]string{"client_name", "channel_name"},
+	)
+
+	slowClientsDisconnectedTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "iptv_slow_clients_disconnected_total",
+			Help: "Total clients disconnected for not keeping up with the stream",
+		},
+		[]string{"client_name", "channel_name"},
+	)
+
+	semaphoresInUse = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "iptv_semaphores_in_use",
+			Help: "Currently acquired concurrency slots",
+		},
+		[]string{"level", "name"},
+	)
+
+	semaphoresQueued = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "iptv_semaphores_queued",
+			Help: "Requests waiting for a concurrency slot",
+		},
+		[]string{"level", "name"},
+	)
+
 	listingRequestsTotal = prometheus.NewCounterVec(
 		prometheus.CounterOpts{
 			Name: "iptv_listing_requests_total",
@@ -75,6 +141,46 @@ var (
 		},
 		[]string{"client_name", "request_type", "cache_status"},
 	)
+
+	playlistSourceUpdated = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "iptv_playlist_source_updated_timestamp_seconds",
+			Help: "Time the data of the last loaded playlist source was fetched from its origin",
+		},
+		[]string{"playlist_name", "source"},
+	)
+
+	playlistSourceErrorsTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "iptv_playlist_source_errors_total",
+			Help: "Total number of playlist sources that failed to load",
+		},
+		[]string{"playlist_name", "source"},
+	)
+
+	sourceRefreshesTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "iptv_source_refreshes_total",
+			Help: "Total number of scheduled source refreshes by result",
+		},
+		[]string{"request_type", "name", "source", "result"},
+	)
+
+	sourceRefreshed = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "iptv_source_refreshed_timestamp_seconds",
+			Help: "Time of the last successful scheduled refresh of a source",
+		},
+		[]string{"request_type", "name", "source"},
+	)
+
+	sourceRefreshDuration = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "iptv_source_refresh_duration_seconds",
+			Help: "Duration of the last scheduled refresh of a source",
+		},
+		[]string{"request_type", "name", "source"},
+	)
 )
 
 func IncPlaylistStreamsActive(ctx context.Context) {
@@ -93,8 +199,8 @@ func DecPlaylistStreamsActive(ctx context.Context) {
 	playlistStreamsActive.WithLabelValues(subscriptionName).Dec()
 }
 
-func SetPlaylistStreamsActive(playlistName string, value float64) {
-	playlistStreamsActive.WithLabelValues(playlistName).Set(value)
+func InitPlaylistStreamsActive(playlistName string) {
+	playlistStreamsActive.WithLabelValues(playlistName).Add(0)
 }
 
 func IncClientStreamsActive(ctx context.Context) {
@@ -139,6 +245,51 @@ func IncStreamsFailures(ctx context.Context, reason string) {
 	streamsFailuresTotal.WithLabelValues(clientName, playlistName, channelName, reason).Inc()
 }
 
+func IncStreamsFailovers(ctx context.Context, reason string) {
+	if ctxutil.ChannelHidden(ctx) {
+		return
+	}
+	subscriptionName := ctxutil.ProviderName(ctx)
+	channelName := ctxutil.ChannelName(ctx)
+	streamsFailoversTotal.WithLabelValues(subscriptionName, channelName, reason).Inc()
+}
+
+func AddStreamsDroppedBytes(ctx context.Context, n int64) {
+	if ctxutil.ChannelHidden(ctx) {
+		return
+	}
+	clientName := ctxutil.ClientName(ctx)
+	channelName := ctxutil.ChannelName(ctx)
+	streamsDroppedBytesTotal.WithLabelValues(clientName, channelName).Add(float64(n))
+}
+
+func IncSlowClientsDisconnected(ctx context.Context) {
+	if ctxutil.ChannelHidden(ctx) {
+		return
+	}
+	clientName := ctxutil.ClientName(ctx)
+	channelName := ctxutil.ChannelName(ctx)
+	slowClientsDisconnectedTotal.WithLabelValues(clientName, channelName).Inc()
+}
+
+func IncSemaphoresInUse(level, name string) {
+	semaphoresInUse.WithLabelValues(level, name).Inc()
+}
+
+func DecSemaphoresInUse(level, name string) {
+	semaphoresInUse.WithLabelValues(level, name).Dec()
+}
+
+// IncSemaphoresQueued and DecSemaphoresQueued count waiters, since playlist semaphores of different clients
+// share the same labels.
+func IncSemaphoresQueued(level, name string) {
+	semaphoresQueued.WithLabelValues(level, name).Inc()
+}
+
+func DecSemaphoresQueued(level, name string) {
+	semaphoresQueued.WithLabelValues(level, name).Dec()
+}
+
 func IncListingDownload(ctx context.Context) {
 	clientName := ctxutil.ClientName(ctx)
 	requestType := ctxutil.RequestType(ctx)
@@ -151,13 +302,45 @@ func IncProxyRequests(ctx context.Context, cacheStatus string) {
 	proxyRequestsTotal.WithLabelValues(clientName, requestType, cacheStatus).Inc()
 }
 
+// SetPlaylistSourceUpdated records when the data of a playlist source, given by its index, was fetched.
+func SetPlaylistSourceUpdated(playlistName string, source int, updatedAt time.Time) {
+	playlistSourceUpdated.WithLabelValues(playlistName, strconv.Itoa(source)).Set(float64(updatedAt.Unix()))
+}
+
+func IncPlaylistSourceErrors(playlistName string, source int) {
+	playlistSourceErrorsTotal.WithLabelValues(playlistName, strconv.Itoa(source)).Inc()
+}
+
+// ObserveSourceRefresh records a scheduled refresh of a playlist or EPG source, given by its index.
+func ObserveSourceRefresh(requestType, name string, source int, duration time.Duration, err error) {
+	index := strconv.Itoa(source)
+	sourceRefreshDuration.WithLabelValues(requestType, name, index).Set(duration.Seconds())
+
+	if err != nil {
+		sourceRefreshesTotal.WithLabelValues(requestType, name, index, RefreshResultFailure).Inc()
+		return
+	}
+	sourceRefreshesTotal.WithLabelValues(requestType, name, index, RefreshResultSuccess).Inc()
+	sourceRefreshed.WithLabelValues(requestType, name, index).SetToCurrentTime()
+}
+
 func init() {
 	Registry.MustRegister(clientStreamsActive)
 	Registry.MustRegister(playlistStreamsActive)
 	Registry.MustRegister(streamsReusedTotal)
 	Registry.MustRegister(streamsFailuresTotal)
+	Registry.MustRegister(streamsFailoversTotal)
+	Registry.MustRegister(streamsDroppedBytesTotal)
+	Registry.MustRegister(slowClientsDisconnectedTotal)
+	Registry.MustRegister(semaphoresInUse)
+	Registry.MustRegister(semaphoresQueued)
 	Registry.MustRegister(listingRequestsTotal)
 	Registry.MustRegister(proxyRequestsTotal)
+	Registry.MustRegister(playlistSourceUpdated)
+	Registry.MustRegister(playlistSourceErrorsTotal)
+	Registry.MustRegister(sourceRefreshesTotal)
+	Registry.MustRegister(sourceRefreshed)
+	Registry.MustRegister(sourceRefreshDuration)
 	Registry.MustRegister(collectors.NewGoCollector(
 		collectors.WithoutGoCollectorRuntimeMetrics(),
 	))
@@ -0,0 +1,80 @@
+package mpegts
+
+const (
+	PacketSize = 188
+	SyncByte   = 0x47
+
+	PATPID  uint16 = 0x0000
+	NullPID uint16 = 0x1fff
+
+	ptsClock = 90000
+	ptsMask  = 1<<33 - 1
+)
+
+func IsValid(p []byte) bool {
+	return len(p) >= PacketSize && p[0] == SyncByte
+}
+
+func PID(p []byte) uint16 {
+	return uint16(p[1]&0x1f)<<8 | uint16(p[2])
+}
+
+func PayloadUnitStart(p []byte) bool {
+	return p[1]&0x40 != 0
+}
+
+func hasAdaptationField(p []byte) bool {
+	return p[3]&0x20 != 0
+}
+
+func hasPayload(p []byte) bool {
+	return p[3]&0x10 != 0
+}
+
+func RandomAccess(p []byte) bool {
+	if !hasAdaptationField(p) || p[4] == 0 {
+		return false
+	}
+	return p[5]&0x40 != 0
+}
+
+func Payload(p []byte) []byte {
+	if !hasPayload(p) {
+		return nil
+	}
+
+	offset := 4
+	if hasAdaptationField(p) {
+		offset += 1 + int(p[4])
+	}
+	if offset >= PacketSize {
+		return nil
+	}
+	return p[offset:PacketSize]
+}
+
+func PTS(p []byte) (int64, bool) {
+	if !PayloadUnitStart(p) {
+		return 0, false
+	}
+
+	pes := Payload(p)
+	if len(pes) < 14 || pes[0] != 0 || pes[1] != 0 || pes[2] != 1 {
+		return 0, false
+	}
+	if pes[7]&0x80 == 0 {
+		return 0, false
+	}
+
+	pts := int64(pes[9]>>1&0x07)<<30 |
+		int64(pes[10])<<22 |
+		int64(pes[11]>>1)<<15 |
+		int64(pes[12])<<7 |
+		int64(pes[13]>>1)
+	return pts, true
+}
+
+// PTSDiff handles the 33-bit timestamp wraparound.
+func PTSDiff(from, to int64) float64 {
+	return float64((to-from)&ptsMask) / ptsClock
+}
@@ -0,0 +1,187 @@
+package mpegts
+
+import (
+	"testing"
+)
+
+func newPacket(pid uint16, pusi bool) []byte {
+	p := make([]byte, PacketSize)
+	for i := range p {
+		p[i] = 0xff
+	}
+	p[0] = SyncByte
+	p[1] = byte(pid>>8) & 0x1f
+	if pusi {
+		p[1] |= 0x40
+	}
+	p[2] = byte(pid)
+	p[3] = 0x10
+	return p
+}
+
+func withRandomAccess(p []byte) []byte {
+	p[3] = 0x30
+	p[4] = 1
+	p[5] = 0x40
+	return p
+}
+
+func newPESPacket(pid uint16, pts int64, randomAccess bool) []byte {
+	p := newPacket(pid, true)
+	offset := 4
+	if randomAccess {
+		withRandomAccess(p)
+		offset = 6
+	}
+
+	pes := p[offset:]
+	copy(pes, []byte{0, 0, 1, 0xe0, 0, 0, 0x80, 0x80, 5})
+	pes[9] = byte(0x21 | (pts>>29)&0x0e)
+	pes[10] = byte(pts >> 22)
+	pes[11] = byte(0x01 | (pts>>14)&0xfe)
+	pes[12] = byte(pts >> 7)
+	pes[13] = byte(0x01 | (pts<<1)&0xfe)
+	return p
+}
+
+func newPATPacket(pmtPID uint16) []byte {
+	p := newPacket(PATPID, true)
+	section := []byte{
+		0, // pointer field
+		tableIDPAT, 0xb0, 13, 0, 1, 0xc1, 0, 0,
+		0, 1, byte(0xe0 | pmtPID>>8), byte(pmtPID),
+		0, 0, 0, 0,
+	}
+	copy(p[4:], section)
+	return p
+}
+
+func newPMTPacket(pmtPID uint16, streams map[uint16]byte, order []uint16) []byte {
+	p := newPacket(pmtPID, true)
+	section := []byte{
+		0,
+		tableIDPMT, 0xb0, 0, 0, 1, 0xc1, 0, 0,
+		0xe1, 0x00, 0xf0, 0,
+	}
+	for _, pid := range order {
+		section = append(section, streams[pid], byte(0xe0|pid>>8), byte(pid), 0xf0, 0)
+	}
+	section = append(section, 0, 0, 0, 0)
+	section[3] = byte(len(section) - 4)
+	copy(p[4:], section)
+	return p
+}
+
+func TestPacketHeader(t *testing.T) {
+	p := newPacket(0x101, true)
+
+	if !IsValid(p) {
+		t.Fatal("expected valid packet")
+	}
+	if pid := PID(p); pid != 0x101 {
+		t.Errorf("PID() = %#x, want %#x", pid, 0x101)
+	}
+	if !PayloadUnitStart(p) {
+		t.Error("expected payload unit start")
+	}
+	if RandomAccess(p) {
+		t.Error("expected no random access indicator")
+	}
+	if len(Payload(p)) != PacketSize-4 {
+		t.Errorf("Payload() length = %d, want %d", len(Payload(p)), PacketSize-4)
+	}
+
+	withRandomAccess(p)
+	if !RandomAccess(p) {
+		t.Error("expected random access indicator")
+	}
+	if len(Payload(p)) != PacketSize-6 {
+		t.Errorf("Payload() length = %d, want %d", len(Payload(p)), PacketSize-6)
+	}
+
+	if IsValid(p[:100]) {
+		t.Error("expected short packet to be invalid")
+	}
+}
+
+func TestPTS(t *testing.T) {
+	tests := []struct {
+		name         string
+		pts          int64
+		randomAccess bool
+	}{
+		{"zero", 0, false},
+		{"with adaptation field", 900000, true},
+		{"max value", ptsMask, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			pts, ok := PTS(newPESPacket(0x100, tt.pts, tt.randomAccess))
+			if !ok {
+				t.Fatal("expected PTS to be present")
+			}
+			if pts != tt.pts {
+				t.Errorf("PTS() = %d, want %d", pts, tt.pts)
+			}
+		})
+	}
+
+	if _, ok := PTS(newPacket(0x100, false)); ok {
+		t.Error("expected no PTS without payload unit start")
+	}
+}
+
+func TestPTSDiff(t *testing.T) {
+	if d := PTSDiff(0, 90000); d != 1 {
+		t.Errorf("PTSDiff() = %v, want 1", d)
+	}
+	if d := PTSDiff(ptsMask-44999, 45000); d != 1 {
+		t.Errorf("PTSDiff() across wraparound = %v, want 1", d)
+	}
+}
+
+func TestTracker(t *testing.T) {
+	var tr Tracker
+
+	if _, ok := tr.TimingPID(); ok {
+		t.Fatal("expected unknown timing PID before PMT")
+	}
+
+	tr.Observe(newPATPacket(0x1000))
+	if tr.Tables() != nil {
+		t.Error("expected no tables before PMT")
+	}
+
+	tr.Observe(newPMTPacket(0x1000, map[uint16]byte{0x101: 0x0f, 0x100: 0x1b}, []uint16{0x101, 0x100}))
+
+	if !tr.HasPMT() || !tr.HasVideo() {
+		t.Fatal("expected PMT with video stream")
+	}
+	if pid, _ := tr.TimingPID(); pid != 0x100 {
+		t.Errorf("TimingPID() = %#x, want video PID %#x", pid, 0x100)
+	}
+	if len(tr.Tables()) != 2 {
+		t.Errorf("Tables() length = %d, want 2", len(tr.Tables()))
+	}
+	if !tr.IsTable(newPacket(0x1000, false)) || !tr.IsTable(newPacket(PATPID, false)) {
+		t.Error("expected PAT and PMT PIDs to be tables")
+	}
+	if tr.IsTable(newPacket(0x100, false)) {
+		t.Error("expected elementary stream not to be a table")
+	}
+}
+
+func TestTrackerAudioOnly(t *testing.T) {
+	var tr Tracker
+
+	tr.Observe(newPATPacket(0x1000))
+	tr.Observe(newPMTPacket(0x1000, map[uint16]byte{0x101: 0x0f}, []uint16{0x101}))
+
+	if tr.HasVideo() {
+		t.Error("expected no video stream")
+	}
+	if pid, ok := tr.TimingPID(); !ok || pid != 0x101 {
+		t.Errorf("TimingPID() = %#x, %v, want %#x", pid, ok, 0x101)
+	}
+}
@@ -0,0 +1,146 @@
+package mpegts
+
+const (
+	tableIDPAT = 0x00
+	tableIDPMT = 0x02
+)
+
+var videoStreamTypes = map[byte]bool{
+	0x01: true, // MPEG-1 video
+	0x02: true, // MPEG-2 video
+	0x10: true, // MPEG-4 part 2
+	0x1b: true, // H.264
+	0x24: true, // H.265
+}
+
+// Tracker follows the PAT and PMT of the first program in a transport stream.
+// Only tables that fit into a single packet are supported.
+type Tracker struct {
+	pmtPID   uint16
+	pat      []byte
+	pmt      []byte
+	videoPID uint16
+	firstPID uint16
+	hasVideo bool
+	hasPMT   bool
+}
+
+func (t *Tracker) Observe(p []byte) {
+	if !PayloadUnitStart(p) {
+		return
+	}
+
+	pid := PID(p)
+	switch {
+	case pid == PATPID:
+		t.parsePAT(p)
+	case t.pat != nil && pid == t.pmtPID:
+		t.parsePMT(p)
+	}
+}
+
+// Tables returns the latest PAT and PMT packets. They are overwritten in place when new tables are observed.
+func (t *Tracker) Tables() [][]byte {
+	if t.pat == nil || t.pmt == nil {
+		return nil
+	}
+	return [][]byte{t.pat, t.pmt}
+}
+
+func (t *Tracker) IsTable(p []byte) bool {
+	pid := PID(p)
+	return pid == PATPID || (t.pat != nil && pid == t.pmtPID)
+}
+
+func (t *Tracker) HasPMT() bool {
+	return t.hasPMT
+}
+
+func (t *Tracker) HasVideo() bool {
+	return t.hasVideo
+}
+
+func (t *Tracker) TimingPID() (uint16, bool) {
+	if !t.hasPMT {
+		return 0, false
+	}
+	if t.hasVideo {
+		return t.videoPID, true
+	}
+	return t.firstPID, true
+}
+
+func (t *Tracker) parsePAT(p []byte) {
+	section := tableSection(p, tableIDPAT)
+	if section == nil {
+		return
+	}
+
+	for i := 8; i+4 <= len(section)-4; i += 4 {
+		programNumber := uint16(section[i])<<8 | uint16(section[i+1])
+		if programNumber == 0 {
+			continue
+		}
+		t.pmtPID = uint16(section[i+2]&0x1f)<<8 | uint16(section[i+3])
+		t.pat = append(t.pat[:0], p[:PacketSize]...)
+		return
+	}
+}
+
+func (t *Tracker) parsePMT(p []byte) {
+	section := tableSection(p, tableIDPMT)
+	if section == nil || len(section) < 12 {
+		return
+	}
+
+	programInfoLength := int(section[10]&0x0f)<<8 | int(section[11])
+	end := len(section) - 4
+
+	var firstPID, videoPID uint16
+	var hasFirst, hasVideo bool
+
+	for i := 12 + programInfoLength; i+5 <= end; {
+		streamType := section[i]
+		pid := uint16(section[i+1]&0x1f)<<8 | uint16(section[i+2])
+		esInfoLength := int(section[i+3]&0x0f)<<8 | int(section[i+4])
+
+		if !hasFirst {
+			firstPID, hasFirst = pid, true
+		}
+		if !hasVideo && videoStreamTypes[streamType] {
+			videoPID, hasVideo = pid, true
+		}
+
+		i += 5 + esInfoLength
+	}
+
+	if !hasFirst {
+		return
+	}
+
+	t.pmt = append(t.pmt[:0], p[:PacketSize]...)
+	t.firstPID = firstPID
+	t.videoPID = videoPID
+	t.hasVideo = hasVideo
+	t.hasPMT = true
+}
+
+func tableSection(p []byte, tableID byte) []byte {
+	payload := Payload(p)
+	if len(payload) < 1 {
+		return nil
+	}
+
+	start := 1 + int(payload[0])
+	if start+3 > len(payload) || payload[start] != tableID {
+		return nil
+	}
+
+	section := payload[start:]
+	length := int(section[1]&0x0f)<<8 | int(section[2])
+	if 3+length > len(section) || length < 9 {
+		return nil
+	}
+
+	return section[:3+length]
+}
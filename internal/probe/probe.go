@@ -0,0 +1,97 @@
+package probe
+
+import (
+	"bytes"
+	"errors"
+	"majmun/internal/mpegts"
+)
+
+const (
+	MPEGTS = "mpegts"
+	ADTS   = "adts"
+	None   = "none"
+
+	// mpegtsPackets is how many consecutive packets must be in sync.
+	mpegtsPackets = 3
+
+	adtsHeaderSize = 7
+	adtsProbeSize  = 4096
+)
+
+var ErrInvalid = errors.New("stream data does not match the expected format")
+
+// Probe checks the first bytes of a stream before the response is committed.
+type Probe interface {
+	// Size returns how many bytes the probe needs. Check may get fewer when the stream ends early.
+	Size() int
+	Check(data []byte) error
+}
+
+// New returns the probe for the given format, or nil when streams are not checked.
+func New(format string) Probe {
+	switch format {
+	case MPEGTS:
+		return mpegtsProbe{}
+	case ADTS:
+		return adtsProbe{}
+	default:
+		return nil
+	}
+}
+
+type mpegtsProbe struct{}
+
+func (mpegtsProbe) Size() int {
+	return mpegtsPackets * mpegts.PacketSize
+}
+
+// Check expects sync bytes at packet intervals. Streams and joining clients always start on a packet boundary.
+func (mpegtsProbe) Check(data []byte) error {
+	if len(data) < mpegtsPackets*mpegts.PacketSize {
+		return ErrInvalid
+	}
+
+	for i := 0; i < mpegtsPackets; i++ {
+		if data[i*mpegts.PacketSize] != mpegts.SyncByte {
+			return ErrInvalid
+		}
+	}
+
+	return nil
+}
+
+type adtsProbe struct{}
+
+func (adtsProbe) Size() int {
+	return adtsProbeSize
+}
+
+// Check looks for two consecutive ADTS frames.
+func (adtsProbe) Check(data []byte) error {
+	for start := 0; start+adtsHeaderSize <= len(data); start++ {
+		i := bytes.IndexByte(data[start:], 0xff)
+		if i < 0 {
+			break
+		}
+		start += i
+
+		length, ok := adtsFrameLength(data[start:])
+		if !ok {
+			continue
+		}
+		if _, ok := adtsFrameLength(data[min(start+length, len(data)):]); ok {
+			return nil
+		}
+	}
+
+	return ErrInvalid
+}
+
+func adtsFrameLength(h []byte) (int, bool) {
+	if len(h) < adtsHeaderSize || h[0] != 0xff || h[1]&0xf6 != 0xf0 {
+		return 0, false
+	}
+
+	length := int(h[3]&0x03)<<11 | int(h[4])<<3 | int(h[5])>>5
+	return length, length >= adtsHeaderSize
+}
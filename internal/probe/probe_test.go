@@ -0,0 +1,71 @@
+package probe
+
+import (
+	"bytes"
+	"errors"
+	"majmun/internal/mpegts"
+	"testing"
+)
+
+func tsPackets(n int) []byte {
+	data := make([]byte, 0, n*mpegts.PacketSize)
+	for range n {
+		p := make([]byte, mpegts.PacketSize)
+		p[0] = mpegts.SyncByte
+		data = append(data, p...)
+	}
+	return data
+}
+
+func adtsFrames(n, length int) []byte {
+	var data []byte
+	for range n {
+		frame := make([]byte, length)
+		frame[0], frame[1] = 0xff, 0xf1
+		frame[3] = byte(length>>11) & 0x03
+		frame[4] = byte(length >> 3)
+		frame[5] = byte(length<<5) | 0x1f
+		data = append(data, frame...)
+	}
+	return data
+}
+
+func TestProbe(t *testing.T) {
+	html := []byte("<html><body><h1>404 Not Found</h1></body></html>" + string(bytes.Repeat([]byte(" "), 1024)))
+
+	tests := []struct {
+		name   string
+		format string
+		data   []byte
+		valid  bool
+	}{
+		{"mpegts aligned", MPEGTS, tsPackets(4), true},
+		{"mpegts mid packet", MPEGTS, tsPackets(5)[100:], false},
+		{"mpegts after html", MPEGTS, append([]byte("<html>404</html>"), tsPackets(4)...), false},
+		{"mpegts too short", MPEGTS, tsPackets(2), false},
+		{"mpegts html page", MPEGTS, html, false},
+		{"adts frames", ADTS, append([]byte{0x00, 0x12}, adtsFrames(3, 300)...), true},
+		{"adts single frame", ADTS, adtsFrames(1, 300), false},
+		{"adts html page", ADTS, html, false},
+		{"adts given mpegts", ADTS, tsPackets(4), false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := New(tt.format)
+			data := tt.data[:min(len(tt.data), p.Size())]
+
+			err := p.Check(data)
+			if tt.valid && err != nil {
+				t.Errorf("Check() error = %v, want nil", err)
+			}
+			if !tt.valid && !errors.Is(err, ErrInvalid) {
+				t.Errorf("Check() error = %v, want %v", err, ErrInvalid)
+			}
+		})
+	}
+
+	if New(None) != nil || New("") != nil {
+		t.Errorf("New() returned a probe for disabled probing")
+	}
+}
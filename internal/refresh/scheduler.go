@@ -0,0 +1,204 @@
+package refresh
+
+import (
+	"context"
+	"io"
+	"majmun/internal/config"
+	"majmun/internal/listing"
+	"majmun/internal/logging"
+	"majmun/internal/metrics"
+	"majmun/internal/xtream"
+	"math/rand/v2"
+	"sync"
+	"time"
+)
+
+// Source is a playlist or EPG source that is downloaded into the cache on a schedule.
+type Source struct {
+	Type     string
+	Name     string
+	Index    int
+	URL      string
+	Interval time.Duration
+}
+
+// Sources returns the sources of the playlists and EPGs with a refresh_interval. Local files are left out,
+// since they are never cached, and a source shared by several entries is refreshed at the shortest interval.
+func Sources(cfg *config.Config) []Source {
+	var sources []Source
+	byURL := make(map[string]int)
+
+	add := func(src Source) {
+		if src.Interval <= 0 || !(listing.IsURL(src.URL) || xtream.IsSource(src.URL)) {
+			return
+		}
+		if i, ok := byURL[src.URL]; ok {
+			sources[i].Interval = min(sources[i].Interval, src.Interval)
+			return
+		}
+		byURL[src.URL] = len(sources)
+		sources = append(sources, src)
+	}
+
+	for _, playlist := range cfg.Playlists {
+		for i, url := range playlist.Sources {
+			add(Source{metrics.RequestTypePlaylist, playlist.Name, i, url, time.Duration(playlist.RefreshInterval)})
+		}
+	}
+	for _, epg := range cfg.EPGs {
+		for i, url := range epg.Sources {
+			add(Source{metrics.RequestTypeEPG, epg.Name, i, url, time.Duration(epg.RefreshInterval)})
+		}
+	}
+
+	return sources
+}
+
+// Scheduler refreshes sources in the background, so client requests are served from a warm cache. The config
+// validation keeps every interval, with the jitter, shorter than the cache TTL, so a successful refresh always
+// lands before the cached copy expires.
+type Scheduler struct {
+	httpClient listing.HTTPClient
+
+	mu          sync.Mutex
+	lastRefresh map[string]time.Time
+
+	runMu  sync.Mutex
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+}
+
+// NewScheduler creates a scheduler that downloads the sources with httpClient, which is expected to store
+// the responses in the cache.
+func NewScheduler(httpClient listing.HTTPClient) *Scheduler {
+	return &Scheduler{
+		httpClient:  httpClient,
+		lastRefresh: make(map[string]time.Time),
+	}
+}
+
+// Update replaces the scheduled sources. Sources refreshed before keep their schedule, new ones are
+// refreshed right away, within the jitter.
+func (s *Scheduler) Update(ctx context.Context, sources []Source, cfg config.RefreshConfig) {
+	s.runMu.Lock()
+	defer s.runMu.Unlock()
+
+	s.stop()
+
+	ctx, cancel := context.WithCancel(ctx)
+	s.cancel = cancel
+
+	slots := make(chan struct{}, cfg.Concurrency)
+	jitter := time.Duration(cfg.Jitter)
+
+	for _, src := range sources {
+		s.wg.Add(1)
+		go s.run(ctx, src, slots, jitter)
+	}
+
+	if len(sources) > 0 {
+		logging.Info(ctx, "source refresh scheduled", "sources", len(sources))
+	}
+}
+
+// Stop cancels the running refreshes and waits for them to finish.
+func (s *Scheduler) Stop() {
+	s.runMu.Lock()
+	defer s.runMu.Unlock()
+
+	s.stop()
+}
+
+func (s *Scheduler) stop() {
+	if s.cancel != nil {
+		s.cancel()
+		s.cancel = nil
+	}
+	s.wg.Wait()
+}
+
+func (s *Scheduler) run(ctx context.Context, src Source, slots chan struct{}, jitter time.Duration) {
+	defer s.wg.Done()
+
+	timer := time.NewTimer(s.firstDelay(src) + randomJitter(jitter))
+	defer timer.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-timer.C:
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case slots <- struct{}{}:
+		}
+
+		s.refresh(ctx, src)
+		<-slots
+
+		timer.Reset(src.Interval + randomJitter(jitter))
+	}
+}
+
+func (s *Scheduler) firstDelay(src Source) time.Duration {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	last, ok := s.lastRefresh[src.URL]
+	if !ok {
+		return 0
+	}
+	return max(0, time.Until(last.Add(src.Interval)))
+}
+
+func (s *Scheduler) refresh(ctx context.Context, src Source) {
+	start := time.Now()
+	err := s.fetch(ctx, src.URL)
+	if ctx.Err() != nil {
+		return
+	}
+
+	metrics.ObserveSourceRefresh(src.Type, src.Name, src.Index, time.Since(start), err)
+
+	if err != nil {
+		logging.Error(ctx, err, "failed to refresh source",
+			"type", src.Type, "name", src.Name, "url", logging.SanitizeURL(src.URL))
+		return
+	}
+
+	s.mu.Lock()
+	s.lastRefresh[src.URL] = time.Now()
+	s.mu.Unlock()
+
+	logging.Debug(ctx, "source refreshed",
+		"type", src.Type, "name", src.Name, "url", logging.SanitizeURL(src.URL), "duration", time.Since(start))
+}
+
+// fetch downloads a source to the end, which is what stores it in the cache.
+func (s *Scheduler) fetch(ctx context.Context, url string) error {
+	if xtream.IsSource(url) {
+		_, err := xtream.NewSourceDecoder(ctx, s.httpClient, url)
+		return err
+	}
+
+	reader, err := listing.CreateReader(ctx, s.httpClient, url)
+	if err != nil {
+		return err
+	}
+
+	_, err = io.Copy(io.Discard, reader)
+	if closeErr := reader.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+func randomJitter(jitter time.Duration) time.Duration {
+	if jitter <= 0 {
+		return 0
+	}
+	return rand.N(jitter)
+}
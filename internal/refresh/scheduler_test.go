@@ -0,0 +1,144 @@
+package refresh
+
+import (
+	"context"
+	"majmun/internal/config"
+	"majmun/internal/config/common"
+	"majmun/internal/metrics"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func waitFor(t *testing.T, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(2 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatal("condition not met in time")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestSources(t *testing.T) {
+	cfg := &config.Config{
+		Playlists: []config.Playlist{
+			{
+				Name:            "scheduled",
+				Sources:         common.StringOrArr{"http://provider.com/a.m3u", "/local/b.m3u", "xtream://u:p@panel.com"},
+				RefreshInterval: common.Duration(time.Hour),
+			},
+			{
+				Name:    "lazy",
+				Sources: common.StringOrArr{"http://provider.com/c.m3u"},
+			},
+		},
+		EPGs: []config.EPG{
+			{
+				Name:            "guide",
+				Sources:         common.StringOrArr{"http://provider.com/epg.xml", "http://provider.com/a.m3u"},
+				RefreshInterval: common.Duration(time.Minute),
+			},
+		},
+	}
+
+	sources := Sources(cfg)
+
+	expected := []Source{
+		{metrics.RequestTypePlaylist, "scheduled", 0, "http://provider.com/a.m3u", time.Minute},
+		{metrics.RequestTypePlaylist, "scheduled", 2, "xtream://u:p@panel.com", time.Hour},
+		{metrics.RequestTypeEPG, "guide", 0, "http://provider.com/epg.xml", time.Minute},
+	}
+	if len(sources) != len(expected) {
+		t.Fatalf("Sources() = %+v, expected %+v", sources, expected)
+	}
+	for i := range expected {
+		if sources[i] != expected[i] {
+			t.Errorf("Sources()[%d] = %+v, expected %+v", i, sources[i], expected[i])
+		}
+	}
+}
+
+func TestSchedulerRefreshesSources(t *testing.T) {
+	var hits atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hits.Add(1)
+		_, _ = w.Write([]byte("#EXTM3U\n"))
+	}))
+	defer server.Close()
+
+	s := NewScheduler(server.Client())
+	s.Update(context.Background(), []Source{
+		{Type: metrics.RequestTypePlaylist, Name: "test", URL: server.URL, Interval: 10 * time.Millisecond},
+	}, config.RefreshConfig{Concurrency: 1})
+
+	waitFor(t, func() bool { return hits.Load() >= 3 })
+	s.Stop()
+
+	stopped := hits.Load()
+	time.Sleep(50 * time.Millisecond)
+	if hits.Load() != stopped {
+		t.Errorf("source refreshed after Stop()")
+	}
+}
+
+func TestSchedulerConcurrency(t *testing.T) {
+	var running, maxRunning, hits atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n := running.Add(1)
+		defer running.Add(-1)
+		for {
+			m := maxRunning.Load()
+			if n <= m || maxRunning.CompareAndSwap(m, n) {
+				break
+			}
+		}
+		time.Sleep(20 * time.Millisecond)
+		hits.Add(1)
+	}))
+	defer server.Close()
+
+	var sources []Source
+	for _, path := range []string{"/a", "/b", "/c", "/d"} {
+		sources = append(sources, Source{
+			Type: metrics.RequestTypeEPG, Name: "test", URL: server.URL + path, Interval: time.Hour})
+	}
+
+	s := NewScheduler(server.Client())
+	s.Update(context.Background(), sources, config.RefreshConfig{Concurrency: 2})
+	defer s.Stop()
+
+	waitFor(t, func() bool { return hits.Load() == 4 })
+	if m := maxRunning.Load(); m != 2 {
+		t.Errorf("max concurrent refreshes = %d, expected 2", m)
+	}
+}
+
+func TestSchedulerKeepsScheduleOnUpdate(t *testing.T) {
+	var hits atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hits.Add(1)
+	}))
+	defer server.Close()
+
+	sources := []Source{{Type: metrics.RequestTypePlaylist, Name: "test", URL: server.URL, Interval: time.Hour}}
+
+	s := NewScheduler(server.Client())
+	s.Update(context.Background(), sources, config.RefreshConfig{Concurrency: 1})
+	defer s.Stop()
+	waitFor(t, func() bool {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		_, ok := s.lastRefresh[server.URL]
+		return ok
+	})
+
+	s.Update(context.Background(), sources, config.RefreshConfig{Concurrency: 1})
+	time.Sleep(50 * time.Millisecond)
+	if n := hits.Load(); n != 1 {
+		t.Errorf("source refreshed %d times after Update(), expected to keep its schedule", n)
+	}
+}
@@ -0,0 +1,219 @@
+package server
+
+import (
+	"crypto/subtle"
+	"majmun/internal/app"
+	"majmun/internal/logging"
+	"majmun/internal/utils"
+	"net/http"
+	"strings"
+
+	"github.com/gorilla/mux"
+)
+
+const (
+	muxStreamKeyVar    = "stream_key"
+	muxSubscriberIDVar = "subscriber_id"
+	muxClientNameVar   = "client_name"
+)
+
+type adminDisconnected struct {
+	Disconnected int `json:"disconnected"`
+}
+
+type adminSemaphore struct {
+	Limit int64 `json:"limit"`
+	InUse int64 `json:"in_use"`
+}
+
+type adminPlaylist struct {
+	Name      string          `json:"name"`
+	Proxied   bool            `json:"proxied"`
+	Semaphore *adminSemaphore `json:"semaphore,omitempty"`
+}
+
+type adminEPG struct {
+	Name    string `json:"name"`
+	Proxied bool   `json:"proxied"`
+}
+
+type adminClient struct {
+	Name      string          `json:"name"`
+	Semaphore *adminSemaphore `json:"semaphore,omitempty"`
+	Playlists []adminPlaylist `json:"playlists"`
+	EPGs      []adminEPG      `json:"epgs"`
+}
+
+type adminClientSemaphores struct {
+	Semaphore *adminSemaphore            `json:"semaphore,omitempty"`
+	Playlists map[string]*adminSemaphore `json:"playlists"`
+}
+
+type adminSemaphores struct {
+	Global   *adminSemaphore                       `json:"global,omitempty"`
+	Clients  map[string]adminClientSemaphores      `json:"clients"`
+	Accounts map[string]map[string]*adminSemaphore `json:"accounts,omitempty"`
+	Groups   map[string]*adminSemaphore            `json:"concurrency_groups,omitempty"`
+}
+
+func (s *Server) setupAdminServer(addr, secret string) {
+	r := mux.NewRouter()
+	r.Use(s.requestIDMiddleware)
+	r.Use(s.loggerMiddleware)
+	r.Use(adminAuthMiddleware(secret))
+
+	api := r.PathPrefix("/api").Subrouter()
+	api.HandleFunc("/clients", s.handleAdminClients).Methods(http.MethodGet)
+	api.HandleFunc("/streams", s.handleAdminStreams).Methods(http.MethodGet)
+	api.HandleFunc("/semaphores", s.handleAdminSemaphores).Methods(http.MethodGet)
+	api.HandleFunc("/streams/{"+muxStreamKeyVar+"}", s.handleAdminStopStream).Methods(http.MethodDelete)
+	api.HandleFunc("/streams/{"+muxStreamKeyVar+"}/subscribers/{"+muxSubscriberIDVar+"}",
+		s.handleAdminDisconnectSubscriber).Methods(http.MethodDelete)
+	api.HandleFunc("/clients/{"+muxClientNameVar+"}/sessions",
+		s.handleAdminDisconnectClient).Methods(http.MethodDelete)
+
+	s.adminServer = &http.Server{
+		Addr:    addr,
+		Handler: r,
+	}
+}
+
+func adminAuthMiddleware(secret string) mux.MiddlewareFunc {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+			if !ok || subtle.ConstantTimeCompare([]byte(token), []byte(secret)) != 1 {
+				logging.Debug(r.Context(), "admin authentication failed")
+				http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+func (s *Server) handleAdminClients(w http.ResponseWriter, r *http.Request) {
+	clients := s.manager.Load().Clients()
+
+	result := make([]adminClient, 0, len(clients))
+	for _, c := range clients {
+		result = append(result, newAdminClient(c))
+	}
+
+	writeJSON(w, r, result)
+}
+
+func (s *Server) handleAdminStreams(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, r, s.demux.Streams())
+}
+
+func (s *Server) handleAdminStopStream(w http.ResponseWriter, r *http.Request) {
+	streamKey := mux.Vars(r)[muxStreamKeyVar]
+
+	if !s.demux.StopStream(streamKey) {
+		http.Error(w, http.StatusText(http.StatusNotFound), http.StatusNotFound)
+		return
+	}
+
+	logging.Info(r.Context(), "stream stopped by administrator", "stream_key", streamKey)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (s *Server) handleAdminDisconnectSubscriber(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	streamKey, subscriberID := vars[muxStreamKeyVar], vars[muxSubscriberIDVar]
+
+	if !s.demux.DisconnectSubscriber(streamKey, subscriberID) {
+		http.Error(w, http.StatusText(http.StatusNotFound), http.StatusNotFound)
+		return
+	}
+
+	logging.Info(r.Context(), "subscriber disconnected by administrator",
+		"stream_key", streamKey, "subscriber_id", subscriberID)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (s *Server) handleAdminDisconnectClient(w http.ResponseWriter, r *http.Request) {
+	clientName := mux.Vars(r)[muxClientNameVar]
+
+	n := s.demux.DisconnectClient(clientName) + s.disconnectHLSClient(clientName)
+
+	logging.Info(r.Context(), "client sessions disconnected by administrator",
+		"client", clientName, "sessions", n)
+	writeJSON(w, r, adminDisconnected{Disconnected: n})
+}
+
+func (s *Server) handleAdminSemaphores(w http.ResponseWriter, r *http.Request) {
+	m := s.manager.Load()
+
+	result := adminSemaphores{
+		Global:  newAdminSemaphore(m.Semaphore()),
+		Clients: make(map[string]adminClientSemaphores, len(m.Clients())),
+	}
+
+	for _, c := range m.Clients() {
+		cs := adminClientSemaphores{
+			Semaphore: newAdminSemaphore(c.Semaphore()),
+			Playlists: make(map[string]*adminSemaphore),
+		}
+		for _, pl := range c.Playlists() {
+			if sem := newAdminSemaphore(pl.Semaphore()); sem != nil {
+				cs.Playlists[pl.Name()] = sem
+			}
+		}
+		result.Clients[c.Name()] = cs
+	}
+
+	for playlistName, pool := range m.AccountPools() {
+		if result.Accounts == nil {
+			result.Accounts = make(map[string]map[string]*adminSemaphore)
+		}
+		accounts := make(map[string]*adminSemaphore, len(pool.Accounts()))
+		for _, account := range pool.Accounts() {
+			accounts[account.Name()] = newAdminSemaphore(account.Semaphore())
+		}
+		result.Accounts[playlistName] = accounts
+	}
+
+	for name, sem := range m.ConcurrencyGroups() {
+		if result.Groups == nil {
+			result.Groups = make(map[string]*adminSemaphore)
+		}
+		result.Groups[name] = newAdminSemaphore(sem)
+	}
+
+	writeJSON(w, r, result)
+}
+
+func newAdminClient(c *app.Client) adminClient {
+	ac := adminClient{
+		Name:      c.Name(),
+		Semaphore: newAdminSemaphore(c.Semaphore()),
+		Playlists: make([]adminPlaylist, 0, len(c.Playlists())),
+		EPGs:      make([]adminEPG, 0, len(c.EPGs())),
+	}
+
+	for _, pl := range c.Playlists() {
+		ac.Playlists = append(ac.Playlists, adminPlaylist{
+			Name:      pl.Name(),
+			Proxied:   pl.IsProxied(),
+			Semaphore: newAdminSemaphore(pl.Semaphore()),
+		})
+	}
+
+	for _, epg := range c.EPGs() {
+		ac.EPGs = append(ac.EPGs, adminEPG{
+			Name:    epg.Name(),
+			Proxied: epg.IsProxied(),
+		})
+	}
+
+	return ac
+}
+
+func newAdminSemaphore(sem *utils.Semaphore) *adminSemaphore {
+	if sem == nil {
+		return nil
+	}
+	return &adminSemaphore{Limit: sem.Size(), InUse: sem.InUse()}
+}
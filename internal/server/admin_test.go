@@ -0,0 +1,310 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"majmun/internal/config"
+	"majmun/internal/ctxutil"
+	"majmun/internal/demux"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+const testAdminSecret = "admin-secret"
+
+type blockingStreamer struct{}
+
+func (blockingStreamer) Stream(ctx context.Context, w io.Writer) (int64, error) {
+	n, _ := w.Write([]byte("data"))
+	<-ctx.Done()
+	return int64(n), ctx.Err()
+}
+
+type adminTestClient string
+
+func (c adminTestClient) Name() string {
+	return string(c)
+}
+
+func newTestServer(t *testing.T, content string) *Server {
+	t.Helper()
+
+	dir := t.TempDir()
+	path := filepath.Join(dir, "config.yaml")
+	content = fmt.Sprintf("cache:\n  path: %q\n%s", filepath.Join(dir, "cache"), content)
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatalf("failed to write config: %v", err)
+	}
+	cfg, err := config.Load(path)
+	if err != nil {
+		t.Fatalf("failed to load config: %v", err)
+	}
+
+	s, err := NewServer(cfg)
+	if err != nil {
+		t.Fatalf("NewServer() error = %v", err)
+	}
+	t.Cleanup(func() {
+		s.cancel()
+		s.demux.Stop()
+	})
+	return s
+}
+
+func newAdminTestServer(t *testing.T) *Server {
+	t.Helper()
+
+	return newTestServer(t, fmt.Sprintf(`server:
+  listen_addr: ":8080"
+  public_url: "http://example.com"
+  admin_addr: ":8081"
+  admin_secret: %q
+url_generator:
+  secret: "test-secret"
+proxy:
+  enabled: true
+  concurrency: 4
+playlists:
+  - name: provider
+    sources: ["http://provider.com/playlist.m3u"]
+    proxy:
+      concurrency: 2
+clients:
+  - name: living-room
+    secret: "client-secret"
+    playlists: ["provider"]`, testAdminSecret))
+}
+
+func adminRequest(t *testing.T, s *Server, method, path, token string) *httptest.ResponseRecorder {
+	t.Helper()
+
+	r := httptest.NewRequest(method, path, nil)
+	if token != "" {
+		r.Header.Set("Authorization", "Bearer "+token)
+	}
+	w := httptest.NewRecorder()
+	s.adminServer.Handler.ServeHTTP(w, r)
+	return w
+}
+
+func decodeJSON(t *testing.T, w *httptest.ResponseRecorder, v any) {
+	t.Helper()
+
+	if err := json.NewDecoder(w.Body).Decode(v); err != nil {
+		t.Fatalf("failed to decode response %q: %v", w.Body.String(), err)
+	}
+}
+
+func startTestStream(t *testing.T, s *Server, key, clientName string) io.ReadCloser {
+	t.Helper()
+
+	ctx := ctxutil.WithRequestID(ctxutil.WithClient(context.Background(), adminTestClient(clientName)))
+	reader, err := s.demux.GetReader(ctx, demux.Request{StreamKey: key, Streamer: blockingStreamer{}})
+	if err != nil {
+		t.Fatalf("GetReader() error = %v", err)
+	}
+	t.Cleanup(func() { _ = reader.Close() })
+
+	if _, err := reader.Read(make([]byte, 16)); err != nil {
+		t.Fatalf("Read() error = %v", err)
+	}
+	return reader
+}
+
+func readError(t *testing.T, r io.Reader) error {
+	t.Helper()
+
+	done := make(chan error, 1)
+	go func() {
+		buf := make([]byte, 16)
+		for {
+			if _, err := r.Read(buf); err != nil {
+				done <- err
+				return
+			}
+		}
+	}()
+
+	select {
+	case err := <-done:
+		return err
+	case <-time.After(3 * time.Second):
+		t.Fatal("reader was not closed in time")
+		return nil
+	}
+}
+
+func TestAdminAuth(t *testing.T) {
+	s := newAdminTestServer(t)
+
+	tests := []struct {
+		name   string
+		header string
+		status int
+	}{
+		{"missing token", "", http.StatusUnauthorized},
+		{"wrong token", "Bearer wrong", http.StatusUnauthorized},
+		{"wrong scheme", "Basic " + testAdminSecret, http.StatusUnauthorized},
+		{"valid token", "Bearer " + testAdminSecret, http.StatusOK},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, "/api/streams", nil)
+			if tt.header != "" {
+				r.Header.Set("Authorization", tt.header)
+			}
+			w := httptest.NewRecorder()
+			s.adminServer.Handler.ServeHTTP(w, r)
+
+			if w.Code != tt.status {
+				t.Errorf("status = %d, expected %d", w.Code, tt.status)
+			}
+		})
+	}
+}
+
+func TestAdminClients(t *testing.T) {
+	s := newAdminTestServer(t)
+
+	w := adminRequest(t, s, http.MethodGet, "/api/clients", testAdminSecret)
+	if w.Code != http.StatusOK {
+		t.Fatalf("status = %d, expected %d", w.Code, http.StatusOK)
+	}
+
+	var clients []adminClient
+	decodeJSON(t, w, &clients)
+	if len(clients) != 1 || clients[0].Name != "living-room" {
+		t.Fatalf("clients = %+v, expected living-room", clients)
+	}
+	if playlists := clients[0].Playlists; len(playlists) != 1 || playlists[0].Name != "provider" || !playlists[0].Proxied {
+		t.Errorf("playlists = %+v, expected the proxied provider", playlists)
+	}
+}
+
+func TestAdminSemaphores(t *testing.T) {
+	s := newAdminTestServer(t)
+
+	w := adminRequest(t, s, http.MethodGet, "/api/semaphores", testAdminSecret)
+	if w.Code != http.StatusOK {
+		t.Fatalf("status = %d, expected %d", w.Code, http.StatusOK)
+	}
+
+	var sems adminSemaphores
+	decodeJSON(t, w, &sems)
+	if sems.Global == nil || sems.Global.Limit != 4 {
+		t.Errorf("global semaphore = %+v, expected limit 4", sems.Global)
+	}
+	client, ok := sems.Clients["living-room"]
+	if !ok {
+		t.Fatalf("clients = %+v, expected living-room", sems.Clients)
+	}
+	if sem := client.Playlists["provider"]; sem == nil || sem.Limit != 2 || sem.InUse != 0 {
+		t.Errorf("playlist semaphore = %+v, expected limit 2 and none in use", sem)
+	}
+}
+
+func TestAdminStreams(t *testing.T) {
+	s := newAdminTestServer(t)
+
+	w := adminRequest(t, s, http.MethodGet, "/api/streams", testAdminSecret)
+	var streams []demux.StreamInfo
+	decodeJSON(t, w, &streams)
+	if len(streams) != 0 {
+		t.Fatalf("streams = %+v, expected none", streams)
+	}
+
+	startTestStream(t, s, "stream", "living-room")
+
+	w = adminRequest(t, s, http.MethodGet, "/api/streams", testAdminSecret)
+	decodeJSON(t, w, &streams)
+	if len(streams) != 1 || streams[0].StreamKey != "stream" || len(streams[0].Subscribers) != 1 {
+		t.Errorf("streams = %+v, expected the stream with one subscriber", streams)
+	}
+}
+
+func TestAdminDeleteUnknown(t *testing.T) {
+	s := newAdminTestServer(t)
+	startTestStream(t, s, "stream", "living-room")
+
+	paths := []string{
+		"/api/streams/unknown",
+		"/api/streams/unknown/subscribers/unknown",
+		"/api/streams/stream/subscribers/unknown",
+	}
+	for _, path := range paths {
+		if w := adminRequest(t, s, http.MethodDelete, path, testAdminSecret); w.Code != http.StatusNotFound {
+			t.Errorf("DELETE %s status = %d, expected %d", path, w.Code, http.StatusNotFound)
+		}
+	}
+
+	w := adminRequest(t, s, http.MethodDelete, "/api/clients/unknown/sessions", testAdminSecret)
+	var result adminDisconnected
+	decodeJSON(t, w, &result)
+	if w.Code != http.StatusOK || result.Disconnected != 0 {
+		t.Errorf("status = %d, disconnected = %d, expected %d and 0", w.Code, result.Disconnected, http.StatusOK)
+	}
+
+	if streams := s.demux.Streams(); len(streams) != 1 || len(streams[0].Subscribers) != 1 {
+		t.Errorf("streams = %+v, expected the stream to keep running", streams)
+	}
+}
+
+func TestAdminStopStream(t *testing.T) {
+	s := newAdminTestServer(t)
+	reader := startTestStream(t, s, "stream", "living-room")
+
+	if w := adminRequest(t, s, http.MethodDelete, "/api/streams/stream", testAdminSecret); w.Code != http.StatusNoContent {
+		t.Fatalf("status = %d, expected %d", w.Code, http.StatusNoContent)
+	}
+	if err := readError(t, reader); !errors.Is(err, demux.ErrDisconnected) {
+		t.Errorf("Read() error = %v, expected %v", err, demux.ErrDisconnected)
+	}
+	if streams := s.demux.Streams(); len(streams) != 0 {
+		t.Errorf("streams = %+v, expected none", streams)
+	}
+}
+
+func TestAdminDisconnectSubscriber(t *testing.T) {
+	s := newAdminTestServer(t)
+	reader := startTestStream(t, s, "stream", "living-room")
+
+	id := s.demux.Streams()[0].Subscribers[0].ID
+	path := "/api/streams/stream/subscribers/" + id
+	if w := adminRequest(t, s, http.MethodDelete, path, testAdminSecret); w.Code != http.StatusNoContent {
+		t.Fatalf("status = %d, expected %d", w.Code, http.StatusNoContent)
+	}
+	if err := readError(t, reader); !errors.Is(err, demux.ErrDisconnected) {
+		t.Errorf("Read() error = %v, expected %v", err, demux.ErrDisconnected)
+	}
+}
+
+func TestAdminDisconnectClient(t *testing.T) {
+	s := newAdminTestServer(t)
+	first := startTestStream(t, s, "first", "living-room")
+	second := startTestStream(t, s, "second", "living-room")
+	startTestStream(t, s, "other", "bedroom")
+
+	w := adminRequest(t, s, http.MethodDelete, "/api/clients/living-room/sessions", testAdminSecret)
+	var result adminDisconnected
+	decodeJSON(t, w, &result)
+	if w.Code != http.StatusOK || result.Disconnected != 2 {
+		t.Errorf("status = %d, disconnected = %d, expected %d and 2", w.Code, result.Disconnected, http.StatusOK)
+	}
+
+	for _, reader := range []io.Reader{first, second} {
+		if err := readError(t, reader); !errors.Is(err, demux.ErrDisconnected) {
+			t.Errorf("Read() error = %v, expected %v", err, demux.ErrDisconnected)
+		}
+	}
+	if streams := s.demux.Streams(); len(streams) != 1 || streams[0].StreamKey != "other" {
+		t.Errorf("streams = %+v, expected only the stream of the other client", streams)
+	}
+}
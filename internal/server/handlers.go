@@ -4,6 +4,7 @@ import (
 	"context"
 	"crypto/sha256"
 	"encoding/hex"
+	"encoding/json"
 	"fmt"
 	"io"
 	"net/http"
@@ -31,6 +32,10 @@ var (
 		"Content-Type":  "application/xml",
 		"Cache-Control": "no-cache",
 	}
+	jsonHeaders = responseHeaders{
+		"Content-Type":  "application/json",
+		"Cache-Control": "no-cache",
+	}
 	epgGzipHeaders = responseHeaders{
 		"Content-Type":        "application/gzip",
 		"Cache-Control":       "no-cache",
@@ -126,6 +131,10 @@ func (s *Server) handleProxy(w http.ResponseWriter, r *http.Request) {
 	case urlgen.RequestTypeFile:
 		s.handleFileProxy(ctx, w, data)
 	case urlgen.RequestTypeStream:
+		if data.StreamData.HLS {
+			s.handleHLSProxy(ctx, w, r)
+			return
+		}
 		s.handleStreamProxy(ctx, w, r)
 	default:
 		logging.Error(ctx, nil, "invalid proxy request type", "type", data.RequestType)
@@ -201,6 +210,13 @@ func setHeaders(w http.ResponseWriter, headers responseHeaders) {
 	}
 }
 
+func writeJSON(w http.ResponseWriter, r *http.Request, v any) {
+	setHeaders(w, jsonHeaders)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		logging.Error(r.Context(), err, "failed to write json response")
+	}
+}
+
 func buildStreamURL(baseURL, rawQuery string) string {
 	if rawQuery != "" {
 		return baseURL + "?" + rawQuery
@@ -0,0 +1,171 @@
+package server
+
+import (
+	"encoding/xml"
+	"majmun/internal/app"
+	"majmun/internal/ctxutil"
+	"majmun/internal/hdhomerun"
+	"majmun/internal/listing/m3u8"
+	"majmun/internal/logging"
+	"majmun/internal/metrics"
+	"majmun/internal/utils"
+	"net/http"
+	"net/url"
+
+	"github.com/gorilla/mux"
+)
+
+// hdhomerunDevicePath is the prefix of the tuners announced over SSDP, followed by the device ID.
+const hdhomerunDevicePath = "/hdhr"
+
+var xmlHeaders = responseHeaders{
+	"Content-Type":  "application/xml",
+	"Cache-Control": "no-cache",
+}
+
+func (s *Server) setupHDHomeRunRoutes(router *mux.Router) {
+	router.HandleFunc("/discover.json", s.handleHDHomeRunDiscover)
+	router.HandleFunc("/lineup.json", s.handleHDHomeRunLineup)
+	router.HandleFunc("/lineup_status.json", s.handleHDHomeRunLineupStatus)
+	router.HandleFunc("/device.xml", s.handleHDHomeRunDevice)
+}
+
+func (s *Server) hdhomerunMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		client := ctxutil.Client(r.Context()).(*app.Client)
+		if !client.HDHomeRun().Enabled {
+			http.NotFound(w, r)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// hdhomerunDeviceMiddleware resolves the client of a tuner reached by the device ID it is announced with.
+func (s *Server) hdhomerunDeviceMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := mux.Vars(r)[muxDeviceIDVar]
+
+		for _, client := range s.manager.Load().Clients() {
+			if client.HDHomeRun().Enabled && hdhomerunDeviceID(client) == id {
+				next.ServeHTTP(w, r.WithContext(ctxutil.WithClient(r.Context(), client)))
+				return
+			}
+		}
+
+		logging.Debug(r.Context(), "hdhomerun device not found", "device_id", id)
+		http.NotFound(w, r)
+	})
+}
+
+func (s *Server) handleHDHomeRunDiscover(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, r, s.hdhomerunRequestDevice(r).Discover())
+}
+
+func (s *Server) handleHDHomeRunLineupStatus(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, r, s.hdhomerunRequestDevice(r).LineupStatus())
+}
+
+func (s *Server) handleHDHomeRunDevice(w http.ResponseWriter, r *http.Request) {
+	setHeaders(w, xmlHeaders)
+	_, _ = w.Write([]byte(xml.Header))
+
+	enc := xml.NewEncoder(w)
+	enc.Indent("", "  ")
+	if err := enc.Encode(s.hdhomerunRequestDevice(r).Description()); err != nil {
+		logging.Error(r.Context(), err, "failed to write device description")
+	}
+}
+
+func (s *Server) handleHDHomeRunLineup(w http.ResponseWriter, r *http.Request) {
+	ctx := ctxutil.WithRequestType(r.Context(), metrics.RequestTypePlaylist)
+	client := ctxutil.Client(ctx).(*app.Client)
+
+	logging.Debug(ctx, "hdhomerun lineup request")
+
+	streamer := m3u8.NewStreamer(
+		client.PlaylistProviders(),
+		client.EPGLink(),
+		s.httpClient,
+		client.ChannelProcessor(),
+		client.PlaylistProcessor(),
+	)
+
+	channels, err := streamer.Channels(ctx)
+	if err != nil {
+		logging.Error(ctx, err, "failed to build hdhomerun lineup")
+		http.Error(w, http.StatusText(http.StatusBadGateway), http.StatusBadGateway)
+		return
+	}
+
+	writeJSON(w, r, hdhomerun.NewLineup(channels))
+	metrics.IncListingDownload(ctx)
+}
+
+func (s *Server) hdhomerunDevice(m *app.Manager, client *app.Client) hdhomerun.Device {
+	name := client.HDHomeRun().FriendlyName
+	if name == "" {
+		name = "majmun " + client.Name()
+	}
+
+	return hdhomerun.Device{
+		ID:           hdhomerunDeviceID(client),
+		FriendlyName: name,
+		BaseURL:      client.HDHomeRunLink(),
+		TunerCount:   hdhomerunTunerCount(m, client),
+	}
+}
+
+// hdhomerunRequestDevice returns the device of the request client. A tuner reached by its device ID links to
+// the device path, so the client secret is not handed out to whoever found the tuner over SSDP.
+func (s *Server) hdhomerunRequestDevice(r *http.Request) hdhomerun.Device {
+	client := ctxutil.Client(r.Context()).(*app.Client)
+	device := s.hdhomerunDevice(s.manager.Load(), client)
+	if _, ok := mux.Vars(r)[muxDeviceIDVar]; ok {
+		device.BaseURL = s.hdhomerunDeviceURL(device.ID)
+	}
+	return device
+}
+
+func (s *Server) hdhomerunDeviceURL(id string) string {
+	return s.serverURL + hdhomerunDevicePath + "/" + url.PathEscape(id)
+}
+
+func hdhomerunDeviceID(client *app.Client) string {
+	if id := client.HDHomeRun().DeviceID; id != "" {
+		return id
+	}
+	return hdhomerun.DeviceID(client.Name())
+}
+
+// hdhomerunDevices lists the devices of all clients with HDHomeRun emulation enabled for SSDP announcements.
+func (s *Server) hdhomerunDevices() []hdhomerun.Device {
+	m := s.manager.Load()
+
+	var devices []hdhomerun.Device
+	for _, c := range m.Clients() {
+		if c.HDHomeRun().Enabled {
+			device := s.hdhomerunDevice(m, c)
+			device.BaseURL = s.hdhomerunDeviceURL(device.ID)
+			devices = append(devices, device)
+		}
+	}
+	return devices
+}
+
+func hdhomerunTunerCount(m *app.Manager, client *app.Client) int {
+	count := int64(0)
+	for _, sem := range []*utils.Semaphore{m.Semaphore(), client.Semaphore()} {
+		if sem == nil {
+			continue
+		}
+		if count == 0 || sem.Size() < count {
+			count = sem.Size()
+		}
+	}
+
+	if count == 0 {
+		return hdhomerun.DefaultTunerCount
+	}
+	return int(count)
+}
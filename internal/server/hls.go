@@ -0,0 +1,453 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"majmun/internal/app"
+	"majmun/internal/config/proxy"
+	"majmun/internal/ctxutil"
+	"majmun/internal/hls"
+	"majmun/internal/logging"
+	"majmun/internal/metrics"
+	"majmun/internal/urlgen"
+	"net"
+	"net/http"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	hlsMinSegments   = 2
+	hlsReadyTimeout  = 10 * time.Second
+	hlsReapInterval  = time.Second
+	hlsPlaylistExt   = ".m3u8"
+	hlsSegmentExt    = ".ts"
+	hlsSegmentKeySep = "-"
+
+	// hlsSessionClientName is the client name the upstream stream of a session is logged and measured under.
+	hlsSessionClientName = "hls"
+)
+
+type hlsSession struct {
+	key         string
+	stream      string
+	segmenter   *hls.Segmenter
+	idleTimeout time.Duration
+	cancel      context.CancelFunc
+	viewers     map[string]*hlsViewer
+}
+
+type hlsViewer struct {
+	ctx        context.Context
+	manager    *app.Manager
+	clientName string
+	lastSeen   time.Time
+}
+
+// hlsSessionClient is the identity the upstream stream of a session runs under. The stream is shared by the
+// viewers, so it belongs to none of them, and it runs with the highest client priority, since stopping it
+// stops every viewer.
+type hlsSessionClient struct {
+	priority int
+}
+
+func (c hlsSessionClient) Name() string {
+	return hlsSessionClientName
+}
+
+func (c hlsSessionClient) Priority() int {
+	return c.priority
+}
+
+// hlsRequest is a request for the media playlist or a segment of a session.
+type hlsRequest struct {
+	key     string
+	segment bool
+	seq     uint64
+}
+
+// parseHLSRequest returns the session a request is for. The playlist request names the session by the stream and
+// its query, while segments carry the session key in their name.
+func parseHLSRequest(r *http.Request, stream urlgen.Stream) (hlsRequest, bool) {
+	name := path.Base(r.URL.Path)
+	if strings.HasSuffix(name, hlsPlaylistExt) {
+		return hlsRequest{key: generateHash(stream.URL, r.URL.RawQuery, stream.ProxyProfile)}, true
+	}
+
+	key, seqStr, ok := strings.Cut(strings.TrimSuffix(name, hlsSegmentExt), hlsSegmentKeySep)
+	seq, err := strconv.ParseUint(seqStr, 10, 64)
+	if !ok || err != nil {
+		return hlsRequest{}, false
+	}
+	return hlsRequest{key: key, segment: true, seq: seq}, true
+}
+
+// hlsStreamID identifies the stream a session runs, so requests with a token of another stream are not served
+// from it.
+func hlsStreamID(stream urlgen.Stream) string {
+	return generateHash(stream.URL, stream.ProxyProfile)
+}
+
+// hlsStatusError ends a session whose stream got an error response instead of media, such as when no source
+// could be started, so the viewers get the same status.
+type hlsStatusError struct {
+	status int
+}
+
+func (e *hlsStatusError) Error() string {
+	return fmt.Sprintf("hls stream failed with status %d", e.status)
+}
+
+// hlsErrorStatus returns the status of the error response a session ended with, or 502 for any other failure.
+func hlsErrorStatus(err error) int {
+	var statusErr *hlsStatusError
+	if errors.As(err, &statusErr) {
+		return statusErr.status
+	}
+	return http.StatusBadGateway
+}
+
+// segmenterResponseWriter feeds the response of the session stream to the segmenter.
+// The body of an error response is not media and is discarded.
+type segmenterResponseWriter struct {
+	*hls.Segmenter
+	header http.Header
+	status int
+}
+
+func (w *segmenterResponseWriter) Header() http.Header {
+	return w.header
+}
+
+func (w *segmenterResponseWriter) WriteHeader(status int) {
+	if w.status == 0 {
+		w.status = status
+	}
+}
+
+func (w *segmenterResponseWriter) Write(p []byte) (int, error) {
+	w.WriteHeader(http.StatusOK)
+	if w.err() != nil {
+		return len(p), nil
+	}
+	return w.Segmenter.Write(p)
+}
+
+// err returns the error the session ends with when the stream got an error response.
+func (w *segmenterResponseWriter) err() error {
+	if w.status == 0 || (w.status >= 200 && w.status < 300) {
+		return nil
+	}
+	return &hlsStatusError{status: w.status}
+}
+
+func (s *Server) handleHLSProxy(ctx context.Context, w http.ResponseWriter, r *http.Request) {
+	data := ctxutil.StreamData(ctx).(*urlgen.Data)
+
+	ctx = ctxutil.WithRequestType(ctx, metrics.RequestTypePlaylist)
+	ctx = ctxutil.WithChannelName(ctx, data.StreamData.ChannelName)
+
+	if len(data.StreamData.Streams) == 0 {
+		http.Error(w, http.StatusText(http.StatusBadGateway), http.StatusBadGateway)
+		return
+	}
+
+	req, ok := parseHLSRequest(r, data.StreamData.Streams[0])
+	if !ok {
+		http.Error(w, http.StatusText(http.StatusNotFound), http.StatusNotFound)
+		return
+	}
+
+	if req.segment {
+		s.handleHLSSegment(ctx, w, r, req)
+		return
+	}
+	s.handleHLSPlaylist(ctx, w, r, req.key)
+}
+
+func (s *Server) handleHLSPlaylist(ctx context.Context, w http.ResponseWriter, r *http.Request, key string) {
+	logging.Debug(ctx, "hls playlist request")
+
+	client := ctxutil.Client(ctx).(*app.Client)
+	data := ctxutil.StreamData(ctx).(*urlgen.Data)
+
+	stream := data.StreamData.Streams[0]
+	playlist, ok := client.GetProvider(stream.ProviderInfo.ProviderType, stream.ProviderInfo.ProviderName).(*app.Playlist)
+	if !ok || playlist == nil {
+		logging.Error(ctx, errors.New("provider is not a playlist"), "")
+		http.Error(w, http.StatusText(http.StatusBadGateway), http.StatusBadGateway)
+		return
+	}
+	playlist = playlist.WithProxyProfile(stream.ProxyProfile)
+
+	session := s.joinHLSSession(ctx, r, key, playlist.ProxyConfig().HLS)
+	if session == nil {
+		logging.Error(ctx, errors.New("failed to acquire semaphores"), "")
+		http.Error(w, http.StatusText(http.StatusTooManyRequests), http.StatusTooManyRequests)
+		return
+	}
+
+	waitCtx, cancel := context.WithTimeout(ctx, hlsMinSegments*session.segmenter.TargetDuration()+hlsReadyTimeout)
+	defer cancel()
+
+	if err := session.segmenter.Wait(waitCtx, hlsMinSegments); err != nil {
+		logging.Error(ctx, err, "hls stream is not ready")
+		status := hlsErrorStatus(session.segmenter.Err())
+		http.Error(w, http.StatusText(status), status)
+		return
+	}
+
+	setHeaders(w, playlistHeaders)
+
+	err := session.segmenter.WritePlaylist(w, func(seq uint64) string {
+		return key + hlsSegmentKeySep + strconv.FormatUint(seq, 10) + hlsSegmentExt
+	})
+	if err != nil {
+		logging.Error(ctx, err, "failed to write hls playlist")
+	}
+}
+
+// handleHLSSegment serves a segment to a viewer of the session. Other requests get 404, so the player requests
+// the playlist again, which joins the session and takes the concurrency slots of the client.
+func (s *Server) handleHLSSegment(ctx context.Context, w http.ResponseWriter, r *http.Request, req hlsRequest) {
+	client := ctxutil.Client(ctx).(*app.Client)
+	data := ctxutil.StreamData(ctx).(*urlgen.Data)
+
+	s.hlsMu.Lock()
+	session, viewer := s.hlsViewer(req.key, client, data.StreamData.Streams[0], r)
+	if viewer != nil {
+		viewer.lastSeen = time.Now()
+	}
+	s.hlsMu.Unlock()
+
+	if viewer == nil {
+		logging.Debug(ctx, "hls session not found", "session", req.key)
+		http.Error(w, http.StatusText(http.StatusNotFound), http.StatusNotFound)
+		return
+	}
+
+	segment, ok := session.segmenter.Segment(req.seq)
+	if !ok {
+		logging.Debug(ctx, "hls segment not found", "session", req.key, "sequence", req.seq)
+		status := http.StatusNotFound
+		if err := session.segmenter.Err(); err != nil {
+			status = hlsErrorStatus(err)
+		}
+		http.Error(w, http.StatusText(status), status)
+		return
+	}
+
+	w.Header().Set("Content-Type", streamContentType)
+	w.Header().Set("Content-Length", strconv.Itoa(len(segment.Data)))
+
+	if _, err := w.Write(segment.Data); err != nil && !isClientDisconnect(err) {
+		logging.Error(ctx, err, "failed to write hls segment")
+	}
+}
+
+// hlsViewer returns the session with the key and the viewer the client has in it. The session must run the stream
+// of the token, and is not returned to clients that did not join it. It must be called with hlsMu held.
+func (s *Server) hlsViewer(
+	key string, client *app.Client, stream urlgen.Stream, r *http.Request) (*hlsSession, *hlsViewer) {
+	session := s.hlsSessions[key]
+	if session == nil || session.stream != hlsStreamID(stream) {
+		return nil, nil
+	}
+
+	viewer := session.viewers[hlsViewerKey(client, r)]
+	if viewer == nil {
+		return nil, nil
+	}
+	return session, viewer
+}
+
+// watchingHLS reports whether the request is for the playlist or a segment of a live session the client watches.
+// These requests are served after the link expired, so stream_ttl does not cut off playback.
+func (s *Server) watchingHLS(client *app.Client, data *urlgen.Data, r *http.Request) bool {
+	if !data.StreamData.HLS || len(data.StreamData.Streams) == 0 {
+		return false
+	}
+
+	stream := data.StreamData.Streams[0]
+	req, ok := parseHLSRequest(r, stream)
+	if !ok {
+		return false
+	}
+
+	s.hlsMu.Lock()
+	defer s.hlsMu.Unlock()
+
+	_, viewer := s.hlsViewer(req.key, client, stream, r)
+	return viewer != nil
+}
+
+func (s *Server) joinHLSSession(ctx context.Context, r *http.Request, key string, cfg proxy.HLS) *hlsSession {
+	client := ctxutil.Client(ctx).(*app.Client)
+	viewerKey := hlsViewerKey(client, r)
+
+	data := ctxutil.StreamData(ctx).(*urlgen.Data)
+	stream := data.StreamData.Streams[0]
+
+	s.hlsMu.Lock()
+	if session, viewer := s.hlsViewer(key, client, stream, r); viewer != nil {
+		viewer.lastSeen = time.Now()
+		s.hlsMu.Unlock()
+		return session
+	}
+	s.hlsMu.Unlock()
+
+	manager := s.manager.Load()
+	if !s.acquireSemaphores(ctx, manager) {
+		return nil
+	}
+
+	viewer := &hlsViewer{
+		ctx:        context.WithoutCancel(ctx),
+		manager:    manager,
+		clientName: client.Name(),
+		lastSeen:   time.Now(),
+	}
+
+	s.hlsMu.Lock()
+	defer s.hlsMu.Unlock()
+
+	session := s.hlsSessions[key]
+	if session == nil {
+		session = s.startHLSSession(ctx, r, manager, key, cfg)
+		s.hlsSessions[key] = session
+	}
+
+	if existing := session.viewers[viewerKey]; existing != nil {
+		existing.lastSeen = viewer.lastSeen
+		s.releaseSemaphores(viewer.ctx, manager)
+		return session
+	}
+
+	session.viewers[viewerKey] = viewer
+	logging.Info(ctx, "hls viewer joined", "session", key)
+
+	return session
+}
+
+// startHLSSession starts the upstream stream of a session. It runs under the server context with its own request ID
+// and identity instead of the request that started it, since it outlives that request and serves every viewer.
+func (s *Server) startHLSSession(
+	ctx context.Context, r *http.Request, manager *app.Manager, key string, cfg proxy.HLS) *hlsSession {
+	client := ctxutil.Client(ctx).(*app.Client)
+	data := ctxutil.StreamData(ctx).(*urlgen.Data)
+
+	priority := client.Priority()
+	for _, c := range manager.Clients() {
+		priority = max(priority, c.Priority())
+	}
+
+	sessionCtx := ctxutil.WithRequestID(s.ctx)
+	sessionCtx = ctxutil.WithClient(sessionCtx, hlsSessionClient{priority: priority})
+	sessionCtx = ctxutil.WithStreamData(sessionCtx, data)
+	sessionCtx = ctxutil.WithProviderType(sessionCtx, ctxutil.ProviderType(ctx))
+	sessionCtx = ctxutil.WithProviderName(sessionCtx, ctxutil.ProviderName(ctx))
+	sessionCtx = ctxutil.WithRequestType(sessionCtx, metrics.RequestTypePlaylist)
+	sessionCtx = ctxutil.WithChannelName(sessionCtx, data.StreamData.ChannelName)
+	sessionCtx, cancel := context.WithCancel(sessionCtx)
+
+	session := &hlsSession{
+		key:         key,
+		stream:      hlsStreamID(data.StreamData.Streams[0]),
+		segmenter:   hls.NewSegmenter(time.Duration(cfg.SegmentDuration), cfg.PlaylistSize),
+		idleTimeout: time.Duration(cfg.IdleTimeout),
+		cancel:      cancel,
+		viewers:     make(map[string]*hlsViewer),
+	}
+
+	go func() {
+		logging.Info(sessionCtx, "started hls session", "session", key)
+
+		w := &segmenterResponseWriter{Segmenter: session.segmenter, header: make(http.Header)}
+		s.serveStream(sessionCtx, w, r.WithContext(sessionCtx), manager, client, data, nil)
+
+		err := w.err()
+		if err == nil {
+			err = sessionCtx.Err()
+		}
+		session.segmenter.Close(err)
+		s.endHLSSession(session)
+
+		logging.Info(sessionCtx, "hls session ended", "session", key)
+	}()
+
+	return session
+}
+
+func (s *Server) endHLSSession(session *hlsSession) {
+	s.hlsMu.Lock()
+	defer s.hlsMu.Unlock()
+
+	if s.hlsSessions[session.key] == session {
+		delete(s.hlsSessions, session.key)
+	}
+
+	for viewerKey, viewer := range session.viewers {
+		s.releaseSemaphores(viewer.ctx, viewer.manager)
+		delete(session.viewers, viewerKey)
+	}
+
+	session.cancel()
+}
+
+func (s *Server) reapHLSSessions() {
+	ticker := time.NewTicker(hlsReapInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-s.ctx.Done():
+			return
+		case now := <-ticker.C:
+			s.expireHLSViewers(func(v *hlsViewer, session *hlsSession) bool {
+				return now.Sub(v.lastSeen) > session.idleTimeout
+			})
+		}
+	}
+}
+
+func (s *Server) disconnectHLSClient(clientName string) int {
+	return s.expireHLSViewers(func(v *hlsViewer, _ *hlsSession) bool {
+		return v.clientName == clientName
+	})
+}
+
+func (s *Server) expireHLSViewers(expired func(*hlsViewer, *hlsSession) bool) int {
+	s.hlsMu.Lock()
+	defer s.hlsMu.Unlock()
+
+	var n int
+	for key, session := range s.hlsSessions {
+		for viewerKey, viewer := range session.viewers {
+			if !expired(viewer, session) {
+				continue
+			}
+			s.releaseSemaphores(viewer.ctx, viewer.manager)
+			delete(session.viewers, viewerKey)
+			logging.Info(viewer.ctx, "hls viewer left", "session", key)
+			n++
+		}
+
+		if len(session.viewers) == 0 {
+			delete(s.hlsSessions, key)
+			session.cancel()
+		}
+	}
+
+	return n
+}
+
+func hlsViewerKey(client *app.Client, r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	return fmt.Sprintf("%s/%s", client.Name(), host)
+}
@@ -0,0 +1,108 @@
+package server
+
+import (
+	"context"
+	"majmun/internal/ctxutil"
+	"majmun/internal/hls"
+	"majmun/internal/metrics"
+	"majmun/internal/urlgen"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestSegmenterResponseWriterErrorStatus(t *testing.T) {
+	w := &segmenterResponseWriter{Segmenter: hls.NewSegmenter(time.Second, 6), header: make(http.Header)}
+	w.WriteHeader(http.StatusServiceUnavailable)
+	w.WriteHeader(http.StatusOK)
+
+	body := []byte("Service Unavailable\n")
+	if n, err := w.Write(body); n != len(body) || err != nil {
+		t.Errorf("Write() = %d, %v, expected %d, nil", n, err, len(body))
+	}
+
+	if status := hlsErrorStatus(w.err()); status != http.StatusServiceUnavailable {
+		t.Errorf("error status = %d, expected %d", status, http.StatusServiceUnavailable)
+	}
+}
+
+func TestSegmenterResponseWriterSuccess(t *testing.T) {
+	w := &segmenterResponseWriter{Segmenter: hls.NewSegmenter(time.Second, 6), header: make(http.Header)}
+	if _, err := w.Write([]byte("data")); err != nil {
+		t.Fatalf("Write() error = %v", err)
+	}
+	if err := w.err(); err != nil {
+		t.Errorf("err() = %v, expected nil", err)
+	}
+}
+
+func TestHLSSessionUpstreamFailsToStart(t *testing.T) {
+	s := newTestServer(t, `server:
+  listen_addr: ":8080"
+  public_url: "http://example.com"
+url_generator:
+  secret: "test-secret"
+proxy:
+  enabled: true
+  output: hls
+  stream:
+    command: ["false"]
+  error:
+    command: ["false"]
+playlists:
+  - name: provider
+    sources: ["http://provider.com/playlist.m3u"]
+clients:
+  - name: living-room
+    secret: "client-secret"
+    proxy:
+      concurrency: 1
+    playlists: ["provider"]`)
+
+	client := s.manager.Load().Client("client-secret")
+	data := &urlgen.Data{
+		RequestType: urlgen.RequestTypeStream,
+		StreamData: urlgen.StreamData{
+			ChannelName: "channel",
+			HLS:         true,
+			Streams: []urlgen.Stream{{
+				ProviderInfo: urlgen.ProviderInfo{ProviderType: urlgen.ProviderTypePlaylist, ProviderName: "provider"},
+				URL:          "http://provider.com/channel.ts",
+			}},
+		},
+	}
+
+	ctx := ctxutil.WithRequestID(context.Background())
+	ctx = ctxutil.WithClient(ctx, client)
+	ctx = ctxutil.WithStreamData(ctx, data)
+	ctx = ctxutil.WithProviderType(ctx, metrics.RequestTypePlaylist)
+	ctx = ctxutil.WithProviderName(ctx, "provider")
+
+	start := time.Now()
+	w := httptest.NewRecorder()
+	s.handleHLSProxy(ctx, w, httptest.NewRequest(http.MethodGet, "/token/channel.m3u8", nil))
+
+	if w.Code != http.StatusBadGateway {
+		t.Errorf("status = %d, expected %d", w.Code, http.StatusBadGateway)
+	}
+	if elapsed := time.Since(start); elapsed > hlsReadyTimeout {
+		t.Errorf("playlist request took %v, expected to fail without waiting for the ready timeout", elapsed)
+	}
+
+	deadline := time.Now().Add(3 * time.Second)
+	for {
+		s.hlsMu.Lock()
+		sessions := len(s.hlsSessions)
+		s.hlsMu.Unlock()
+
+		if sessions == 0 && client.Semaphore().InUse() == 0 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("session not ended in time, sessions = %d, slots in use = %d",
+				sessions, client.Semaphore().InUse())
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
@@ -5,6 +5,7 @@ import (
 	"majmun/internal/app"
 	"majmun/internal/ctxutil"
 	"majmun/internal/logging"
+	"majmun/internal/shell"
 	"majmun/internal/urlgen"
 	"net/http"
 	"time"
@@ -39,7 +40,7 @@ func (s *Server) clientAuthMiddleware(next http.Handler) http.Handler {
 			return
 		}
 
-		client := s.manager.Client(secret)
+		client := s.manager.Load().Client(secret)
 		if client == nil {
 			logging.Debug(r.Context(), "authentication failed: invalid secret", "secret", secret)
 			http.Error(w, http.StatusText(http.StatusForbidden), http.StatusForbidden)
@@ -62,9 +63,14 @@ func (s *Server) proxyAuthMiddleware(next http.Handler) http.Handler {
 
 		ctx := r.Context()
 
-		for _, client := range s.manager.Clients() {
+		for _, client := range s.manager.Load().Clients() {
 			data, err := client.URLGenerator().Decrypt(token)
 
+			// A viewer of a live HLS session keeps playing after the link expired.
+			if errors.Is(err, urlgen.ErrExpiredStreamURL) && s.watchingHLS(client, data, r) {
+				err = nil
+			}
+
 			if err == nil && data != nil {
 				provider := s.getProviderFromData(client, data)
 				if provider == nil {
@@ -81,9 +87,13 @@ func (s *Server) proxyAuthMiddleware(next http.Handler) http.Handler {
 
 			if errors.Is(err, urlgen.ErrExpiredStreamURL) {
 				provider := s.getProviderFromData(client, data)
-				if provider != nil && provider.ExpiredLinkStreamer() != nil {
+				var streamer *shell.Streamer
+				if provider != nil {
+					streamer = provider.ExpiredLinkStreamer(streamVars(r, client, data, 0))
+				}
+				if streamer != nil {
 					ctx = ctxutil.WithClient(ctx, client)
-					_, err := provider.ExpiredLinkStreamer().Stream(ctx, w)
+					_, err := streamer.Stream(ctx, w)
 					if err != nil {
 						logging.Error(ctx, err, "failed to stream expired link response")
 					}
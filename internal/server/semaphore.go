@@ -2,55 +2,60 @@ package server
 
 import (
 	"context"
-	"fmt"
 	"majmun/internal/app"
 	"majmun/internal/ctxutil"
 	"majmun/internal/metrics"
 	"majmun/internal/utils"
 	"time"
-
-	"golang.org/x/sync/errgroup"
-	"golang.org/x/sync/semaphore"
 )
 
 const (
 	semaphoreTimeout = 3 * time.Second
 )
 
-func (s *Server) acquireSemaphores(ctx context.Context) bool {
+// acquireSemaphores takes a client slot and then a global one, so a client waiting on its own limit
+// does not hold a global slot meanwhile. A slot already taken is released if the next one fails.
+func (s *Server) acquireSemaphores(ctx context.Context, m *app.Manager) bool {
 	c := ctxutil.Client(ctx).(*app.Client)
 
-	g, gCtx := errgroup.WithContext(ctx)
+	clientSem := c.Semaphore()
+	if !utils.AcquireSemaphore(ctx, clientSem, semaphoreTimeout, metrics.FailureReasonClientLimit) {
+		metrics.IncStreamsFailures(ctx, metrics.FailureReasonClientLimit)
+		return false
+	}
 
-	acquireSem := func(sem *semaphore.Weighted, reason string) func() error {
-		return func() error {
-			if sem == nil || utils.AcquireSemaphore(gCtx, sem, semaphoreTimeout, reason) {
-				return nil
-			}
-			metrics.IncStreamsFailures(ctx, reason)
-			return fmt.Errorf("failed to acquire semaphore: %s", reason)
+	if !s.acquireGlobalSemaphore(ctx, c, m.Semaphore()) {
+		metrics.IncStreamsFailures(ctx, metrics.FailureReasonGlobalLimit)
+		if clientSem != nil {
+			clientSem.Release()
 		}
+		return false
 	}
 
-	if managerSem := s.manager.Semaphore(); managerSem != nil {
-		g.Go(acquireSem(managerSem, metrics.FailureReasonGlobalLimit))
-	}
+	return true
+}
 
-	if clientSem := c.Semaphore(); clientSem != nil {
-		g.Go(acquireSem(clientSem, metrics.FailureReasonClientLimit))
+// acquireGlobalSemaphore waits for a global slot. A client allowed to preempt first disconnects
+// the subscriber with the lowest priority when no slot is free, and then waits for the slot it frees.
+func (s *Server) acquireGlobalSemaphore(ctx context.Context, c *app.Client, sem *utils.Semaphore) bool {
+	if sem != nil && c.Preempt() {
+		if sem.TryAcquire() {
+			return true
+		}
+		s.demux.PreemptSubscriber(ctx)
 	}
 
-	return g.Wait() == nil
+	return utils.AcquireSemaphore(ctx, sem, semaphoreTimeout, metrics.FailureReasonGlobalLimit)
 }
 
-func (s *Server) releaseSemaphores(ctx context.Context) {
+func (s *Server) releaseSemaphores(ctx context.Context, m *app.Manager) {
 	c := ctxutil.Client(ctx).(*app.Client)
 
-	if managerSem := s.manager.Semaphore(); managerSem != nil {
-		managerSem.Release(1)
+	if managerSem := m.Semaphore(); managerSem != nil {
+		managerSem.Release()
 	}
 
 	if clientSem := c.Semaphore(); clientSem != nil {
-		clientSem.Release(1)
+		clientSem.Release()
 	}
 }
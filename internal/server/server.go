@@ -7,9 +7,14 @@ import (
 	"majmun/internal/cache"
 	"majmun/internal/config"
 	"majmun/internal/demux"
+	"majmun/internal/hdhomerun"
 	"majmun/internal/logging"
 	"majmun/internal/metrics"
+	"majmun/internal/refresh"
 	"net/http"
+	"reflect"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/gorilla/mux"
@@ -19,28 +24,37 @@ import (
 const (
 	muxClientSecretVar   = "client_secret"
 	muxEncryptedTokenVar = "encrypted_token"
+	muxDeviceIDVar       = "device_id"
 )
 
 type Server struct {
 	router  *mux.Router
 	server  *http.Server
-	manager *app.Manager
+	manager atomic.Pointer[app.Manager]
+
+	config   *config.Config
+	reloadMu sync.Mutex
 
 	cache      *cache.Cache
 	httpClient *http.Client
+	refresher  *refresh.Scheduler
 
 	demux *demux.Demuxer
 
+	hlsSessions map[string]*hlsSession
+	hlsMu       sync.Mutex
+
 	serverURL     string
 	listenAddr    string
 	metricsServer *http.Server
+	adminServer   *http.Server
 
 	ctx    context.Context
 	cancel context.CancelFunc
 }
 
 func NewServer(cfg *config.Config) (*Server, error) {
-	m, err := app.NewManager(cfg)
+	m, err := app.NewManager(cfg, nil)
 	if err != nil {
 		return nil, err
 	}
@@ -53,27 +67,49 @@ func NewServer(cfg *config.Config) (*Server, error) {
 	ctx, cancel := context.WithCancel(context.Background())
 
 	server := &Server{
-		router:     mux.NewRouter(),
-		manager:    m,
-		cache:      c,
-		httpClient: c.NewCachedHTTPClient(),
-		demux:      demux.NewDemuxer(),
-		serverURL:  cfg.Server.PublicURL.String(),
-		listenAddr: cfg.Server.ListenAddr,
-		ctx:        ctx,
-		cancel:     cancel,
+		router:      mux.NewRouter(),
+		config:      cfg,
+		cache:       c,
+		httpClient:  c.NewCachedHTTPClient(),
+		refresher:   refresh.NewScheduler(c.NewRefreshingHTTPClient()),
+		demux:       demux.NewDemuxer(),
+		hlsSessions: make(map[string]*hlsSession),
+		serverURL:   cfg.Server.PublicURL.String(),
+		listenAddr:  cfg.Server.ListenAddr,
+		ctx:         ctx,
+		cancel:      cancel,
 	}
+	server.manager.Store(m)
 
 	if cfg.Server.MetricsAddr != "" {
 		server.setupMetricsServer(cfg.Server.MetricsAddr)
 	}
 
+	if cfg.Server.AdminAddr != "" {
+		server.setupAdminServer(cfg.Server.AdminAddr, cfg.Server.AdminSecret)
+	}
+
 	return server, nil
 }
 
 func (s *Server) Start() error {
 	s.setupRoutes()
 
+	go s.reapHLSSessions()
+
+	s.reloadMu.Lock()
+	s.refresher.Update(s.ctx, refresh.Sources(s.config), s.config.Refresh)
+	s.reloadMu.Unlock()
+
+	if s.config.Server.SSDP {
+		go func() {
+			logging.Info(s.ctx, "starting ssdp responder")
+			if err := hdhomerun.NewResponder(s.hdhomerunDevices).Run(s.ctx); err != nil {
+				logging.Error(s.ctx, err, "ssdp responder failed")
+			}
+		}()
+	}
+
 	if s.metricsServer != nil {
 		go func() {
 			logging.Info(s.ctx, "starting metrics server", "address", s.metricsServer.Addr)
@@ -83,6 +119,15 @@ func (s *Server) Start() error {
 		}()
 	}
 
+	if s.adminServer != nil {
+		go func() {
+			logging.Info(s.ctx, "starting admin server", "address", s.adminServer.Addr)
+			if err := s.adminServer.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+				logging.Error(s.ctx, err, "admin server failed")
+			}
+		}()
+	}
+
 	s.server = &http.Server{
 		Addr:    s.listenAddr,
 		Handler: s.router,
@@ -99,12 +144,34 @@ func (s *Server) Start() error {
 	return nil
 }
 
+func (s *Server) Reload(cfg *config.Config) error {
+	s.reloadMu.Lock()
+	defer s.reloadMu.Unlock()
+
+	m, err := app.NewManager(cfg, s.manager.Load())
+	if err != nil {
+		return err
+	}
+
+	if !reflect.DeepEqual(cfg.Server, s.config.Server) || !reflect.DeepEqual(cfg.Cache, s.config.Cache) {
+		logging.Info(s.ctx, "server and cache changes are applied only after restart")
+	}
+
+	s.manager.Store(m)
+	s.config = cfg
+	s.refresher.Update(s.ctx, refresh.Sources(cfg), cfg.Refresh)
+
+	logging.Info(s.ctx, "configuration reloaded", "clients", len(m.Clients()))
+	return nil
+}
+
 func (s *Server) Stop() error {
 	s.cancel()
 
 	ctx, cancel := context.WithTimeout(s.ctx, 5*time.Second)
 	defer cancel()
 
+	s.refresher.Stop()
 	s.cache.Close()
 	s.demux.Stop()
 
@@ -122,6 +189,14 @@ func (s *Server) Stop() error {
 		}
 	}
 
+	if s.adminServer != nil {
+		logging.Info(ctx, "stopping admin server")
+		if err := s.adminServer.Shutdown(ctx); err != nil {
+			logging.Error(ctx, err, "admin server shutdown timeout, force closing connections")
+			_ = s.adminServer.Close()
+		}
+	}
+
 	logging.Info(ctx, "server stopped")
 	return nil
 }
@@ -136,12 +211,25 @@ func (s *Server) setupRoutes() {
 	s.router.Use(s.requestIDMiddleware)
 	s.router.Use(s.loggerMiddleware)
 
+	s.setupXtreamRoutes()
+
+	// Tuners announced over SSDP are reached by device ID, so the announcements do not contain the client secret.
+	if s.config.Server.SSDP {
+		deviceRouter := s.router.PathPrefix(hdhomerunDevicePath + "/{" + muxDeviceIDVar + "}").Subrouter()
+		deviceRouter.Use(s.hdhomerunDeviceMiddleware)
+		s.setupHDHomeRunRoutes(deviceRouter)
+	}
+
 	clientRouter := s.router.PathPrefix("/{" + muxClientSecretVar + "}").Subrouter()
 	clientRouter.Use(s.clientAuthMiddleware)
 	clientRouter.HandleFunc("/playlist.m3u8", s.handlePlaylist)
 	clientRouter.HandleFunc("/epg.xml", s.handleEPG)
 	clientRouter.HandleFunc("/epg.xml.gz", s.handleEPGgz)
 
+	hdhomerunRouter := clientRouter.PathPrefix("/hdhr").Subrouter()
+	hdhomerunRouter.Use(s.hdhomerunMiddleware)
+	s.setupHDHomeRunRoutes(hdhomerunRouter)
+
 	proxyRouter := s.router.PathPrefix("/{" + muxEncryptedTokenVar + "}").Subrouter()
 	proxyRouter.Use(s.proxyAuthMiddleware)
 	proxyRouter.HandleFunc("/{.*}", s.handleProxy)
@@ -1,10 +1,13 @@
 package server
 
 import (
+	"bytes"
 	"context"
 	"errors"
 	"io"
 	"net/http"
+	"strings"
+	"sync"
 	"syscall"
 	"time"
 
@@ -13,6 +16,7 @@ import (
 	"majmun/internal/demux"
 	"majmun/internal/logging"
 	"majmun/internal/metrics"
+	"majmun/internal/probe"
 	"majmun/internal/urlgen"
 )
 
@@ -25,13 +29,16 @@ type streamResult struct {
 	success         bool
 	isLimitError    bool
 	isUpstreamError bool
+	isPreempted     bool
 }
 
 type allStreamsResult struct {
 	success          bool
 	hasLimitError    bool
 	hasUpstreamError bool
+	preempted        bool
 	defaultProvider  *app.Playlist
+	defaultIndex     int
 }
 
 func (s *Server) handleStreamProxy(ctx context.Context, w http.ResponseWriter, r *http.Request) {
@@ -39,30 +46,64 @@ func (s *Server) handleStreamProxy(ctx context.Context, w http.ResponseWriter, r
 
 	client := ctxutil.Client(ctx).(*app.Client)
 	data := ctxutil.StreamData(ctx).(*urlgen.Data)
+	manager := s.manager.Load()
 
 	ctx = ctxutil.WithRequestType(ctx, metrics.RequestTypePlaylist)
 	ctx = ctxutil.WithChannelName(ctx, data.StreamData.ChannelName)
 
-	if !s.acquireSemaphores(ctx) {
+	firstPlaylist := firstPlaylist(client, data)
+
+	// Clients queued for a slot get the waiting response until their stream starts.
+	if firstPlaylist != nil && firstPlaylist.WaitingSlate() {
+		slate := &waitingSlate{
+			ctx:      ctx,
+			w:        w,
+			streamer: firstPlaylist.WaitingStreamer(streamVars(r, client, data, 0)),
+		}
+		defer slate.stop()
+
+		ctx = ctxutil.WithWaitNotify(ctx, slate.start)
+		w = &slateResponseWriter{ResponseWriter: w, slate: slate}
+	}
+
+	if !s.acquireSemaphores(ctx, manager) {
 		logging.Error(ctx, errors.New("failed to acquire semaphores"), "")
-		if len(data.StreamData.Streams) > 0 {
-			firstProvider := client.GetProvider(
-				data.StreamData.Streams[0].ProviderInfo.ProviderType,
-				data.StreamData.Streams[0].ProviderInfo.ProviderName,
-			)
-			if playlist, ok := firstProvider.(*app.Playlist); ok && playlist != nil {
-				_, err := playlist.LimitStreamer().Stream(ctx, w)
-				if err != nil {
-					logging.Error(ctx, err, "failed to stream limit response")
-				}
-				return
+		if firstPlaylist != nil {
+			_, err := firstPlaylist.LimitStreamer(streamVars(r, client, data, 0)).Stream(ctx, w)
+			if err != nil {
+				logging.Error(ctx, err, "failed to stream limit response")
 			}
+			return
 		}
 		http.Error(w, http.StatusText(http.StatusServiceUnavailable), http.StatusServiceUnavailable)
 		return
 	}
-	defer s.releaseSemaphores(ctx)
+	release := sync.OnceFunc(func() { s.releaseSemaphores(ctx, manager) })
+	defer release()
+
+	s.serveStream(ctx, w, r, manager, client, data, release)
+}
+
+// firstPlaylist returns the playlist of the first channel source, which provides the error responses.
+func firstPlaylist(client *app.Client, data *urlgen.Data) *app.Playlist {
+	if len(data.StreamData.Streams) == 0 {
+		return nil
+	}
 
+	stream := data.StreamData.Streams[0]
+	playlist, ok := client.GetProvider(stream.ProviderInfo.ProviderType, stream.ProviderInfo.ProviderName).(*app.Playlist)
+	if !ok || playlist == nil {
+		return nil
+	}
+	return playlist.WithProxyProfile(stream.ProxyProfile)
+}
+
+// serveStream tries the sources of the channel and falls back to the error responses.
+// A preempted client gives its slots back through release, if set, before getting the preempted response.
+// The manager is the one the request took its slots from, so a reload does not mix limits of two configs.
+func (s *Server) serveStream(
+	ctx context.Context, w http.ResponseWriter, r *http.Request, manager *app.Manager, client *app.Client,
+	data *urlgen.Data, release func()) {
 	var lastResult allStreamsResult
 
 	for attempt := 0; attempt < maxRetryAttempts; attempt++ {
@@ -71,16 +112,32 @@ func (s *Server) handleStreamProxy(ctx context.Context, w http.ResponseWriter, r
 			time.Sleep(retryTimeout)
 		}
 
-		result := s.tryAllStreams(ctx, w, r, client, data)
+		result := s.tryAllStreams(ctx, w, r, manager, client, data)
 		if result.success {
 			return
 		}
 
 		lastResult = result
+		if result.preempted {
+			break
+		}
+	}
+
+	vars := streamVars(r, client, data, lastResult.defaultIndex)
+
+	if lastResult.preempted && lastResult.defaultProvider != nil {
+		if release != nil {
+			release()
+		}
+		_, err := lastResult.defaultProvider.PreemptedStreamer(vars).Stream(ctx, w)
+		if err != nil {
+			logging.Error(ctx, err, "failed to stream preempted response")
+		}
+		return
 	}
 
 	if lastResult.hasLimitError && lastResult.defaultProvider != nil {
-		_, err := lastResult.defaultProvider.LimitStreamer().Stream(ctx, w)
+		_, err := lastResult.defaultProvider.LimitStreamer(vars).Stream(ctx, w)
 		if err != nil {
 			logging.Error(ctx, err, "failed to stream limit response")
 		}
@@ -88,7 +145,7 @@ func (s *Server) handleStreamProxy(ctx context.Context, w http.ResponseWriter, r
 	}
 
 	if lastResult.hasUpstreamError && lastResult.defaultProvider != nil {
-		_, err := lastResult.defaultProvider.UpstreamErrorStreamer().Stream(ctx, w)
+		_, err := lastResult.defaultProvider.UpstreamErrorStreamer(vars).Stream(ctx, w)
 		if err != nil {
 			logging.Error(ctx, err, "failed to stream upstream error response")
 		}
@@ -99,10 +156,12 @@ func (s *Server) handleStreamProxy(ctx context.Context, w http.ResponseWriter, r
 }
 
 func (s *Server) tryAllStreams(
-	ctx context.Context, w http.ResponseWriter, r *http.Request, client *app.Client, data *urlgen.Data) allStreamsResult {
+	ctx context.Context, w http.ResponseWriter, r *http.Request, manager *app.Manager, client *app.Client,
+	data *urlgen.Data) allStreamsResult {
 	var hasLimitError bool
 	var hasUpstreamError bool
 	var firstProvider *app.Playlist
+	var firstIndex int
 
 	for i, stream := range data.StreamData.Streams {
 		logging.Debug(ctx, "trying stream source", "index", i)
@@ -120,15 +179,21 @@ func (s *Server) tryAllStreams(
 			logging.Error(ctx, errors.New("provider is not a playlist"), "stream_index", i)
 			continue
 		}
+		playlist = playlist.WithProxyProfile(stream.ProxyProfile)
 
 		if firstProvider == nil {
 			firstProvider = playlist
+			firstIndex = i
 		}
 
-		result := s.tryStream(ctx, w, r, playlist, stream, i)
+		result := s.tryStream(ctx, w, r, manager, client, playlist, data, i)
 
 		if result.success {
-			return allStreamsResult{true, false, false, nil}
+			return allStreamsResult{true, false, false, false, nil, 0}
+		}
+
+		if result.isPreempted {
+			return allStreamsResult{false, false, false, true, firstProvider, firstIndex}
 		}
 
 		if result.isLimitError {
@@ -139,48 +204,145 @@ func (s *Server) tryAllStreams(
 	}
 
 	return allStreamsResult{
-		false, hasLimitError, hasUpstreamError, firstProvider}
+		false, hasLimitError, hasUpstreamError, false, firstProvider, firstIndex}
 }
 
 func (s *Server) tryStream(
 	ctx context.Context,
-	w http.ResponseWriter, r *http.Request,
-	playlist *app.Playlist, stream urlgen.Stream, streamIndex int) streamResult {
+	w http.ResponseWriter, r *http.Request, manager *app.Manager,
+	client *app.Client, playlist *app.Playlist, data *urlgen.Data, streamIndex int) streamResult {
+
+	stream := data.StreamData.Streams[streamIndex]
 
 	ctx = ctxutil.WithChannelHidden(ctx, stream.Hidden)
 	ctx = ctxutil.WithProviderType(ctx, metrics.RequestTypePlaylist)
 	ctx = ctxutil.WithProviderName(ctx, playlist.Name())
 
-	streamURL := buildStreamURL(stream.URL, r.URL.RawQuery)
 	streamKey := buildStreamKey(stream.URL, r.URL.RawQuery)
+	if stream.ProxyProfile != "" {
+		streamKey = generateHash(streamKey, stream.ProxyProfile)
+	}
 
-	streamSource := playlist.LinkStreamer(streamURL)
+	streamSource := playlist.LinkStreamer(streamVars(r, client, data, streamIndex))
 	if streamSource == nil {
 		logging.Error(ctx,
 			errors.New("failed to create stream source"), "stream_index", streamIndex)
 
-		return streamResult{false, false, false}
+		return streamResult{false, false, false, false}
+	}
+
+	// Commands that render differently for other clients or requests must not share a stream.
+	if cmd, ok := streamSource.(interface{ Command() ([]string, error) }); ok {
+		if parts, err := cmd.Command(); err == nil {
+			streamKey = generateHash(streamKey, strings.Join(parts, "\x00"))
+		}
 	}
 
+	proxyConfig := playlist.ProxyConfig()
 	demuxReq := demux.Request{
-		StreamKey: streamKey,
-		Streamer:  streamSource,
-		Semaphore: playlist.Semaphore(),
+		StreamKey:  streamKey,
+		Streamer:   streamSource,
+		Semaphore:  playlist.Semaphore(),
+		Group:      manager.ConcurrencyGroup(stream.Group),
+		Preempt:    proxyConfig.IsPreemptEnabled(),
+		Linger:     time.Duration(proxyConfig.Linger),
+		BufferSize: int(proxyConfig.ReplayBuffer),
+		SlowClient: demux.SlowClient{
+			Policy:  demux.SlowClientPolicy(proxyConfig.SlowClient.Policy),
+			Timeout: time.Duration(proxyConfig.SlowClient.Timeout),
+		},
+	}
+
+	if failover := proxyConfig.Failover; failover.IsEnabled() {
+		demuxReq.Failover = demux.Failover{
+			Restarts:     failover.Restarts,
+			StallTimeout: time.Duration(failover.StallTimeout),
+		}
+		demuxReq.Fallbacks = s.failoverSources(r, manager, client, data, streamIndex)
 	}
 
 	reader, err := s.demux.GetReader(ctx, demuxReq)
 	if errors.Is(err, demux.ErrSubscriptionSemaphore) {
 		s.handleSubscriptionError(ctx, streamIndex)
-		return streamResult{false, true, false}
+		return streamResult{false, true, false, false}
+	}
+	if errors.Is(err, demux.ErrChannelLimit) {
+		logging.Error(ctx, err, "failed to get stream - concurrency group limit", "stream_index", streamIndex,
+			"concurrency_group", stream.Group)
+		metrics.IncStreamsFailures(ctx, metrics.FailureReasonChannelLimit)
+		return streamResult{false, true, false, false}
+	}
+	if errors.Is(err, demux.ErrNoFreeAccount) {
+		logging.Error(ctx, err, "failed to get stream - all accounts busy", "stream_index", streamIndex)
+		metrics.IncStreamsFailures(ctx, metrics.FailureReasonAccountLimit)
+		return streamResult{false, true, false, false}
 	}
 	if err != nil {
 		logging.Error(ctx, err, "failed to get stream", "stream_index", streamIndex)
-		return streamResult{false, false, false}
+		return streamResult{false, false, false, false}
 	}
 	defer func() { _ = reader.Close() }()
 
 	logging.Debug(ctx, "started stream", "stream_index", streamIndex)
-	return s.streamToResponse(ctx, w, reader)
+	return s.streamToResponse(ctx, w, reader, probe.New(proxyConfig.Probe))
+}
+
+// failoverSources lists the other sources of the channel, starting after the current one,
+// for the demuxer to switch to when the running stream fails.
+func (s *Server) failoverSources(
+	r *http.Request, manager *app.Manager, client *app.Client, data *urlgen.Data, streamIndex int) []demux.Source {
+
+	streams := data.StreamData.Streams
+
+	var sources []demux.Source
+	for i := 1; i < len(streams); i++ {
+		index := (streamIndex + i) % len(streams)
+		stream := streams[index]
+
+		playlist, ok := client.GetProvider(stream.ProviderInfo.ProviderType, stream.ProviderInfo.ProviderName).(*app.Playlist)
+		if !ok {
+			continue
+		}
+		playlist = playlist.WithProxyProfile(stream.ProxyProfile)
+
+		streamSource := playlist.LinkStreamer(streamVars(r, client, data, index))
+		if streamSource == nil {
+			continue
+		}
+
+		sources = append(sources, demux.Source{
+			Name:      playlist.Name(),
+			Streamer:  streamSource,
+			Semaphore: playlist.Semaphore(),
+			Group:     manager.ConcurrencyGroup(stream.Group),
+		})
+	}
+
+	return sources
+}
+
+// streamVars collects the request details passed to the commands of the given source.
+func streamVars(r *http.Request, client *app.Client, data *urlgen.Data, streamIndex int) app.StreamVars {
+	vars := app.StreamVars{
+		ClientName:  client.Name(),
+		ChannelName: data.StreamData.ChannelName,
+		UserAgent:   r.UserAgent(),
+		Query:       make(map[string]string),
+		SourceIndex: streamIndex,
+	}
+
+	for key, values := range r.URL.Query() {
+		vars.Query[key] = values[0]
+	}
+
+	if streamIndex < len(data.StreamData.Streams) {
+		stream := data.StreamData.Streams[streamIndex]
+		vars.URL = buildStreamURL(stream.URL, r.URL.RawQuery)
+		vars.Attrs = stream.Attrs
+		vars.Tags = stream.Tags
+	}
+
+	return vars
 }
 
 func (s *Server) handleSubscriptionError(ctx context.Context, streamIndex int) {
@@ -192,29 +354,75 @@ func (s *Server) handleSubscriptionError(ctx context.Context, streamIndex int) {
 }
 
 func (s *Server) streamToResponse(
-	ctx context.Context, w http.ResponseWriter, reader io.ReadCloser) streamResult {
+	ctx context.Context, w http.ResponseWriter, reader io.ReadCloser, p probe.Probe) streamResult {
 
 	metrics.IncClientStreamsActive(ctx)
 	defer metrics.DecClientStreamsActive(ctx)
 
+	// The first bytes are checked before anything is sent, so a source returning garbage can still be skipped.
+	var src io.Reader = reader
+	if p != nil {
+		head := make([]byte, p.Size())
+		n, err := io.ReadFull(reader, head)
+		ended := errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF)
+		if n > 0 && (err == nil || ended) {
+			if err := p.Check(head[:n]); err != nil {
+				logging.Error(ctx, err, "stream failed probe", "bytes", n)
+				metrics.IncStreamsFailures(ctx, metrics.FailureReasonInvalidData)
+				closeReader(reader, err)
+				return streamResult{false, false, true, false}
+			}
+		}
+		src = io.MultiReader(bytes.NewReader(head[:n]), reader)
+	}
+
 	w.Header().Set("Content-Type", streamContentType)
-	written, err := io.Copy(w, reader)
+	written, err := io.Copy(w, src)
+	if err != nil && !isClientDisconnect(err) {
+		closeReader(reader, err)
+	}
+
+	if errors.Is(err, demux.ErrDisconnected) {
+		logging.Info(ctx, "stream disconnected by administrator")
+		return streamResult{true, false, false, false}
+	}
+
+	if errors.Is(err, demux.ErrPreempted) {
+		logging.Info(ctx, "stream preempted by a client with higher priority")
+		metrics.IncStreamsFailures(ctx, metrics.FailureReasonPreempted)
+		return streamResult{false, false, false, true}
+	}
+
+	if errors.Is(err, demux.ErrSlowClient) {
+		return streamResult{true, false, false, false}
+	}
 
 	if err == nil && written == 0 {
 		logging.Error(ctx, errors.New("no data written to response"), "")
 		metrics.IncStreamsFailures(ctx, metrics.FailureReasonUpstreamError)
-		return streamResult{false, false, true}
+		return streamResult{false, false, true, false}
 	}
 
 	if err != nil && !isClientDisconnect(err) {
 		logging.Error(ctx, err, "error copying stream to response")
 	}
 
-	return streamResult{true, false, false}
+	return streamResult{true, false, false, false}
+}
+
+// closeReader closes the stream reader with the error the client failed with,
+// so the demuxer stops the stream instead of keeping it lingering for the client to come back.
+func closeReader(reader io.ReadCloser, err error) {
+	if ec, ok := reader.(interface{ CloseWithError(error) error }); ok {
+		_ = ec.CloseWithError(err)
+		return
+	}
+	_ = reader.Close()
 }
 
 func isClientDisconnect(err error) bool {
-	return errors.Is(err, io.ErrClosedPipe) ||
+	return errors.Is(err, context.Canceled) ||
+		errors.Is(err, io.ErrClosedPipe) ||
 		errors.Is(err, syscall.EPIPE) ||
 		errors.Is(err, syscall.ECONNRESET)
 }
@@ -0,0 +1,88 @@
+package server
+
+import (
+	"context"
+	"net/http"
+	"sync"
+
+	"majmun/internal/logging"
+	"majmun/internal/shell"
+)
+
+// waitingSlate streams the waiting response to a client queued for a concurrency slot.
+// It is stopped as soon as anything else is written to the client.
+type waitingSlate struct {
+	ctx      context.Context
+	w        http.ResponseWriter
+	streamer *shell.Streamer
+
+	mu      sync.Mutex
+	stopped bool
+	cancel  context.CancelFunc
+	done    chan struct{}
+}
+
+func (ws *waitingSlate) start() {
+	ws.mu.Lock()
+	defer ws.mu.Unlock()
+
+	if ws.stopped || ws.done != nil {
+		return
+	}
+
+	ctx, cancel := context.WithCancel(ws.ctx)
+	ws.cancel = cancel
+	ws.done = make(chan struct{})
+	ws.w.Header().Set("Content-Type", streamContentType)
+
+	logging.Debug(ctx, "streaming waiting response")
+
+	go func() {
+		defer close(ws.done)
+
+		// The command is restarted until the wait is over, unless it fails or produces nothing.
+		for ctx.Err() == nil {
+			n, err := ws.streamer.Stream(ctx, ws.w)
+			if err != nil && ctx.Err() == nil {
+				logging.Error(ctx, err, "failed to stream waiting response")
+				return
+			}
+			if n == 0 {
+				return
+			}
+		}
+	}()
+}
+
+func (ws *waitingSlate) stop() {
+	ws.mu.Lock()
+	ws.stopped = true
+	cancel, done := ws.cancel, ws.done
+	ws.mu.Unlock()
+
+	if cancel != nil {
+		cancel()
+		<-done
+	}
+}
+
+// slateResponseWriter stops the waiting slate before the client gets the stream or an error response.
+type slateResponseWriter struct {
+	http.ResponseWriter
+	slate *waitingSlate
+}
+
+func (w *slateResponseWriter) Header() http.Header {
+	w.slate.stop()
+	return w.ResponseWriter.Header()
+}
+
+func (w *slateResponseWriter) Write(p []byte) (int, error) {
+	w.slate.stop()
+	return w.ResponseWriter.Write(p)
+}
+
+func (w *slateResponseWriter) WriteHeader(statusCode int) {
+	w.slate.stop()
+	w.ResponseWriter.WriteHeader(statusCode)
+}
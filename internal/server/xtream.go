@@ -0,0 +1,215 @@
+package server
+
+import (
+	"context"
+	"majmun/internal/app"
+	"majmun/internal/ctxutil"
+	"majmun/internal/listing/m3u8"
+	"majmun/internal/logging"
+	"majmun/internal/metrics"
+	"majmun/internal/xtream"
+	"net/http"
+	"net/url"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+const (
+	xtreamUsernameParam = "username"
+	xtreamPasswordParam = "password"
+	xtreamActionParam   = "action"
+	xtreamCategoryParam = "category_id"
+
+	muxXtreamStreamVar = "stream"
+)
+
+const (
+	xtreamActionLiveCategories = "get_live_categories"
+	xtreamActionLiveStreams    = "get_live_streams"
+	xtreamActionShortEPG       = "get_short_epg"
+	xtreamActionSimpleEPG      = "get_simple_data_table"
+)
+
+func (s *Server) setupXtreamRoutes() {
+	xtreamRouter := s.router.NewRoute().Subrouter()
+	xtreamRouter.Use(s.xtreamAuthMiddleware)
+	xtreamRouter.HandleFunc("/player_api.php", s.handleXtreamPlayerAPI)
+	xtreamRouter.HandleFunc("/get.php", s.handlePlaylist)
+	xtreamRouter.HandleFunc("/xmltv.php", s.handleEPG)
+	xtreamRouter.HandleFunc(
+		"/live/{"+xtreamUsernameParam+"}/{"+xtreamPasswordParam+"}/{"+muxXtreamStreamVar+"}", s.handleXtreamLive)
+}
+
+func (s *Server) xtreamAuthMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		vars := mux.Vars(r)
+
+		username, ok := vars[xtreamUsernameParam]
+		if !ok {
+			username = r.FormValue(xtreamUsernameParam)
+		}
+		password, ok := vars[xtreamPasswordParam]
+		if !ok {
+			password = r.FormValue(xtreamPasswordParam)
+		}
+
+		if username == "" || password == "" {
+			logging.Debug(r.Context(), "xtream authentication failed: no credentials")
+			http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+			return
+		}
+
+		client := s.manager.Load().Client(password)
+		if client == nil || client.Name() != username {
+			logging.Debug(r.Context(), "xtream authentication failed: invalid credentials", "username", username)
+			http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+			return
+		}
+
+		ctx := ctxutil.WithClient(r.Context(), client)
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+func (s *Server) handleXtreamPlayerAPI(w http.ResponseWriter, r *http.Request) {
+	ctx := ctxutil.WithRequestType(r.Context(), metrics.RequestTypePlaylist)
+	action := r.FormValue(xtreamActionParam)
+
+	logging.Debug(ctx, "xtream api request", "action", action)
+
+	switch action {
+	case "":
+		writeJSON(w, r, s.xtreamAuthResponse(ctx, r))
+	case xtreamActionLiveCategories, xtreamActionLiveStreams:
+		catalog, err := s.xtreamCatalog(ctx)
+		if err != nil {
+			logging.Error(ctx, err, "failed to build xtream catalog")
+			http.Error(w, http.StatusText(http.StatusBadGateway), http.StatusBadGateway)
+			return
+		}
+
+		if action == xtreamActionLiveCategories {
+			writeJSON(w, r, catalog.Categories())
+			return
+		}
+
+		writeJSON(w, r, catalog.Streams(r.FormValue(xtreamCategoryParam)))
+		metrics.IncListingDownload(ctx)
+	case xtreamActionShortEPG, xtreamActionSimpleEPG:
+		writeJSON(w, r, xtream.ShortEPG{EPGListings: []any{}})
+	default:
+		writeJSON(w, r, []any{})
+	}
+}
+
+func (s *Server) handleXtreamLive(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	name := mux.Vars(r)[muxXtreamStreamVar]
+	streamID, err := strconv.Atoi(strings.TrimSuffix(name, path.Ext(name)))
+	if err != nil {
+		http.Error(w, http.StatusText(http.StatusNotFound), http.StatusNotFound)
+		return
+	}
+
+	catalog, err := s.xtreamCatalog(ctx)
+	if err != nil {
+		logging.Error(ctx, err, "failed to build xtream catalog")
+		http.Error(w, http.StatusText(http.StatusBadGateway), http.StatusBadGateway)
+		return
+	}
+
+	streamURL, ok := catalog.StreamURL(streamID)
+	if !ok {
+		logging.Debug(ctx, "xtream stream not found", "stream_id", streamID)
+		http.Error(w, http.StatusText(http.StatusNotFound), http.StatusNotFound)
+		return
+	}
+
+	if token, ok := s.streamToken(streamURL); ok {
+		r = mux.SetURLVars(r, map[string]string{muxEncryptedTokenVar: token})
+		s.proxyAuthMiddleware(http.HandlerFunc(s.handleProxy)).ServeHTTP(w, r)
+		return
+	}
+
+	http.Redirect(w, r, streamURL.String(), http.StatusFound)
+}
+
+func (s *Server) xtreamCatalog(ctx context.Context) (*xtream.Catalog, error) {
+	client := ctxutil.Client(ctx).(*app.Client)
+
+	streamer := m3u8.NewStreamer(
+		client.PlaylistProviders(),
+		client.EPGLink(),
+		s.httpClient,
+		client.ChannelProcessor(),
+		client.PlaylistProcessor(),
+	)
+
+	channels, err := streamer.Channels(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	return xtream.NewCatalog(channels), nil
+}
+
+func (s *Server) xtreamAuthResponse(ctx context.Context, r *http.Request) xtream.AuthResponse {
+	client := ctxutil.Client(ctx).(*app.Client)
+	now := time.Now()
+
+	var activeConnections, maxConnections int64
+	if sem := client.Semaphore(); sem != nil {
+		activeConnections, maxConnections = sem.InUse(), sem.Size()
+	}
+
+	serverInfo := &xtream.ServerInfo{
+		Timezone:     now.Location().String(),
+		TimestampNow: now.Unix(),
+		TimeNow:      now.Format(time.DateTime),
+	}
+	if u, err := url.Parse(s.serverURL); err == nil {
+		port := u.Port()
+		if port == "" {
+			port = "80"
+			if u.Scheme == "https" {
+				port = "443"
+			}
+		}
+
+		serverInfo.URL = u.Hostname()
+		serverInfo.Port = port
+		serverInfo.ServerProtocol = u.Scheme
+		if u.Scheme == "https" {
+			serverInfo.HTTPSPort = port
+		}
+	}
+
+	return xtream.AuthResponse{
+		UserInfo: xtream.UserInfo{
+			Username:             client.Name(),
+			Password:             r.FormValue(xtreamPasswordParam),
+			Auth:                 1,
+			Status:               xtream.StatusActive,
+			IsTrial:              "0",
+			ActiveConnections:    strconv.FormatInt(activeConnections, 10),
+			CreatedAt:            strconv.FormatInt(now.Unix(), 10),
+			MaxConnections:       strconv.FormatInt(maxConnections, 10),
+			AllowedOutputFormats: []string{"ts", "m3u8"},
+		},
+		ServerInfo: serverInfo,
+	}
+}
+
+// streamToken returns the encrypted token of a proxied MPEG-TS link, so the stream can be served in place.
+// HLS links are redirected instead, because their segments are resolved relative to the playlist URL.
+func (s *Server) streamToken(u *url.URL) (string, bool) {
+	if !strings.HasPrefix(u.String(), s.serverURL+"/") || path.Ext(u.Path) != ".ts" {
+		return "", false
+	}
+	return path.Base(path.Dir(u.Path)), true
+}
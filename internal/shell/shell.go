@@ -4,13 +4,16 @@ import (
 	"bufio"
 	"bytes"
 	"context"
+	"errors"
 	"fmt"
 	"io"
 	"majmun/internal/config/common"
+	"majmun/internal/ioutil"
 	"majmun/internal/logging"
 	"os"
 	"os/exec"
 	"text/template"
+	"time"
 
 	"github.com/Masterminds/sprig/v3"
 )
@@ -20,19 +23,25 @@ const (
 	bufferSize          = 64 * 1024
 )
 
+var ErrNoData = fmt.Errorf("no data from stream command: %w", ioutil.ErrReaderTimeout)
+
 type Streamer struct {
-	cmdTmpl  []*template.Template
-	envVars  []string
-	tmplVars map[string]any
+	cmdTmpl       []*template.Template
+	envVars       []string
+	tmplVars      map[string]any
+	noDataTimeout time.Duration
 }
 
-func NewShellStreamer(command []string, envVars []common.NameValue, tmplVars []common.NameValue) (*Streamer, error) {
+func NewShellStreamer(
+	command []string, envVars []common.NameValue, tmplVars []common.NameValue, noDataTimeout time.Duration) (*Streamer, error) {
 	cmdTmpl := make([]*template.Template, 0, len(command))
 
 	for _, cmdPart := range command {
+		// Missing channel attributes, tags and query parameters render as empty strings.
 		tmpl, err := template.
 			New("").
 			Funcs(sprig.FuncMap()).
+			Option("missingkey=zero").
 			Parse(cmdPart)
 
 		if err != nil {
@@ -52,17 +61,19 @@ func NewShellStreamer(command []string, envVars []common.NameValue, tmplVars []c
 	}
 
 	return &Streamer{
-		cmdTmpl:  cmdTmpl,
-		envVars:  environ,
-		tmplVars: tmplVarMap,
+		cmdTmpl:       cmdTmpl,
+		envVars:       environ,
+		tmplVars:      tmplVarMap,
+		noDataTimeout: noDataTimeout,
 	}, nil
 }
 
 func (s *Streamer) WithTemplateVars(templateVars map[string]any) *Streamer {
 	clone := &Streamer{
-		cmdTmpl:  s.cmdTmpl,
-		envVars:  s.envVars,
-		tmplVars: make(map[string]any),
+		cmdTmpl:       s.cmdTmpl,
+		envVars:       s.envVars,
+		tmplVars:      make(map[string]any),
+		noDataTimeout: s.noDataTimeout,
 	}
 
 	if s.tmplVars != nil {
@@ -78,15 +89,27 @@ func (s *Streamer) WithTemplateVars(templateVars map[string]any) *Streamer {
 	return clone
 }
 
+// Command renders the command with the template variables of the streamer.
+func (s *Streamer) Command() ([]string, error) {
+	return s.renderCommand(s.tmplVars)
+}
+
 func (s *Streamer) Stream(ctx context.Context, w io.Writer) (int64, error) {
-	commandParts, err := s.renderCommand(s.tmplVars)
+	commandParts, err := s.Command()
 	if err != nil {
 		return 0, err
 	}
+	if len(commandParts) == 0 {
+		return 0, errors.New("command is empty")
+	}
 
 	ctx, cancel := context.WithCancel(ctx)
 	defer cancel()
 
+	// The watchdog kills the command through the context when it produces no data for the no data timeout.
+	ctx, watchdog := ioutil.NewWatchdog(ctx, s.noDataTimeout, ErrNoData)
+	defer watchdog.Stop()
+
 	run := exec.Command(commandParts[0], commandParts[1:]...)
 
 	go func() {
@@ -127,23 +150,25 @@ func (s *Streamer) Stream(ctx context.Context, w io.Writer) (int64, error) {
 	buf := make([]byte, bufferSize)
 	bytesWritten := int64(0)
 
-	for {
-		if ctx.Err() != nil {
-			return bytesWritten, nil
-		}
-
+	for ctx.Err() == nil {
 		n, err := stdout.Read(buf)
-		if err != nil {
-			return bytesWritten, nil
-		}
-
 		if n > 0 {
+			watchdog.Kick()
 			bytesWritten += int64(n)
 			if _, writeErr := w.Write(buf[:n]); writeErr != nil {
 				return bytesWritten, nil
 			}
 		}
+		if err != nil {
+			break
+		}
+	}
+
+	if errors.Is(context.Cause(ctx), ErrNoData) {
+		logging.Info(ctx, "no data from stream command, stopping it", "timeout", s.noDataTimeout)
+		return bytesWritten, ErrNoData
 	}
+	return bytesWritten, nil
 }
 
 func (s *Streamer) renderCommand(tmplVars map[string]any) ([]string, error) {
@@ -55,12 +55,17 @@ type ProviderInfo struct {
 type StreamData struct {
 	ChannelName string   `json:"cn"`
 	Streams     []Stream `json:"s"`
+	HLS         bool     `json:"hls,omitempty"`
 }
 
 type Stream struct {
-	ProviderInfo ProviderInfo `json:"pi"`
-	URL          string       `json:"u"`
-	Hidden       bool         `json:"h,omitempty"`
+	ProviderInfo ProviderInfo      `json:"pi"`
+	URL          string            `json:"u"`
+	Hidden       bool              `json:"h,omitempty"`
+	Attrs        map[string]string `json:"a,omitempty"`
+	Tags         map[string]string `json:"t,omitempty"`
+	ProxyProfile string            `json:"pp,omitempty"`
+	Group        string            `json:"cg,omitempty"`
 }
 
 type FileData struct {
@@ -89,6 +94,14 @@ func (g *Generator) CreateStreamURL(channelName string, streams []Stream) (*url.
 	})
 }
 
+func (g *Generator) CreateHLSStreamURL(channelName string, streams []Stream) (*url.URL, error) {
+	return g.CreateURL(Data{
+		RequestType: RequestTypeStream,
+		StreamData:  StreamData{ChannelName: channelName, Streams: streams, HLS: true},
+		CreatedAt:   time.Now().Unix(),
+	})
+}
+
 func (g *Generator) CreateFileURL(providerInfo ProviderInfo, fileURL string) (*url.URL, error) {
 	return g.CreateURL(Data{
 		RequestType: RequestTypeFile,
@@ -150,11 +163,12 @@ func (g *Generator) Decrypt(token string) (*Data, error) {
 			ttl = g.fileTTL
 		}
 
+		// Expired data is still returned, so the expired link response can describe the channel.
 		if ttl > 0 && createdTime.Add(ttl).Before(time.Now()) {
 			if data.RequestType == RequestTypeStream {
-				return nil, ErrExpiredStreamURL
+				return &data, ErrExpiredStreamURL
 			}
-			return nil, ErrExpiredFileURL
+			return &data, ErrExpiredFileURL
 		}
 	}
 
@@ -163,6 +177,9 @@ func (g *Generator) Decrypt(token string) (*Data, error) {
 
 func determineExtension(d Data) string {
 	if d.RequestType == RequestTypeStream {
+		if d.StreamData.HLS {
+			return ".m3u8"
+		}
 		return ".ts"
 	}
 
@@ -2,6 +2,7 @@ package urlgen
 
 import (
 	"errors"
+	"reflect"
 	"strings"
 	"testing"
 	"time"
@@ -36,6 +37,22 @@ func TestGenerator_CreateURL(t *testing.T) {
 			wantExt: ".ts",
 			wantErr: false,
 		},
+		{
+			name: "hls stream request",
+			data: Data{
+				RequestType: RequestTypeStream,
+				StreamData: StreamData{
+					ChannelName: "1",
+					Streams: []Stream{{
+						URL: "https://stream.example.com/video",
+					}},
+					HLS: true,
+				},
+			},
+			ttl:     time.Hour,
+			wantExt: ".m3u8",
+			wantErr: false,
+		},
 		{
 			name: "file request with extension",
 			data: Data{
@@ -105,6 +122,9 @@ func TestGenerator_CreateURL(t *testing.T) {
 			}
 
 			if tt.data.RequestType == RequestTypeStream {
+				if decrypted.StreamData.HLS != tt.data.StreamData.HLS {
+					t.Errorf("decrypted HLS = %v, want %v", decrypted.StreamData.HLS, tt.data.StreamData.HLS)
+				}
 				if decrypted.StreamData.ChannelName != tt.data.StreamData.ChannelName {
 					t.Errorf("decrypted channelID = %v, want %v", decrypted.StreamData.ChannelName, tt.data.StreamData.ChannelName)
 				}
@@ -144,6 +164,8 @@ func TestGenerator_CreateStreamURL(t *testing.T) {
 			ProviderInfo: ProviderInfo{ProviderType: ProviderTypePlaylist, ProviderName: "test2"},
 			URL:          "https://stream2.example.com/video",
 			Hidden:       true,
+			Attrs:        map[string]string{"tvg-id": "one"},
+			Tags:         map[string]string{"EXTVLCOPT": "http-user-agent=VLC"},
 		},
 	}
 
@@ -181,7 +203,7 @@ func TestGenerator_CreateStreamURL(t *testing.T) {
 	}
 
 	for i, expected := range streams {
-		if decrypted.StreamData.Streams[i] != expected {
+		if !reflect.DeepEqual(decrypted.StreamData.Streams[i], expected) {
 			t.Errorf("Stream %d mismatch: got %+v, want %+v", i, decrypted.StreamData.Streams[i], expected)
 		}
 	}
@@ -329,7 +351,7 @@ func TestGenerator_ExpiredLinkDecryption(t *testing.T) {
 		parts := strings.Split(u.Path, "/")
 		token := parts[len(parts)-2]
 
-		_, err = g.Decrypt(token)
+		decrypted, err := g.Decrypt(token)
 		if err == nil {
 			t.Error("expected error when decrypting expired token")
 		}
@@ -337,6 +359,10 @@ func TestGenerator_ExpiredLinkDecryption(t *testing.T) {
 		if !errors.Is(err, ErrExpiredStreamURL) {
 			t.Errorf("expected ErrExpiredStreamURL, got %v", err)
 		}
+
+		if decrypted == nil || decrypted.StreamData.ChannelName != "1" {
+			t.Errorf("expected expired data to be returned, got %v", decrypted)
+		}
 	})
 
 	t.Run("valid token with zero TTL", func(t *testing.T) {
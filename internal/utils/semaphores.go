@@ -3,18 +3,202 @@ package utils
 import (
 	"context"
 	"errors"
+	"majmun/internal/config/proxy"
 	"majmun/internal/ctxutil"
 	"majmun/internal/logging"
+	"majmun/internal/metrics"
+	"sync"
 	"time"
-
-	"golang.org/x/sync/semaphore"
 )
 
-func AcquireSemaphore(ctx context.Context, sem *semaphore.Weighted, timeout time.Duration, name string) bool {
+// Semaphore limits concurrent streams. Requests that find no free slot wait in a queue,
+// served in arrival order or by client priority, and a released slot goes to the head of the queue.
+type Semaphore struct {
+	level string
+	name  string
+	size  int64
+
+	queueTimeout time.Duration
+	queueOrder   string
+
+	mu      sync.Mutex
+	inUse   int64
+	waiters []*waiter
+}
+
+type waiter struct {
+	priority int
+	ready    chan struct{}
+}
+
+func NewSemaphore(level, name string, size int64) *Semaphore {
+	return &Semaphore{
+		level: level,
+		name:  name,
+		size:  size,
+	}
+}
+
+// WithQueue sets how long requests may wait for a slot and the order they are served in.
+// Without a queue timeout, callers wait for their own default time.
+func (s *Semaphore) WithQueue(timeout time.Duration, order string) *Semaphore {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.queueTimeout = timeout
+	s.queueOrder = order
+	return s
+}
+
+// Reconfigure takes the size and the queue settings of other, keeping the slots in use and the queued requests.
+// A larger size hands the new slots to the queue, while a smaller one lets the streams above it run until they
+// end, with new requests waiting until the semaphore is below the new size.
+func (s *Semaphore) Reconfigure(other *Semaphore) {
+	other.mu.Lock()
+	size, timeout, order := other.size, other.queueTimeout, other.queueOrder
+	other.mu.Unlock()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.size = size
+	s.queueTimeout = timeout
+	s.queueOrder = order
+	s.serveQueue()
+}
+
+func (s *Semaphore) Acquire(ctx context.Context) error {
+	s.mu.Lock()
+	if s.inUse < s.size && len(s.waiters) == 0 {
+		s.take()
+		s.mu.Unlock()
+		return nil
+	}
+
+	if ctx.Err() != nil {
+		s.mu.Unlock()
+		return ctx.Err()
+	}
+
+	w := &waiter{ready: make(chan struct{})}
+	if s.queueOrder == proxy.QueueOrderPriority {
+		w.priority = ctxutil.Priority(ctx)
+	}
+	s.enqueue(w)
+	s.mu.Unlock()
+
+	if notify := ctxutil.WaitNotify(ctx); notify != nil {
+		notify()
+	}
+
+	select {
+	case <-w.ready:
+		return nil
+	case <-ctx.Done():
+		s.mu.Lock()
+		select {
+		case <-w.ready:
+			// The slot was handed over while the context ended, so it goes to the next waiter.
+			s.mu.Unlock()
+			s.Release()
+		default:
+			s.dequeue(w)
+			s.mu.Unlock()
+		}
+		return ctx.Err()
+	}
+}
+
+// TryAcquire takes a slot without waiting and reports whether one was free.
+func (s *Semaphore) TryAcquire() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.inUse >= s.size || len(s.waiters) > 0 {
+		return false
+	}
+	s.take()
+	return true
+}
+
+func (s *Semaphore) Release() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.inUse--
+	metrics.DecSemaphoresInUse(s.level, s.name)
+
+	s.serveQueue()
+}
+
+func (s *Semaphore) Size() int64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.size
+}
+
+func (s *Semaphore) InUse() int64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.inUse
+}
+
+// Queued returns the number of requests waiting for a slot.
+func (s *Semaphore) Queued() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.waiters)
+}
+
+// serveQueue hands the free slots to the head of the queue.
+func (s *Semaphore) serveQueue() {
+	for s.inUse < s.size && len(s.waiters) > 0 {
+		w := s.waiters[0]
+		s.dequeue(w)
+		s.take()
+		close(w.ready)
+	}
+}
+
+func (s *Semaphore) take() {
+	s.inUse++
+	metrics.IncSemaphoresInUse(s.level, s.name)
+}
+
+// enqueue adds the waiter behind every waiter of the same or higher priority.
+func (s *Semaphore) enqueue(w *waiter) {
+	i := len(s.waiters)
+	for i > 0 && s.waiters[i-1].priority < w.priority {
+		i--
+	}
+	s.waiters = append(s.waiters, nil)
+	copy(s.waiters[i+1:], s.waiters[i:])
+	s.waiters[i] = w
+	metrics.IncSemaphoresQueued(s.level, s.name)
+}
+
+func (s *Semaphore) dequeue(w *waiter) {
+	for i, other := range s.waiters {
+		if other == w {
+			s.waiters = append(s.waiters[:i], s.waiters[i+1:]...)
+			metrics.DecSemaphoresQueued(s.level, s.name)
+			return
+		}
+	}
+}
+
+// AcquireSemaphore waits for a slot for the given time, or for the queue timeout of the semaphore when it has one.
+func AcquireSemaphore(ctx context.Context, sem *Semaphore, timeout time.Duration, name string) bool {
 	if sem == nil {
 		return true
 	}
 
+	sem.mu.Lock()
+	if sem.queueTimeout > 0 {
+		timeout = sem.queueTimeout
+	}
+	sem.mu.Unlock()
+
 	semCtx, cancel := context.WithTimeout(ctx, timeout)
 	semCtx = ctxutil.WithSemaphoreName(semCtx, name)
 
@@ -22,7 +206,7 @@ func AcquireSemaphore(ctx context.Context, sem *semaphore.Weighted, timeout time
 
 	logging.Debug(ctx, "acquiring semaphore")
 
-	if err := sem.Acquire(semCtx, 1); err != nil {
+	if err := sem.Acquire(semCtx); err != nil {
 		if errors.Is(err, context.DeadlineExceeded) {
 			logging.Info(ctx, "semaphore acquisition timeout")
 		} else {
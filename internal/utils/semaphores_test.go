@@ -0,0 +1,184 @@
+package utils
+
+import (
+	"context"
+	"majmun/internal/config/proxy"
+	"majmun/internal/ctxutil"
+	"majmun/internal/metrics"
+	"testing"
+	"time"
+)
+
+type priorityClient int
+
+func (p priorityClient) Priority() int {
+	return int(p)
+}
+
+// queueWaiters starts a waiter per priority, in order, and returns the order they acquire the semaphore in.
+func queueWaiters(t *testing.T, sem *Semaphore, priorities ...int) <-chan int {
+	t.Helper()
+
+	acquired := make(chan int, len(priorities))
+	for i, priority := range priorities {
+		ctx := ctxutil.WithClient(context.Background(), priorityClient(priority))
+		go func() {
+			if sem.Acquire(ctx) == nil {
+				acquired <- priority
+			}
+		}()
+
+		deadline := time.Now().Add(time.Second)
+		for sem.Queued() != i+1 {
+			if time.Now().After(deadline) {
+				t.Fatalf("waiter %d was not queued", i)
+			}
+			time.Sleep(time.Millisecond)
+		}
+	}
+	return acquired
+}
+
+func TestSemaphoreQueueOrder(t *testing.T) {
+	tests := []struct {
+		name     string
+		order    string
+		expected []int
+	}{
+		{name: "fifo", order: proxy.QueueOrderFIFO, expected: []int{0, 10, 5}},
+		{name: "priority", order: proxy.QueueOrderPriority, expected: []int{10, 5, 0}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sem := NewSemaphore("test", tt.name, 1).WithQueue(time.Second, tt.order)
+			if !sem.TryAcquire() {
+				t.Fatal("TryAcquire() = false on a free semaphore")
+			}
+
+			acquired := queueWaiters(t, sem, 0, 10, 5)
+
+			for _, expected := range tt.expected {
+				sem.Release()
+				if got := <-acquired; got != expected {
+					t.Fatalf("acquired by priority %d, expected %d", got, expected)
+				}
+			}
+		})
+	}
+}
+
+func TestAcquireSemaphoreQueueTimeout(t *testing.T) {
+	sem := NewSemaphore("test", "timeout", 1).WithQueue(50*time.Millisecond, proxy.QueueOrderFIFO)
+	if !sem.TryAcquire() {
+		t.Fatal("TryAcquire() = false on a free semaphore")
+	}
+
+	start := time.Now()
+	if AcquireSemaphore(context.Background(), sem, time.Minute, "test") {
+		t.Fatal("AcquireSemaphore() = true on a full semaphore")
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("AcquireSemaphore() waited %v instead of the queue timeout", elapsed)
+	}
+	if sem.Queued() != 0 {
+		t.Errorf("Queued() = %d after the timeout, expected 0", sem.Queued())
+	}
+
+	sem.Release()
+	if !sem.TryAcquire() {
+		t.Error("TryAcquire() = false after release")
+	}
+}
+
+func TestSemaphoreWaitNotify(t *testing.T) {
+	sem := NewSemaphore("test", "notify", 1)
+	if !sem.TryAcquire() {
+		t.Fatal("TryAcquire() = false on a free semaphore")
+	}
+
+	notified := make(chan struct{}, 1)
+	ctx := ctxutil.WithWaitNotify(context.Background(), func() { notified <- struct{}{} })
+
+	go func() {
+		<-notified
+		sem.Release()
+	}()
+
+	if !AcquireSemaphore(ctx, sem, time.Second, "test") {
+		t.Fatal("AcquireSemaphore() = false after the slot was released")
+	}
+}
+
+func TestSemaphoreQueuedMetricSharedLabels(t *testing.T) {
+	queued := func() float64 {
+		families, err := metrics.Registry.Gather()
+		if err != nil {
+			t.Fatalf("Gather() error = %v", err)
+		}
+		for _, family := range families {
+			if family.GetName() != "iptv_semaphores_queued" {
+				continue
+			}
+			for _, m := range family.GetMetric() {
+				for _, label := range m.GetLabel() {
+					if label.GetName() == "name" && label.GetValue() == "shared-queue" {
+						return m.GetGauge().GetValue()
+					}
+				}
+			}
+		}
+		return 0
+	}
+
+	// Playlist semaphores are created per client with the same labels.
+	first := NewSemaphore(metrics.SemaphoreLevelPlaylist, "shared-queue", 1)
+	second := NewSemaphore(metrics.SemaphoreLevelPlaylist, "shared-queue", 1)
+
+	for _, sem := range []*Semaphore{first, second} {
+		if !sem.TryAcquire() {
+			t.Fatal("TryAcquire() = false on a free semaphore")
+		}
+		queueWaiters(t, sem, 0)
+	}
+
+	if got := queued(); got != 2 {
+		t.Errorf("queued gauge = %v, expected 2", got)
+	}
+
+	first.Release()
+	if got := queued(); got != 1 {
+		t.Errorf("queued gauge = %v after a waiter acquired, expected 1", got)
+	}
+
+	second.Release()
+	if got := queued(); got != 0 {
+		t.Errorf("queued gauge = %v after all waiters acquired, expected 0", got)
+	}
+}
+
+func TestSemaphoreReconfigure(t *testing.T) {
+	sem := NewSemaphore("test", "reconfigure", 2)
+	for range 2 {
+		if !sem.TryAcquire() {
+			t.Fatal("TryAcquire() = false on a free semaphore")
+		}
+	}
+
+	sem.Reconfigure(NewSemaphore("test", "reconfigure", 1))
+	sem.Release()
+	if sem.TryAcquire() {
+		t.Fatal("TryAcquire() = true while the slots in use reach the smaller size")
+	}
+
+	acquired := queueWaiters(t, sem, 0)
+	sem.Reconfigure(NewSemaphore("test", "reconfigure", 2))
+	select {
+	case <-acquired:
+	case <-time.After(time.Second):
+		t.Fatal("queued request did not get the slot added by Reconfigure()")
+	}
+	if sem.InUse() != 2 {
+		t.Errorf("InUse() = %d, expected 2", sem.InUse())
+	}
+}
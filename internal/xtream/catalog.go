@@ -0,0 +1,116 @@
+package xtream
+
+import (
+	"hash/fnv"
+	"majmun/internal/listing/m3u8/store"
+	"majmun/internal/parser/m3u8"
+	"net/url"
+	"strconv"
+)
+
+const defaultCategoryName = "Uncategorized"
+
+type Catalog struct {
+	categories []Category
+	streams    []LiveStream
+	uris       map[int]*url.URL
+}
+
+func NewCatalog(channels []*store.Channel) *Catalog {
+	c := &Catalog{
+		uris: make(map[int]*url.URL, len(channels)),
+	}
+
+	categoryIDs := make(map[string]string)
+
+	for _, ch := range channels {
+		if ch.URI() == nil {
+			continue
+		}
+
+		categoryName, _ := ch.GetAttr(m3u8.AttrGroupTitle)
+		if categoryName == "" {
+			categoryName = defaultCategoryName
+		}
+
+		categoryID, exists := categoryIDs[categoryName]
+		if !exists {
+			categoryID = strconv.Itoa(uniqueID(categoryName, func(id int) bool {
+				return c.hasCategory(strconv.Itoa(id))
+			}))
+			categoryIDs[categoryName] = categoryID
+			c.categories = append(c.categories, Category{
+				CategoryID:   categoryID,
+				CategoryName: categoryName,
+			})
+		}
+
+		key := ch.ID()
+		if key == "" {
+			key = ch.Name()
+		}
+
+		streamID := uniqueID(key, func(id int) bool {
+			_, taken := c.uris[id]
+			return taken
+		})
+		c.uris[streamID] = ch.URI()
+
+		logo, _ := ch.GetAttr(m3u8.AttrTvgLogo)
+		c.streams = append(c.streams, LiveStream{
+			Num:          len(c.streams) + 1,
+			Name:         ch.Name(),
+			StreamType:   StreamLive,
+			StreamID:     streamID,
+			StreamIcon:   logo,
+			EPGChannelID: ch.ID(),
+			CategoryID:   categoryID,
+		})
+	}
+
+	return c
+}
+
+func (c *Catalog) Categories() []Category {
+	if c.categories == nil {
+		return []Category{}
+	}
+	return c.categories
+}
+
+func (c *Catalog) Streams(categoryID string) []LiveStream {
+	result := make([]LiveStream, 0, len(c.streams))
+	for _, s := range c.streams {
+		if categoryID == "" || s.CategoryID == categoryID {
+			result = append(result, s)
+		}
+	}
+	return result
+}
+
+func (c *Catalog) StreamURL(streamID int) (*url.URL, bool) {
+	u, ok := c.uris[streamID]
+	return u, ok
+}
+
+func (c *Catalog) hasCategory(id string) bool {
+	for _, category := range c.categories {
+		if category.CategoryID == id {
+			return true
+		}
+	}
+	return false
+}
+
+// uniqueID derives a stable positive ID from key, probing forward on collisions
+// so that the same playlist always yields the same IDs.
+func uniqueID(key string, taken func(int) bool) int {
+	h := fnv.New32a()
+	_, _ = h.Write([]byte(key))
+
+	id := int(h.Sum32() & 0x7fffffff)
+	for id == 0 || taken(id) {
+		id = (id + 1) & 0x7fffffff
+	}
+	return id
+}
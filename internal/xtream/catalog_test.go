@@ -0,0 +1,97 @@
+package xtream
+
+import (
+	"majmun/internal/listing/m3u8/store"
+	"majmun/internal/parser/m3u8"
+	"net/url"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newTestChannel(name, tvgID, group, uri string) *store.Channel {
+	track := &m3u8.Track{
+		Name:  name,
+		Attrs: map[string]string{},
+	}
+	if tvgID != "" {
+		track.Attrs[m3u8.AttrTvgID] = tvgID
+	}
+	if group != "" {
+		track.Attrs[m3u8.AttrGroupTitle] = group
+	}
+	if uri != "" {
+		track.URI, _ = url.Parse(uri)
+	}
+	return store.NewChannel(track, nil)
+}
+
+func testChannels() []*store.Channel {
+	return []*store.Channel{
+		newTestChannel("News One", "news1", "News", "http://example.com/news1"),
+		newTestChannel("Sports One", "sports1", "Sports", "http://example.com/sports1"),
+		newTestChannel("News Two", "news2", "News", "http://example.com/news2"),
+		newTestChannel("No Group", "", "", "http://example.com/nogroup"),
+		newTestChannel("No URI", "nouri", "News", ""),
+	}
+}
+
+func TestCatalogCategories(t *testing.T) {
+	catalog := NewCatalog(testChannels())
+
+	categories := catalog.Categories()
+	require.Len(t, categories, 3)
+	assert.Equal(t, "News", categories[0].CategoryName)
+	assert.Equal(t, "Sports", categories[1].CategoryName)
+	assert.Equal(t, defaultCategoryName, categories[2].CategoryName)
+
+	assert.Len(t, catalog.Streams(categories[0].CategoryID), 2)
+	assert.Len(t, catalog.Streams(categories[1].CategoryID), 1)
+	assert.Len(t, catalog.Streams(""), 4)
+	assert.Empty(t, catalog.Streams("unknown"))
+}
+
+func TestCatalogStreams(t *testing.T) {
+	catalog := NewCatalog(testChannels())
+
+	streams := catalog.Streams("")
+	for i, s := range streams {
+		assert.Equal(t, i+1, s.Num)
+		assert.Equal(t, StreamLive, s.StreamType)
+		assert.Positive(t, s.StreamID)
+
+		u, ok := catalog.StreamURL(s.StreamID)
+		require.True(t, ok)
+		assert.NotNil(t, u)
+	}
+
+	assert.Equal(t, "news1", streams[0].EPGChannelID)
+
+	u, ok := catalog.StreamURL(streams[1].StreamID)
+	require.True(t, ok)
+	assert.Equal(t, "http://example.com/sports1", u.String())
+}
+
+func TestCatalogStableIDs(t *testing.T) {
+	first := NewCatalog(testChannels())
+
+	reordered := testChannels()
+	reordered[0], reordered[2] = reordered[2], reordered[0]
+	second := NewCatalog(reordered)
+
+	ids := make(map[string]int)
+	for _, s := range first.Streams("") {
+		ids[s.Name] = s.StreamID
+	}
+	for _, s := range second.Streams("") {
+		assert.Equal(t, ids[s.Name], s.StreamID, "stream %s", s.Name)
+	}
+}
+
+func TestUniqueIDCollision(t *testing.T) {
+	id := uniqueID("channel", func(int) bool { return false })
+
+	next := uniqueID("channel", func(candidate int) bool { return candidate == id })
+	assert.Equal(t, id+1, next)
+}
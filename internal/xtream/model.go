@@ -0,0 +1,61 @@
+package xtream
+
+const (
+	StatusActive = "Active"
+	StreamLive   = "live"
+)
+
+type AuthResponse struct {
+	UserInfo   UserInfo    `json:"user_info"`
+	ServerInfo *ServerInfo `json:"server_info,omitempty"`
+}
+
+type UserInfo struct {
+	Username             string   `json:"username,omitempty"`
+	Password             string   `json:"password,omitempty"`
+	Message              string   `json:"message"`
+	Auth                 int      `json:"auth"`
+	Status               string   `json:"status,omitempty"`
+	ExpDate              *string  `json:"exp_date"`
+	IsTrial              string   `json:"is_trial,omitempty"`
+	ActiveConnections    string   `json:"active_cons,omitempty"`
+	CreatedAt            string   `json:"created_at,omitempty"`
+	MaxConnections       string   `json:"max_connections,omitempty"`
+	AllowedOutputFormats []string `json:"allowed_output_formats,omitempty"`
+}
+
+type ServerInfo struct {
+	URL            string `json:"url"`
+	Port           string `json:"port"`
+	HTTPSPort      string `json:"https_port"`
+	ServerProtocol string `json:"server_protocol"`
+	RTMPPort       string `json:"rtmp_port"`
+	Timezone       string `json:"timezone"`
+	TimestampNow   int64  `json:"timestamp_now"`
+	TimeNow        string `json:"time_now"`
+}
+
+type Category struct {
+	CategoryID   string `json:"category_id"`
+	CategoryName string `json:"category_name"`
+	ParentID     int    `json:"parent_id"`
+}
+
+type LiveStream struct {
+	Num               int    `json:"num"`
+	Name              string `json:"name"`
+	StreamType        string `json:"stream_type"`
+	StreamID          int    `json:"stream_id"`
+	StreamIcon        string `json:"stream_icon"`
+	EPGChannelID      string `json:"epg_channel_id"`
+	Added             string `json:"added"`
+	CategoryID        string `json:"category_id"`
+	CustomSID         string `json:"custom_sid"`
+	TVArchive         int    `json:"tv_archive"`
+	DirectSource      string `json:"direct_source"`
+	TVArchiveDuration int    `json:"tv_archive_duration"`
+}
+
+type ShortEPG struct {
+	EPGListings []any `json:"epg_listings"`
+}
@@ -0,0 +1,110 @@
+package xtream
+
+import (
+	"context"
+	"io"
+	"majmun/internal/parser/m3u8"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newFakePanel(t *testing.T) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		require.Equal(t, "/panel/player_api.php", r.URL.Path)
+
+		if r.FormValue("username") != "user" || r.FormValue("password") != "pass" {
+			_, _ = io.WriteString(w, `{"user_info":{"auth":0}}`)
+			return
+		}
+
+		switch r.FormValue("action") {
+		case "":
+			_, _ = io.WriteString(w, `{"user_info":{"auth":1,"status":"Active"}}`)
+		case "get_live_categories":
+			_, _ = io.WriteString(w, `[{"category_id":"1","category_name":"News"},{"category_id":2,"category_name":"Sports"}]`)
+		case "get_live_streams":
+			_, _ = io.WriteString(w, `[
+				{"num":1,"name":"News One","stream_id":101,"stream_icon":"http://logo/news.png","epg_channel_id":"news.one","category_id":"1","tv_archive":1,"tv_archive_duration":"7"},
+				{"num":2,"name":"Sports One","stream_id":"102","stream_icon":"","epg_channel_id":null,"category_id":2,"tv_archive":0,"tv_archive_duration":0},
+				{"num":3,"name":"Broken","stream_id":null}
+			]`)
+		default:
+			_, _ = io.WriteString(w, `[]`)
+		}
+	}))
+}
+
+func decodeAll(t *testing.T, d *SourceDecoder) []*m3u8.Track {
+	var tracks []*m3u8.Track
+	for {
+		item, err := d.Decode()
+		if err == io.EOF {
+			return tracks
+		}
+		require.NoError(t, err)
+		tracks = append(tracks, item.(*m3u8.Track))
+	}
+}
+
+func TestSourceDecoder(t *testing.T) {
+	srv := newFakePanel(t)
+	defer srv.Close()
+
+	source := strings.Replace(srv.URL, "http://", "xtream://user:pass@", 1) + "/panel"
+	require.True(t, IsSource(source))
+
+	d, err := NewSourceDecoder(context.Background(), srv.Client(), source)
+	require.NoError(t, err)
+
+	tracks := decodeAll(t, d)
+	require.Len(t, tracks, 2)
+
+	news := tracks[0]
+	assert.Equal(t, "News One", news.Name)
+	assert.Equal(t, srv.URL+"/panel/live/user/pass/101.ts", news.URI.String())
+	assert.Equal(t, map[string]string{
+		m3u8.AttrTvgID:       "news.one",
+		m3u8.AttrTvgLogo:     "http://logo/news.png",
+		m3u8.AttrGroupTitle:  "News",
+		m3u8.AttrCatchup:     "xc",
+		m3u8.AttrCatchupDays: "7",
+	}, news.Attrs)
+
+	sports := tracks[1]
+	assert.Equal(t, srv.URL+"/panel/live/user/pass/102.ts", sports.URI.String())
+	assert.Equal(t, map[string]string{m3u8.AttrGroupTitle: "Sports"}, sports.Attrs)
+}
+
+func TestSourceDecoderOutput(t *testing.T) {
+	srv := newFakePanel(t)
+	defer srv.Close()
+
+	source := strings.Replace(srv.URL, "http://", "xtream://user:pass@", 1) + "/panel?output=m3u8"
+
+	d, err := NewSourceDecoder(context.Background(), srv.Client(), source)
+	require.NoError(t, err)
+
+	tracks := decodeAll(t, d)
+	require.NotEmpty(t, tracks)
+	assert.Equal(t, srv.URL+"/panel/live/user/pass/101.m3u8", tracks[0].URI.String())
+}
+
+func TestSourceDecoderErrors(t *testing.T) {
+	srv := newFakePanel(t)
+	defer srv.Close()
+
+	_, err := NewSourceDecoder(context.Background(), srv.Client(),
+		strings.Replace(srv.URL, "http://", "xtream://user:wrong@", 1)+"/panel")
+	assert.ErrorContains(t, err, "authentication failed")
+
+	_, err = NewSourceDecoder(context.Background(), srv.Client(), "xtream://host-only")
+	assert.Error(t, err)
+
+	assert.False(t, IsSource("http://example.com/playlist.m3u"))
+	assert.False(t, IsSource("/path/to/playlist.m3u"))
+}